@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Stash Age
+// ---------------------------------------------------------------------------
+
+// Default age thresholds. They're copied into cfg so a config key can override them.
+const (
+	defaultNewAge   = 15 * time.Minute    // younger stashes get a subtle highlight
+	defaultStaleAge = 7 * 24 * time.Hour  // older stashes are probably forgotten
+	defaultOldAge   = 30 * 24 * time.Hour // older stashes are almost certainly junk
+)
+
+// stashAge buckets a stash by how long ago it was made
+type stashAge int
+
+const (
+	ageUnknown stashAge = iota // no timestamp, e.g. from a --from-stdin fixture
+	ageNew
+	ageRecent
+	ageStale
+	ageOld
+)
+
+var stashAgeLabel = map[stashAge]string{
+	ageNew:   "new",
+	ageStale: "stale",
+	ageOld:   "old",
+}
+
+// stashAgeColor colors list entries by age bucket; recent and unknown ages keep the
+// default delegate's colors
+var stashAgeColor = map[stashAge]lipgloss.Color{
+	ageNew:   lipgloss.Color("36"),
+	ageStale: lipgloss.Color("214"),
+	ageOld:   lipgloss.Color("241"),
+}
+
+// ageThresholds are where the stash age buckets start
+type ageThresholds struct {
+	New, Stale, Old time.Duration
+}
+
+// ageOf buckets a stash made at t
+func (a ageThresholds) ageOf(t, now time.Time) stashAge {
+	if t.IsZero() {
+		return ageUnknown
+	}
+	switch age := now.Sub(t); {
+	case age >= a.Old:
+		return ageOld
+	case age >= a.Stale:
+		return ageStale
+	case age < a.New:
+		return ageNew
+	}
+	return ageRecent
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Clean Up
+// ---------------------------------------------------------------------------
+
+// cleanupVerdict is what checking a stash against HEAD found
+type cleanupVerdict int
+
+const (
+	cleanupMerged  cleanupVerdict = iota // every change is already in HEAD
+	cleanupUnknown                       // the check itself failed, so nobody knows
+	cleanupPending                       // HEAD lacks some of the changes
+)
+
+var cleanupVerdictName = map[cleanupVerdict]string{
+	cleanupMerged:  "merged",
+	cleanupUnknown: "unknown",
+	cleanupPending: "pending",
+}
+
+// cleanupEntry is a stash in the cleanup list, with the check's verdict on it
+type cleanupEntry struct {
+	Stash
+	Verdict cleanupVerdict
+	Detail  string // git's output when the check failed
+	Marked  bool
+}
+
+func (e cleanupEntry) Title() string {
+	box := "[ ]"
+	if e.Marked {
+		box = "[x]"
+	}
+	return fmt.Sprintf("%s %s %s", box, e.Stash.Ref, e.displayMessage())
+}
+func (e cleanupEntry) Description() string {
+	return fmt.Sprintf("%s · %s", cleanupVerdictName[e.Verdict], e.Created)
+}
+func (e cleanupEntry) FilterValue() string { return e.Message }
+
+type cleanupCheckMsg struct {
+	seq   int
+	entry cleanupEntry
+}
+
+// checkStashMerged reverse-applies a stash's patch, untracked files included, to a
+// temporary index holding HEAD. If that works, HEAD already has everything the stash
+// would bring back. The working tree and the real index are never touched.
+func checkStashMerged(seq int, s Stash) tea.Cmd {
+	return func() tea.Msg {
+		msg := cleanupCheckMsg{seq: seq, entry: cleanupEntry{Stash: s, Verdict: cleanupUnknown}}
+		unknown := func(detail string) tea.Msg {
+			msg.entry.Detail = strings.TrimSpace(detail)
+			return msg
+		}
+
+		patch, err := exec.Command("git", "stash", "show", "-p", "--binary", "-u", s.Ref).CombinedOutput()
+		if err != nil && untrackedUnsupported(string(patch)) {
+			patch, err = exec.Command("git", "stash", "show", "-p", "--binary", s.Ref).CombinedOutput()
+		}
+		if err != nil {
+			return unknown(string(patch))
+		}
+		if len(bytes.TrimSpace(patch)) == 0 {
+			return unknown("the stash has no changes to compare")
+		}
+
+		tmp, err := os.CreateTemp("", "packrat-index-*")
+		if err != nil {
+			return unknown(err.Error())
+		}
+		tmp.Close()
+		os.Remove(tmp.Name()) // read-tree wants to create the file itself
+		defer os.Remove(tmp.Name())
+		env := append(os.Environ(), "GIT_INDEX_FILE="+tmp.Name())
+
+		readTree := exec.Command("git", "read-tree", "HEAD")
+		readTree.Env = env
+		if out, err := readTree.CombinedOutput(); err != nil {
+			return unknown(string(out))
+		}
+
+		check := exec.Command("git", "apply", "--check", "--reverse", "--cached", "-")
+		check.Env = env
+		check.Stdin = bytes.NewReader(patch)
+		out, err := check.CombinedOutput()
+		switch {
+		case err == nil:
+			msg.entry.Verdict = cleanupMerged
+		case patchDoesntFit(string(out)):
+			msg.entry.Verdict = cleanupPending
+		default:
+			return unknown(string(out))
+		}
+		return msg
+	}
+}
+
+// patchDoesntFit tells `git apply --check` failing because the content differs from
+// it failing for any other reason, such as a binary patch it can't handle
+func patchDoesntFit(out string) bool {
+	for _, line := range splitLines(out) {
+		rest, ok := strings.CutPrefix(line, "error: ")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(rest, "patch failed: ") || strings.HasSuffix(rest, ": does not exist in index") ||
+			strings.HasSuffix(rest, ": does not match index") || strings.HasSuffix(rest, ": already exists in index") {
+			return true
+		}
+	}
+	return false
+}
+
+// startCleanup checks every stash at once, one set of git processes per stash
+func (m *model) startCleanup() tea.Cmd {
+	m.cleanupSeq++
+	m.cleanupPending = len(m.stashes)
+	clear(m.markedStashes) // d fills them from the cleanup list
+	m.cleanupResults = nil
+	m.appState = StateCleanUp
+	m.displayedRef = ""
+	m.cleanupList.SetItems(nil)
+	m.cleanupList.Title = fmt.Sprintf("Packrat - Clean Up (0/%d checked)", len(m.stashes))
+	m.viewport.SetContent(fmt.Sprintf("Checking %d stash(es) against HEAD...", len(m.stashes)))
+	m.viewport.GotoTop()
+	cmds := []tea.Cmd{m.cleanupList.StartSpinner()}
+	for _, s := range m.stashes {
+		cmds = append(cmds, checkStashMerged(m.cleanupSeq, s))
+	}
+	return tea.Batch(cmds...)
+}
+
+// gotCleanupCheck counts a stash's verdict in, and lists the stashes worth dropping
+// once they're all in. Merged stashes start out marked; unknown ones never do.
+func (m *model) gotCleanupCheck(msg cleanupCheckMsg) {
+	if msg.seq != m.cleanupSeq || m.appState != StateCleanUp {
+		return
+	}
+	m.cleanupResults = append(m.cleanupResults, msg.entry)
+	total := len(m.stashes)
+	if m.cleanupPending--; m.cleanupPending > 0 {
+		m.cleanupList.Title = fmt.Sprintf("Packrat - Clean Up (%d/%d checked)", total-m.cleanupPending, total)
+		return
+	}
+	m.cleanupList.StopSpinner()
+
+	var items []list.Item
+	counts := make(map[cleanupVerdict]int)
+	sort.SliceStable(m.cleanupResults, func(i, j int) bool {
+		a, b := m.cleanupResults[i], m.cleanupResults[j]
+		if a.Verdict != b.Verdict {
+			return a.Verdict < b.Verdict
+		}
+		ai, _ := a.Index()
+		bi, _ := b.Index()
+		return ai < bi
+	})
+	for _, e := range m.cleanupResults {
+		counts[e.Verdict]++
+		if e.Verdict == cleanupPending {
+			continue
+		}
+		e.Marked = e.Verdict == cleanupMerged
+		items = append(items, e)
+	}
+	m.cleanupList.SetItems(items)
+	m.cleanupList.Title = fmt.Sprintf("Packrat - Clean Up (%d merged, %d unknown)", counts[cleanupMerged], counts[cleanupUnknown])
+	m.cleanupList.Select(0)
+	m.status = fmt.Sprintf("%d merged, %d unknown, %d still pending", counts[cleanupMerged], counts[cleanupUnknown], counts[cleanupPending])
+	m.showCleanupEntry()
+}
+
+// showCleanupEntry explains the highlighted stash's verdict in the right pane
+func (m *model) showCleanupEntry() {
+	e, ok := m.cleanupList.SelectedItem().(cleanupEntry)
+	if !ok {
+		m.viewport.SetContent("No stash is already in HEAD; every one still holds changes.")
+		return
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(e.Stash.Ref+" "+e.displayMessage()) + "\n\n")
+	switch e.Verdict {
+	case cleanupMerged:
+		b.WriteString("Every change in this stash is already in HEAD, so applying it would do nothing.\n")
+	case cleanupUnknown:
+		b.WriteString("This stash couldn't be checked, so it may or may not be in HEAD:\n\n")
+		b.WriteString(statusStyle.Render(e.Detail) + "\n")
+	}
+	// git's errors can run long, so wrap rather than cut them off
+	m.viewport.SetContent(lipgloss.NewStyle().Width(m.diffPaneWidth()).Render(b.String()))
+	m.viewport.GotoTop()
+}
+
+// updateCleanup handles keys while the cleanup list is shown
+func (m model) updateCleanup(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.cleanupSeq++ // drop any checks still running
+		m.cleanupList.StopSpinner()
+		m.appState = StateExplore
+		clear(m.markedStashes)
+		if sel, ok := m.selectedStash(); ok {
+			return m, m.showStashDiff(sel.Ref, false)
+		}
+		return m, nil
+	case " ": // Mark or unmark the stash
+		if e, ok := m.cleanupList.SelectedItem().(cleanupEntry); ok {
+			e.Marked = !e.Marked
+			m.cleanupList.SetItem(m.cleanupList.GlobalIndex(), e)
+		}
+		return m, nil
+	case "d": // Drop the marked stashes
+		clear(m.markedStashes)
+		for _, item := range m.cleanupList.Items() {
+			if e, ok := item.(cleanupEntry); ok && e.Marked {
+				m.markedStashes[e.Sha] = true
+			}
+		}
+		if len(m.markedStashes) == 0 {
+			m.status = "No stashes marked; press Space to mark some"
+			return m, nil
+		}
+		m.activeModal = ModalDeleteConfirm
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if m.focus == PaneDiff {
+		m.viewport, cmd = m.viewport.Update(msg)
+	} else {
+		before := m.cleanupList.Index()
+		m.cleanupList, cmd = m.cleanupList.Update(msg)
+		if m.cleanupList.Index() != before {
+			m.showCleanupEntry()
+		}
+	}
+	return m, cmd
+}
+
+// markedCleanupCount is how many stashes d would drop
+func (m model) markedCleanupCount() int {
+	n := 0
+	for _, item := range m.cleanupList.Items() {
+		if e, ok := item.(cleanupEntry); ok && e.Marked {
+			n++
+		}
+	}
+	return n
+}
+
+// newCleanupList lists the stashes found in HEAD, and the ones that couldn't be checked
+func newCleanupList() list.Model {
+	l := list.New([]list.Item{}, list.NewDefaultDelegate(), 30, 10)
+	l.Title = "Packrat - Clean Up"
+	return l
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Clear Stashes
+// ---------------------------------------------------------------------------
+
+type stashesClearedMsg struct {
+	cleared int
+	err     error
+}
+
+// clearStashes runs `git stash clear`, unless the stash list no longer holds exactly
+// the stashes the modal showed, e.g. because one was made or popped from another
+// terminal meanwhile
+func clearStashes(shas []string) tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "stash", "list", "--format=%H").Output()
+		if err != nil {
+			return stashesClearedMsg{err: err}
+		}
+		current := splitLines(string(out))
+		slices.Sort(current)
+		if !slices.Equal(current, slices.Sorted(slices.Values(shas))) {
+			return stashesClearedMsg{err: errors.New("the stash list changed since it was shown; nothing was dropped")}
+		}
+		if out, err := exec.Command("git", "stash", "clear").CombinedOutput(); err != nil {
+			return stashesClearedMsg{err: fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))}
+		}
+		return stashesClearedMsg{cleared: len(shas)}
+	}
+}
+
+// clearWarnings lists the stashes worth a second thought: pinned ones, and ones made
+// within the last hour
+func (m model) clearWarnings() (pinned, recent []Stash) {
+	now := time.Now()
+	for _, s := range m.stashes {
+		if m.pinned[s.Sha] {
+			pinned = append(pinned, s)
+		}
+		if !s.Timestamp.IsZero() && now.Sub(s.Timestamp) < time.Hour {
+			recent = append(recent, s)
+		}
+	}
+	return pinned, recent
+}
+
+// clearAll drops the stashes currently listed
+func (m *model) clearAll() tea.Cmd {
+	m.loading = true
+	shas := make([]string, len(m.stashes))
+	for i, s := range m.stashes {
+		shas[i] = s.Sha
+	}
+	return clearStashes(shas)
+}
+
+// updateClearModal handles keys while the drop is confirmed, as packrat.confirm.clear
+// asks: by typing "clear" unless it's set otherwise
+func (m model) updateClearModal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	confirmed, done := m.updateConfirm(msg, "clear")
+	if done {
+		m.activeModal = ModalNone
+		if confirmed {
+			return m, m.clearAll()
+		}
+	}
+	return m, nil
+}
+
+func (m model) clearModalView() string {
+	var b strings.Builder
+	b.WriteString("⚠️  DROP ALL STASHES ⚠️\n\n")
+	b.WriteString(fmt.Sprintf("This will drop all %d stash(es) with `git stash clear`.\n", len(m.stashes)))
+	b.WriteString("They can only be recovered by digging through unreachable commits.\n")
+
+	pinned, recent := m.clearWarnings()
+	if len(pinned) > 0 {
+		b.WriteString(fmt.Sprintf("\n%d of them are pinned:\n", len(pinned)))
+		for _, s := range pinned {
+			b.WriteString(fmt.Sprintf("  %s %s\n", s.Ref, s.displayMessage()))
+		}
+	}
+	if len(recent) > 0 {
+		b.WriteString(fmt.Sprintf("\n%d of them were made within the last hour:\n", len(recent)))
+		for _, s := range recent {
+			b.WriteString(fmt.Sprintf("  %s %s (%s)\n", s.Ref, s.displayMessage(), relativeTime(s.Timestamp, time.Now())))
+		}
+	}
+
+	b.WriteString("\n" + m.confirmPrompt("clear"))
+	return modalStyle.Render(b.String())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestClearConfirmLevels(t *testing.T) {
+	keys := func(s string) []tea.KeyMsg {
+		var msgs []tea.KeyMsg
+		for _, r := range s {
+			msgs = append(msgs, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+		return msgs
+	}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	tests := []struct {
+		level     string
+		keys      []tea.KeyMsg
+		wantModal bool // the modal opens on D
+	}{
+		{"none", nil, false},
+		{"yesno", keys("y"), true},
+		{"type", append(keys("clear"), enter), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			m := testModel(t)
+			cfg = parseConfig(map[string][]string{"packrat.confirm.clear": {tt.level}})
+			updated, _ := m.Update(stashesLoadedMsg{stashes: []Stash{{Ref: "stash@{0}", Sha: "1111111111111111111111111111111111111111"}}, branch: "main"})
+			m = updated.(model)
+			m.loading = false // the first stash's diff is loading
+
+			updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'D'}})
+			m = updated.(model)
+			if opened := m.activeModal == ModalClearStashes; opened != tt.wantModal {
+				t.Fatalf("modal open after D = %v, want %v", opened, tt.wantModal)
+			}
+			for _, key := range tt.keys {
+				if m.activeModal != ModalClearStashes {
+					t.Fatalf("modal closed before %q", key.String())
+				}
+				updated, cmd = m.Update(key)
+				m = updated.(model)
+			}
+			if m.activeModal != ModalNone || !m.loading || cmd == nil {
+				t.Errorf("stashes weren't cleared: modal %v, loading %v, cmd %v", m.activeModal, m.loading, cmd != nil)
+			}
+		})
+	}
+
+	t.Run("type rejects y", func(t *testing.T) {
+		m := testModel(t)
+		updated, _ := m.Update(stashesLoadedMsg{stashes: []Stash{{Ref: "stash@{0}", Sha: "1111111111111111111111111111111111111111"}}, branch: "main"})
+		m = updated.(model)
+		m.loading = false
+		for _, key := range append(keys("Dy"), enter) {
+			updated, _ = m.Update(key)
+			m = updated.(model)
+		}
+		if m.activeModal != ModalClearStashes || m.loading {
+			t.Errorf("typing y cleared the stashes: modal %v, loading %v", m.activeModal, m.loading)
+		}
+	})
+}
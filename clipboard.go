@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/atotto/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Clipboard
+// ---------------------------------------------------------------------------
+
+type copiedMsg struct {
+	what  string // short description of what was copied, for the status line
+	bytes int
+	err   error
+}
+
+// copyToClipboard puts text on the system clipboard. It always sends an OSC 52 sequence,
+// which reaches the local clipboard even over SSH or inside tmux, and also runs pbcopy,
+// xclip, wl-copy or the like when one is installed, for terminals that ignore OSC 52.
+func copyToClipboard(text string) error {
+	oscErr := writeOSC52(text)
+	if clipboard.Unsupported {
+		return oscErr
+	}
+	if err := clipboard.WriteAll(text); err != nil && oscErr != nil {
+		return err
+	}
+	return nil
+}
+
+// terminalOutput is the program's output. Writes made outside the renderer, like OSC
+// 52, go through it too, so they land between frames rather than in the middle of one.
+// It's still the terminal's *os.File underneath, so bubbletea sees a terminal.
+type terminalOutput struct {
+	*os.File
+	mu sync.Mutex
+}
+
+func (o *terminalOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.File.Write(p)
+}
+
+var output = &terminalOutput{File: os.Stdout}
+
+// writeOSC52 asks the terminal to set its clipboard. tmux only forwards the request
+// when it's wrapped in a passthrough sequence (and allow-passthrough is on).
+func writeOSC52(text string) error {
+	seq := ansi.SetSystemClipboard(text)
+	if os.Getenv("TMUX") != "" {
+		seq = ansi.TmuxPassthrough(seq)
+	}
+	_, err := io.WriteString(output, seq)
+	return err
+}
+
+// groupThousands formats n with commas, e.g. 4,213
+func groupThousands(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// applyCommand is a shell one-liner that applies a stash's changes to the working tree
+func applyCommand(ref string) string {
+	return fmt.Sprintf("git stash show -p --binary --include-untracked %s | git apply", ref)
+}
+
+// copyText copies text in the background, since the clipboard may shell out to pbcopy/xclip
+func copyText(text, what string) tea.Cmd {
+	return func() tea.Msg {
+		err := copyToClipboard(text)
+		return copiedMsg{what: what, bytes: len(text), err: err}
+	}
+}
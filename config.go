@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Config
+// ---------------------------------------------------------------------------
+
+// config holds the user's Packrat settings. They live in git config under the
+// "packrat." section, so they can be set per repo or with --global.
+type config struct {
+	CleanExcludes   []string                // packrat.cleanExclude (multi-valued): globs `git clean` must never delete
+	ReloadOnEnter   bool                    // packrat.reloadOnEnter: re-fetch the diff even if it's already displayed
+	MaxLineWidth    int                     // packrat.maxLineWidth: longer diff lines are cut off with "…" (0 disables)
+	DateFormat      string                  // packrat.dateFormat: "relative", "iso", or a strftime-style pattern
+	Confirm         map[string]confirmLevel // packrat.confirm.<operation>: "yesno", "type" or "none"
+	SharedRefs      string                  // packrat.sharedNamespace: ref namespace holding shared stashes
+	StashFormat     string                  // packrat.stashFormat: extra --pretty placeholders shown per stash
+	MaxDiffLines    int                     // packrat.maxDiffLines: bigger diffs load as a --stat summary (0 disables)
+	MaxDiffBytes    int                     // packrat.maxDiffBytes: stash diffs load in chunks of this size (0 disables)
+	AutoRefresh     bool                    // packrat.autoRefresh: re-fetch expanded Build Mode diffs when their files change
+	RefreshInterval int                     // packrat.refreshInterval: seconds between Build Mode file list reloads (0 disables)
+	Border          string                  // packrat.border: pane border, "normal", "rounded", "thick" or "none"
+	Padding         int                     // packrat.padding: blank cells between a pane's border and its content
+	Glyphs          glyphSet                // packrat.glyphs plus packrat.glyph.<name> overrides
+	DiffTool        string                  // packrat.diffTool: `git difftool --tool` to use instead of diff.tool
+	ShowHeader      bool                    // packrat.showHeader: show the key help above the diff pane (ctrl+h saves it)
+	EmptyStart      string                  // packrat.emptyStart: with no stashes at startup, "prompt" or start in "build" mode
+	Pinned          []string                // packrat.pinned (multi-valued): SHAs of stashes pinned with *
+	MessageHistory  []string                // packrat.messageHistory (multi-valued): recent stash messages, oldest first
+	Ages            ageThresholds           // where the list's new/stale/old coloring starts; not configurable yet
+
+	Problems []string // settings that were rejected, reported once at startup
+}
+
+// glyphSet holds the indicator symbols drawn in lists and diff views
+type glyphSet struct {
+	Staged, Unstaged    string // before each changed file in Build Mode
+	Untracked           string // before files git doesn't track yet, in Build Mode
+	Conflicted          string // before files left unmerged, in Build Mode
+	Expanded, Collapsed string // before expandable files and directories
+	Pinned              string // before pinned stashes
+}
+
+var (
+	unicodeGlyphs = glyphSet{Staged: "●", Unstaged: "○", Untracked: "◇", Conflicted: "✗", Expanded: "▼", Collapsed: "▶", Pinned: "★"}
+	asciiGlyphs   = glyphSet{Staged: "*", Unstaged: "o", Untracked: "?", Conflicted: "!", Expanded: "v", Collapsed: ">", Pinned: "^"}
+)
+
+// terminalSupportsUnicode guesses from the locale, the same way most terminal programs do
+func terminalSupportsUnicode() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	// No locale at all is common on macOS and in containers, which are UTF-8 in practice
+	return true
+}
+
+// configGlyphs picks the glyph set from packrat.glyphs ("auto", "unicode" or "ascii")
+// and applies any packrat.glyph.<name> overrides on top
+func configGlyphs(values map[string][]string) (glyphSet, error) {
+	var glyphs glyphSet
+	var err error
+	switch mode := strings.ToLower(configString(values, "packrat.glyphs", "auto")); mode {
+	case "unicode":
+		glyphs = unicodeGlyphs
+	case "ascii":
+		glyphs = asciiGlyphs
+	default:
+		if mode != "auto" {
+			err = fmt.Errorf("packrat.glyphs %q isn't one of auto, unicode or ascii", mode)
+		}
+		glyphs = asciiGlyphs
+		if terminalSupportsUnicode() {
+			glyphs = unicodeGlyphs
+		}
+	}
+
+	glyphs.Staged = configString(values, "packrat.glyph.staged", glyphs.Staged)
+	glyphs.Unstaged = configString(values, "packrat.glyph.unstaged", glyphs.Unstaged)
+	glyphs.Untracked = configString(values, "packrat.glyph.untracked", glyphs.Untracked)
+	glyphs.Conflicted = configString(values, "packrat.glyph.conflicted", glyphs.Conflicted)
+	glyphs.Expanded = configString(values, "packrat.glyph.expanded", glyphs.Expanded)
+	glyphs.Collapsed = configString(values, "packrat.glyph.collapsed", glyphs.Collapsed)
+	glyphs.Pinned = configString(values, "packrat.glyph.pinned", glyphs.Pinned)
+	return glyphs, err
+}
+
+// stashFormatPresets are the named values packrat.stashFormat accepts besides a custom format
+var stashFormatPresets = map[string]string{
+	"default": "",
+	"author":  "%an",
+	"sha":     "%h",
+	"full":    "%an · %h · %ci",
+}
+
+// validateStashFormat checks a custom packrat.stashFormat. Packrat always requests the ref,
+// timestamp and message itself, so the custom part only has to stay on one line and keep
+// clear of the field separator.
+func validateStashFormat(format string) error {
+	lower := strings.ToLower(format)
+	for _, bad := range []string{"%n", "%x00", "%x0a", "%x1f", "\n"} {
+		if strings.Contains(lower, bad) {
+			return fmt.Errorf("packrat.stashFormat can't contain %q", bad)
+		}
+	}
+	if !strings.Contains(format, "%") {
+		return fmt.Errorf("packrat.stashFormat %q has no placeholders and isn't a preset", format)
+	}
+	return nil
+}
+
+// confirmLevel is how much friction a destructive operation asks for before running
+type confirmLevel int
+
+const (
+	confirmYesNo confirmLevel = iota // press y
+	confirmType                      // type the operation's name
+	confirmNone                      // run immediately
+)
+
+var confirmLevelNames = map[string]confirmLevel{
+	"yesno": confirmYesNo,
+	"type":  confirmType,
+	"none":  confirmNone,
+}
+
+// confirmDefaults lists the operations whose confirmation can be configured, with the
+// level each asks for when it isn't. Dropping every stash has to be typed out.
+var confirmDefaults = map[string]confirmLevel{
+	"restore": confirmYesNo,
+	"clear":   confirmType,
+}
+
+// confirmLevelFor returns the configured level for an operation such as "restore"
+func (c config) confirmLevelFor(operation string) confirmLevel {
+	if level, ok := c.Confirm[operation]; ok {
+		return level
+	}
+	return confirmDefaults[operation]
+}
+
+// cfg is loaded once in main before the model is built
+var cfg config
+
+func loadConfig() config {
+	return parseConfig(readGitConfig())
+}
+
+// parseConfig builds the config from packrat.* values keyed by lowercase name, with
+// the defaults for anything missing
+func parseConfig(values map[string][]string) config {
+	c := config{
+		CleanExcludes:   values["packrat.cleanexclude"],
+		Pinned:          values["packrat.pinned"],
+		MessageHistory:  values["packrat.messagehistory"],
+		ReloadOnEnter:   configBool(values, "packrat.reloadonenter", false),
+		MaxLineWidth:    configInt(values, "packrat.maxlinewidth", 1000),
+		DateFormat:      configString(values, "packrat.dateformat", "relative"),
+		SharedRefs:      configString(values, "packrat.sharednamespace", "refs/stashes/"),
+		MaxDiffLines:    configInt(values, "packrat.maxdifflines", 5000),
+		MaxDiffBytes:    configInt(values, "packrat.maxdiffbytes", 1<<20),
+		AutoRefresh:     configBool(values, "packrat.autorefresh", false),
+		RefreshInterval: max(configInt(values, "packrat.refreshinterval", 0), 0),
+		Border:          strings.ToLower(configString(values, "packrat.border", "normal")),
+		Padding:         configInt(values, "packrat.padding", 1),
+		DiffTool:        configString(values, "packrat.difftool", ""),
+		ShowHeader:      configBool(values, "packrat.showheader", true),
+		EmptyStart:      strings.ToLower(configString(values, "packrat.emptystart", "prompt")),
+		Ages:            ageThresholds{New: defaultNewAge, Stale: defaultStaleAge, Old: defaultOldAge},
+	}
+
+	confirm, problems := configConfirmLevels(values)
+	c.Confirm = confirm
+	c.Problems = append(c.Problems, problems...)
+
+	glyphs, err := configGlyphs(values)
+	if err != nil {
+		c.Problems = append(c.Problems, err.Error())
+	}
+	c.Glyphs = glyphs
+
+	if _, ok := paneBorders[c.Border]; !ok {
+		c.Problems = append(c.Problems, fmt.Sprintf("packrat.border %q isn't one of normal, rounded, thick or none", c.Border))
+		c.Border = "normal"
+	}
+
+	if c.EmptyStart != "prompt" && c.EmptyStart != "build" {
+		c.Problems = append(c.Problems, fmt.Sprintf("packrat.emptyStart %q isn't one of prompt or build", c.EmptyStart))
+		c.EmptyStart = "prompt"
+	}
+
+	format := configString(values, "packrat.stashformat", "default")
+	if preset, ok := stashFormatPresets[format]; ok {
+		c.StashFormat = preset
+	} else if err := validateStashFormat(format); err != nil {
+		c.Problems = append(c.Problems, err.Error())
+	} else {
+		c.StashFormat = format
+	}
+	return c
+}
+
+// configConfirmLevels collects every packrat.confirm.<operation> setting, and the ones
+// naming an unknown operation or level
+func configConfirmLevels(values map[string][]string) (map[string]confirmLevel, []string) {
+	levels := make(map[string]confirmLevel)
+	var problems []string
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		operation, ok := strings.CutPrefix(key, "packrat.confirm.")
+		v := values[key]
+		if !ok || len(v) == 0 {
+			continue
+		}
+		if _, ok := confirmDefaults[operation]; !ok {
+			problems = append(problems, fmt.Sprintf("packrat.confirm.%s isn't an operation; use restore or clear", operation))
+			continue
+		}
+		level, ok := confirmLevelNames[strings.ToLower(v[len(v)-1])]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("packrat.confirm.%s %q isn't one of yesno, type or none", operation, v[len(v)-1]))
+			continue
+		}
+		levels[operation] = level
+	}
+	return levels, problems
+}
+
+// configInt reads a non-negative integer, falling back to def when unset or invalid
+func configInt(values map[string][]string, key string, def int) int {
+	v, ok := values[key]
+	if !ok || len(v) == 0 {
+		return def
+	}
+	n, err := strconv.Atoi(v[len(v)-1])
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// configString reads a single-valued key, falling back to def when unset
+func configString(values map[string][]string, key string, def string) string {
+	v, ok := values[key]
+	if !ok || len(v) == 0 || v[len(v)-1] == "" {
+		return def
+	}
+	return v[len(v)-1]
+}
+
+// configBool reads a boolean the way git does, falling back to def when unset or invalid
+func configBool(values map[string][]string, key string, def bool) bool {
+	v, ok := values[key]
+	if !ok || len(v) == 0 {
+		return def
+	}
+	switch strings.ToLower(v[len(v)-1]) {
+	case "true", "yes", "on", "1", "":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	}
+	return def
+}
+
+// readGitConfig returns every packrat.* key with all of its values. Git reports
+// key names lowercased, so lookups must use lowercase names.
+func readGitConfig() map[string][]string {
+	values := make(map[string][]string)
+
+	cmd := exec.Command("git", "config", "--get-regexp", `^packrat\.`)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	// Exits non-zero when nothing matches, which just means there's no config
+	if err := cmd.Run(); err != nil {
+		return values
+	}
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		values[key] = append(values[key], value)
+	}
+	return values
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConfigConfirmLevels(t *testing.T) {
+	c := parseConfig(nil)
+	if got := c.confirmLevelFor("restore"); got != confirmYesNo {
+		t.Errorf("default restore level = %v, want yesno", got)
+	}
+	if got := c.confirmLevelFor("clear"); got != confirmType {
+		t.Errorf("default clear level = %v, want type", got)
+	}
+
+	c = parseConfig(map[string][]string{
+		"packrat.confirm.restore": {"TYPE"},
+		"packrat.confirm.clear":   {"none", "yesno"},
+	})
+	if got := c.confirmLevelFor("restore"); got != confirmType {
+		t.Errorf("restore level = %v, want type", got)
+	}
+	if got := c.confirmLevelFor("clear"); got != confirmYesNo {
+		t.Errorf("clear level = %v, want yesno, the last value set", got)
+	}
+	if len(c.Problems) != 0 {
+		t.Errorf("Problems = %q, want none", c.Problems)
+	}
+
+	c = parseConfig(map[string][]string{
+		"packrat.confirm.clear": {"always"},
+		"packrat.confirm.drop":  {"none"},
+	})
+	if got := c.confirmLevelFor("clear"); got != confirmType {
+		t.Errorf("clear level = %v after an invalid value, want the default", got)
+	}
+	want := []string{
+		`packrat.confirm.clear "always" isn't one of yesno, type or none`,
+		"packrat.confirm.drop isn't an operation; use restore or clear",
+	}
+	if !slices.Equal(c.Problems, want) {
+		t.Errorf("Problems = %q, want %q", c.Problems, want)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Diff Context
+// ---------------------------------------------------------------------------
+
+const (
+	defaultDiffContext = 3 // git's own default
+	maxDiffContext     = 50
+)
+
+// contextStep is how far a + or - key moves the context
+func contextStep(key string) int {
+	if key == "-" {
+		return -1
+	}
+	return 1
+}
+
+// changeDiffContext widens or narrows the context around changes by delta lines,
+// reporting whether it changed
+func (m *model) changeDiffContext(delta int) bool {
+	context := min(max(m.diffOpts.Context+delta, 0), maxDiffContext)
+	if context == m.diffOpts.Context {
+		m.status = fmt.Sprintf("Context is already %d line(s)", context)
+		return false
+	}
+	m.diffOpts.Context = context
+	m.status = fmt.Sprintf("%d line(s) of context", context)
+	return true
+}
+
+// diffOptionsInfo names the context size, and the whitespace mode unless it's the
+// default, for the status line
+func (m model) diffOptionsInfo() string {
+	info := fmt.Sprintf("context %d", m.diffOpts.Context)
+	if m.diffOpts.Whitespace != WhitespaceShown {
+		info += " · " + whitespaceModeName[m.diffOpts.Whitespace]
+	}
+	return info
+}
+
+// fileDiffOptions are the diff options Build Mode file diffs use; word diffs are
+// Explore Mode only
+func (m model) fileDiffOptions() diffOptions {
+	opts := m.diffOpts
+	opts.Words = false
+	return opts
+}
+
+// refetchFileDiffs reloads the selected files' diffs after the options changed, except
+// ones that haven't been needed yet. Files that were shown in full stay in full. Diffs
+// cached for the preview are dropped, to be fetched again when they're previewed.
+func (m *model) refetchFileDiffs() tea.Cmd {
+	m.forgetPreviews()
+	var cmds []tea.Cmd
+	for path, file := range m.selectedFiles {
+		if _, loaded := m.fileDiffs[path]; !loaded && !m.needsDiff(path) {
+			continue // it's fetched when expanded
+		}
+		fetch := fetchFileDiff(file, !m.summarizedFiles[path], m.fileDiffOptions())
+		cmds = append(cmds, func() tea.Msg {
+			msg := fetch().(fileDiffMsg)
+			msg.keepScroll = true
+			return msg
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// refetchStashDiff shows the displayed stash diff again after the options changed,
+// keeping roughly the same part of it in view
+func (m *model) refetchStashDiff() tea.Cmd {
+	if m.displayedRef == "" || m.statView {
+		return nil
+	}
+	pos := m.diffPosition()
+	m.pendingDiffPosition = &pos
+	return m.showStashDiff(m.displayedRef, false)
+}
+
+// diffPosition is roughly where the stash diff is scrolled to: a file, and how far
+// through that file's section the top of the pane is. It survives a re-fetch that
+// moves lines around, such as a change of context.
+type diffPosition struct {
+	File     int // -1 above the first file
+	Fraction float64
+	Offset   int // the plain line offset, used above the first file
+}
+
+// diffPosition notes where the stash diff is scrolled to
+func (m model) diffPosition() diffPosition {
+	pos := diffPosition{File: m.currentDiffFile(), Offset: m.viewport.YOffset}
+	if pos.File >= 0 {
+		start, end := m.diffFileSpan(pos.File)
+		if end > start {
+			pos.Fraction = float64(m.viewport.YOffset-start) / float64(end-start)
+		}
+	}
+	return pos
+}
+
+// restoreDiffPosition scrolls back to a position noted before the diff was replaced
+func (m *model) restoreDiffPosition(pos diffPosition) {
+	if pos.File < 0 || pos.File >= len(m.diffFiles) {
+		m.viewport.SetYOffset(pos.Offset)
+		return
+	}
+	start, end := m.diffFileSpan(pos.File)
+	m.viewport.SetYOffset(start + int(pos.Fraction*float64(end-start)))
+}
+
+// diffFileSpan is the range of lines the file's section of the diff covers
+func (m model) diffFileSpan(file int) (start, end int) {
+	start = m.diffFiles[file].Line
+	if file+1 < len(m.diffFiles) {
+		return start, m.diffFiles[file+1].Line
+	}
+	return start, strings.Count(m.renderedDiff(), "\n") + 1
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Diff Parsing
+// ---------------------------------------------------------------------------
+
+// diffOptions change how git renders a stash's diff. They're part of the diff cache key,
+// since each combination is a separate fetch.
+type diffOptions struct {
+	Words      bool           // --word-diff, toggled with w
+	Context    int            // -U<n>, changed with +/-
+	Whitespace whitespaceMode // -w and friends, cycled with W
+}
+
+// args are the `git stash show` flags for the options
+func (o diffOptions) args() []string {
+	args := append([]string{fmt.Sprintf("-U%d", o.Context)}, o.Whitespace.args()...)
+	if o.Words {
+		args = append(args, "--word-diff=porcelain")
+	}
+	return args
+}
+
+// colorize styles plain diff output fetched with the options
+func (o diffOptions) colorize(diff string) string {
+	if o.Words {
+		return colorizeWordDiff(diff)
+	}
+	return colorizeDiff(diff)
+}
+
+// diffHunk is a single "@@" section of a unified diff
+type diffHunk struct {
+	Header string   // the "@@ -a,b +c,d @@" line
+	Lines  []string // body lines that follow the header
+}
+
+// filePatch is the portion of a unified diff that belongs to one file
+type filePatch struct {
+	Header []string // "diff --git", "index", "---", "+++" and friends
+	Hunks  []diffHunk
+}
+
+// parseDiff splits plain (uncolored) unified diff output into per-file patches
+func parseDiff(diff string) []filePatch {
+	var patches []filePatch
+	var current *filePatch
+
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			patches = append(patches, filePatch{Header: []string{line}})
+			current = &patches[len(patches)-1]
+		case current == nil:
+			// Anything before the first file header isn't part of a patch
+			continue
+		case strings.HasPrefix(line, "@@"):
+			current.Hunks = append(current.Hunks, diffHunk{Header: line})
+		case len(current.Hunks) > 0:
+			hunk := &current.Hunks[len(current.Hunks)-1]
+			hunk.Lines = append(hunk.Lines, line)
+		default:
+			current.Header = append(current.Header, line)
+		}
+	}
+	return patches
+}
+
+// String renders the hunk back to its unified diff form
+func (h diffHunk) String() string {
+	return h.Header + "\n" + strings.Join(h.Lines, "\n") + "\n"
+}
+
+// buildPatch reassembles a patch containing only the selected hunks, suitable for `git apply`
+func (p filePatch) buildPatch(selected map[int]bool) string {
+	var b strings.Builder
+	for _, line := range p.Header {
+		b.WriteString(line + "\n")
+	}
+	for i, hunk := range p.Hunks {
+		if selected[i] {
+			b.WriteString(hunk.String())
+		}
+	}
+	return b.String()
+}
+
+// decorateRenames collapses git's "rename from"/"rename to" header pair into a single
+// "renamed: old → new" line. It works on colored output, so matching ignores ANSI codes.
+func decorateRenames(diff string) string {
+	lines := strings.Split(diff, "\n")
+	out := make([]string, 0, len(lines))
+	renamedFrom := ""
+
+	for _, line := range lines {
+		plain := ansi.Strip(line)
+		switch {
+		case strings.HasPrefix(plain, "rename from "):
+			renamedFrom = strings.TrimPrefix(plain, "rename from ")
+		case strings.HasPrefix(plain, "rename to ") && renamedFrom != "":
+			renamedTo := strings.TrimPrefix(plain, "rename to ")
+			out = append(out, renameStyle.Render("renamed: "+renamedFrom+" → "+renamedTo))
+			renamedFrom = ""
+		default:
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// hunkAtLine finds the hunk containing the given line of (possibly colored) diff
+// output and returns it with ANSI codes stripped. Lines above the first hunk of a
+// file don't belong to any hunk.
+func hunkAtLine(diff string, line int) (string, bool) {
+	lines := strings.Split(ansi.Strip(diff), "\n")
+	if line < 0 || line >= len(lines) {
+		return "", false
+	}
+
+	start := -1
+	for i := line; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], "@@") {
+			start = i
+			break
+		}
+		if strings.HasPrefix(lines[i], "diff --git ") {
+			break
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+
+	end := start + 1
+	for end < len(lines) && !strings.HasPrefix(lines[end], "@@") && !strings.HasPrefix(lines[end], "diff --git ") {
+		end++
+	}
+	return strings.Join(lines[start:end], "\n") + "\n", true
+}
+
+// diffFileHeader is where one file's section starts in a diff
+type diffFileHeader struct {
+	Line int    // line of the "diff --git" header
+	Path string // the file's path on the "b/" side
+}
+
+// indexFileHeaders finds every file's "diff --git" header in (possibly colored) diff output
+func indexFileHeaders(diff string) []diffFileHeader {
+	var headers []diffFileHeader
+	for i, line := range strings.Split(diff, "\n") {
+		plain, ok := strings.CutPrefix(ansi.Strip(line), "diff --git ")
+		if !ok {
+			continue
+		}
+		path := plain
+		if at := strings.LastIndex(plain, " b/"); at >= 0 {
+			path = plain[at+len(" b/"):]
+		}
+		headers = append(headers, diffFileHeader{Line: i, Path: path})
+	}
+	return headers
+}
+
+// fileHeaderLine finds the line of (possibly colored) diff output where path's
+// "diff --git" header is, or -1 when the diff doesn't include it
+func fileHeaderLine(diff, path string) int {
+	for _, h := range indexFileHeaders(diff) {
+		if h.Path == path {
+			return h.Line
+		}
+	}
+	return -1
+}
+
+// renderDiffHTML turns plain unified diff output into a standalone HTML page
+func renderDiffHTML(title, diff string) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString(fmt.Sprintf("<title>%s</title>\n", html.EscapeString(title)))
+	b.WriteString(`<style>
+body { background: #1e1e1e; color: #d4d4d4; font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 13px; }
+pre { margin: 0; }
+.file { color: #e5c07b; font-weight: bold; margin-top: 1.5em; }
+.meta { color: #888; }
+.hunk { color: #56b6c2; }
+.add { color: #98c379; background: #1f2d1f; }
+.del { color: #e06c75; background: #2d1f1f; }
+</style>
+</head>
+<body>
+`)
+	b.WriteString(fmt.Sprintf("<h2>%s</h2>\n<pre>\n", html.EscapeString(title)))
+
+	inHeader := false
+	for _, line := range strings.Split(diff, "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			class, inHeader = "file", true
+		case strings.HasPrefix(line, "@@"):
+			class, inHeader = "hunk", false
+		case inHeader:
+			class = "meta"
+		case strings.HasPrefix(line, "+"):
+			class = "add"
+		case strings.HasPrefix(line, "-"):
+			class = "del"
+		}
+		if class == "" {
+			b.WriteString(html.EscapeString(line) + "\n")
+		} else {
+			b.WriteString(fmt.Sprintf("<span class=\"%s\">%s</span>\n", class, html.EscapeString(line)))
+		}
+	}
+
+	b.WriteString("</pre>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// summaryNote is appended to a --stat summary shown in place of an over-budget diff
+func summaryNote() string {
+	return fmt.Sprintf("Diff is larger than packrat.maxDiffLines (%d changed lines), so only a summary is shown. Press F to load the full diff.", cfg.MaxDiffLines)
+}
+
+// clampLines cuts every line wider than max cells down to size with a "…" marker, so
+// minified or generated files can't wreck the viewport layout. A max of 0 disables it.
+func clampLines(content string, max int) string {
+	if max <= 0 {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		// A line can't be wider than its byte length, so most lines skip the width check
+		if len(line) > max && ansi.StringWidth(line) > max {
+			lines[i] = ansi.Truncate(line, max, "…")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+func TestClampLinesLongLine(t *testing.T) {
+	const max = 80
+	long := "\x1b[32m+" + strings.Repeat("a", 100_000) + "\x1b[0m"
+	content := "short line\n" + long + "\n\x1b[31m-removed\x1b[0m"
+
+	lines := strings.Split(clampLines(content, max), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("clampLines() gave %d lines, want 3", len(lines))
+	}
+	if lines[0] != "short line" || lines[2] != "\x1b[31m-removed\x1b[0m" {
+		t.Errorf("lines that fit were changed: %q, %q", lines[0], lines[2])
+	}
+
+	clamped := lines[1]
+	if w := ansi.StringWidth(clamped); w != max {
+		t.Errorf("clamped width = %d, want %d", w, max)
+	}
+	plain := ansi.Strip(clamped)
+	if !strings.HasPrefix(plain, "+aaa") || !strings.HasSuffix(plain, "…") {
+		t.Errorf("clamped text = %q, want the start of the line and a … marker", plain)
+	}
+	// The color still opens the line and is still reset, so it can't bleed onward
+	if !strings.HasPrefix(clamped, "\x1b[32m") || !strings.HasSuffix(clamped, "\x1b[0m") {
+		t.Errorf("clamped line lost its escape sequences: %q…%q", clamped[:10], clamped[len(clamped)-10:])
+	}
+	if strings.Count(clamped, "\x1b[") != 2 {
+		t.Errorf("clamped line has %d escape sequences, want 2", strings.Count(clamped, "\x1b["))
+	}
+}
+
+func TestClampLinesDisabled(t *testing.T) {
+	long := strings.Repeat("a", 100_000)
+	if got := clampLines(long, 0); got != long {
+		t.Errorf("clampLines(_, 0) changed the content")
+	}
+}
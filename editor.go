@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Open in Editor
+// ---------------------------------------------------------------------------
+
+// errNoEditor explains what to set when neither $VISUAL nor $EDITOR is
+var errNoEditor = errors.New("set $VISUAL or $EDITOR to open files from a stash")
+
+// stashFileWrittenMsg reports that a file from a stash was copied somewhere an editor
+// can open it
+type stashFileWrittenMsg struct {
+	ref, path string
+	tmp       string // the copy, in a directory of its own
+	err       error
+}
+
+type editorClosedMsg struct {
+	path string
+	err  error
+}
+
+// editorCommand is the user's editor, split into the program and its arguments so
+// settings like "code --wait" work
+func editorCommand() ([]string, error) {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields, nil
+		}
+	}
+	return nil, errNoEditor
+}
+
+// showStashFile reads a file as the stash holds it. Untracked files live in its third
+// parent; when untracked is unknown, the stash's own tree is tried first.
+func showStashFile(ref, path string, untracked bool) ([]byte, error) {
+	if !untracked {
+		if out, err := exec.Command("git", "show", ref+":"+path).Output(); err == nil {
+			return out, nil
+		}
+	}
+	out, err := exec.Command("git", "show", ref+"^3:"+path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't in %s; the stash may delete it", path, ref)
+	}
+	return out, nil
+}
+
+// writeStashFile copies a file out of a stash into a temporary directory, keeping its
+// name so the editor picks the right syntax. The copy is read-only, as a reminder that
+// edits don't go back into the stash.
+func writeStashFile(ref, path string, untracked bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := stashFileWrittenMsg{ref: ref, path: path}
+		data, err := showStashFile(ref, path, untracked)
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		dir, err := os.MkdirTemp("", "packrat-stash-*")
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		msg.tmp = filepath.Join(dir, filepath.Base(path))
+		msg.err = os.WriteFile(msg.tmp, data, 0o444)
+		return msg
+	}
+}
+
+// openInEditor hands the terminal to the editor until it exits, then removes the copy
+func openInEditor(msg stashFileWrittenMsg) tea.Cmd {
+	editor, err := editorCommand()
+	if err != nil {
+		os.RemoveAll(filepath.Dir(msg.tmp))
+		return func() tea.Msg { return editorClosedMsg{path: msg.path, err: err} }
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], msg.tmp)...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		os.RemoveAll(filepath.Dir(msg.tmp))
+		return editorClosedMsg{path: msg.path, err: err}
+	})
+}
+
+// fileToOpen picks the stash file E opens: the files panel's cursor when it has focus,
+// otherwise the file the diff is scrolled to
+func (m model) fileToOpen() (ref, path string, untracked bool, ok bool) {
+	if m.focus == PaneFiles && m.showStashFiles && m.stashFileCursor < len(m.stashFiles) {
+		f := m.stashFiles[m.stashFileCursor]
+		return m.stashFilesRef, f.Path, f.Status == "?", true
+	}
+	if i := m.currentDiffFile(); m.displayedRef != "" && i >= 0 {
+		return m.displayedRef, m.diffFiles[i].Path, false, true
+	}
+	return "", "", false, false
+}
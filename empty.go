@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Empty States
+// ---------------------------------------------------------------------------
+
+// emptyStashesView is shown in place of a diff when there are no stashes to pick from
+func (m model) emptyStashesView() string {
+	switch {
+	case m.showShared:
+		return "(no shared stashes)\n\nPress S to go back to your own stashes."
+	case m.branchOnly && len(m.stashes) > 0:
+		return fmt.Sprintf("(no stashes on %s)\n\nPress Ctrl+f to show the stashes from every branch.", m.currentBranch)
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Welcome to Packrat") + "\n\n")
+	b.WriteString("There are no stashes in this repository yet. Packrat stashes just the files you pick, so the rest of your work stays where it is.\n\n")
+	for _, k := range [][2]string{
+		{"Tab", "pick files to stash in Build Mode"},
+		{"Ctrl+s", "stash all changes now"},
+		{"S", "browse shared stashes"},
+		{"F1", "git stash cheatsheet"},
+		{"q", "quit"},
+	} {
+		b.WriteString(fmt.Sprintf("  %-8s %s\n", k[0], k[1]))
+	}
+	return b.String()
+}
+
+// cleanTreeView is shown in Build Mode when there's nothing to stash
+func cleanTreeView() string {
+	return titleStyle.Render("Working tree clean") + "\n\n" +
+		"There are no changes to stash. Edit some files, then press R to refresh the list."
+}
+
+// emptyStateView renders content in place of vp's own, wrapped to fit and at the same
+// size, for as long as an empty state lasts
+func emptyStateView(vp viewport.Model, content string) string {
+	width := vp.Width - vp.Style.GetHorizontalFrameSize()
+	vp.SetContent(lipgloss.NewStyle().Width(max(width, 1)).Render(content))
+	vp.GotoTop()
+	return vp.View()
+}
+
+// exploreEmpty reports whether the stash list has loaded with nothing in it
+func (m model) exploreEmpty() bool {
+	return m.stashesLoaded && len(m.stashList.Items()) == 0
+}
+
+// buildEmpty reports whether the working tree has been checked and has no changes
+func (m model) buildEmpty() bool {
+	return m.changedFilesLoaded && len(m.changedFiles) == 0 && m.appState != StateHunkSelect
+}
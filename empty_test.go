@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+func TestEmptyStashesView(t *testing.T) {
+	tests := []struct {
+		name       string
+		shared     bool
+		branchOnly bool
+		stashes    []Stash
+		want       string
+	}{
+		{"no stashes", false, false, nil, "Welcome to Packrat"},
+		{"no shared stashes", true, false, nil, "(no shared stashes)"},
+		{"none on this branch", false, true, []Stash{{Ref: "stash@{0}"}}, "(no stashes on main)"},
+		{"branch filter with no stashes at all", false, true, nil, "Welcome to Packrat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(t)
+			m.showShared, m.branchOnly, m.stashes = tt.shared, tt.branchOnly, tt.stashes
+			m.currentBranch = "main"
+			if got := m.emptyStashesView(); !strings.Contains(got, tt.want) {
+				t.Errorf("emptyStashesView() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyStateView(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		content       string
+	}{
+		{"short content", 40, 10, "nothing here"},
+		{"wrapped content", 20, 10, strings.Repeat("word ", 30)},
+		{"taller than the pane", 30, 3, strings.Repeat("line\n", 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vp := viewport.New(tt.width, tt.height)
+			got := emptyStateView(vp, tt.content)
+			if h := lipgloss.Height(got); h != tt.height {
+				t.Errorf("height = %d, want %d", h, tt.height)
+			}
+			for _, line := range strings.Split(got, "\n") {
+				if w := ansi.StringWidth(line); w > tt.width {
+					t.Errorf("line %q is %d wide, want at most %d", line, w, tt.width)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyAfterLastStashDropped(t *testing.T) {
+	m := testModel(t)
+	stash := Stash{Ref: "stash@{0}", Sha: "1111111111111111111111111111111111111111", Message: "On main: wip", Timestamp: time.Now()}
+	updated, _ := m.Update(stashesLoadedMsg{stashes: []Stash{stash}, branch: "main"})
+	m = updated.(model)
+	if m.exploreEmpty() {
+		t.Fatal("exploreEmpty() with one stash listed")
+	}
+
+	// Dropping the last stash reloads the list with nothing in it
+	updated, _ = m.Update(stashesLoadedMsg{branch: "main"})
+	m = updated.(model)
+	if !m.exploreEmpty() {
+		t.Fatal("exploreEmpty() = false after the last stash was dropped")
+	}
+	if n := len(m.stashList.Items()); n != 0 {
+		t.Errorf("stash list has %d items, want 0", n)
+	}
+	if view := m.View(); !strings.Contains(view, "Welcome to Packrat") {
+		t.Errorf("View() doesn't show the empty state:\n%s", view)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Patch Export
+// ---------------------------------------------------------------------------
+
+type stashExportedMsg struct {
+	ref    string
+	path   string // absolute, so the confirmation says exactly where the file went
+	exists bool   // nothing was written because the file is already there
+	err    error
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-z0-9._]+`)
+
+// patchFileName suggests a file name for a stash's patch, made from its message
+func patchFileName(s Stash) string {
+	message := s.Message
+	if s.Branch != "" {
+		// Drop git's "On main: " prefix, which every stash on the branch shares
+		if _, rest, ok := strings.Cut(message, ": "); ok {
+			message = rest
+		}
+	}
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(strings.ToLower(message), "-"), "-.")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-.")
+	}
+	if name == "" {
+		name = "stash"
+	}
+	return name + ".patch"
+}
+
+// exportStash writes the stash, untracked files included, as a plain patch. Unless
+// overwrite is set, an existing file is left alone and reported back.
+func exportStash(ref, path string, overwrite bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := stashExportedMsg{ref: ref, path: path}
+		if abs, err := filepath.Abs(path); err == nil {
+			msg.path = abs
+		}
+
+		patch, err := exec.Command("git", "stash", "show", "-u", "-p", "--binary", "--no-color", ref).Output()
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if !overwrite {
+			flags |= os.O_EXCL
+		}
+		file, err := os.OpenFile(msg.path, flags, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			msg.exists = true
+			return msg
+		} else if err != nil {
+			msg.err = err
+			return msg
+		}
+		_, err = file.Write(patch)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		msg.err = err
+		return msg
+	}
+}
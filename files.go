@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Stash Files Panel
+// ---------------------------------------------------------------------------
+
+// stashFilesMaxRows caps the panel's height so the diff below it keeps most of the pane
+const stashFilesMaxRows = 8
+
+var stashFileCursorStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("36"))
+
+type stashFilesMsg struct {
+	ref   string
+	files []treeFile
+	err   error
+}
+
+// listStashFiles lists a stash's changed files with their status letters, followed by
+// any untracked files it holds
+func listStashFiles(ref string) ([]treeFile, error) {
+	out, err := exec.Command("git", "stash", "show", "--name-status", "-M", ref).Output()
+	if err != nil {
+		return nil, err
+	}
+	var files []treeFile
+	for _, line := range splitLines(string(out)) {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		// Renames and copies list the old and new paths; the new one is what's in the stash
+		files = append(files, treeFile{Path: unquotePath(fields[len(fields)-1]), Status: fields[0][:1]})
+	}
+
+	// Untracked files live in the stash's third parent, when there is one
+	if untracked, err := exec.Command("git", "ls-tree", "-r", "--name-only", ref+"^3").Output(); err == nil {
+		for _, path := range splitPaths(string(untracked)) {
+			files = append(files, treeFile{Path: path, Status: "?"})
+		}
+	}
+	return files, nil
+}
+
+func getStashFiles(ref string) tea.Cmd {
+	return func() tea.Msg {
+		files, err := listStashFiles(ref)
+		return stashFilesMsg{ref: ref, files: files, err: err}
+	}
+}
+
+// stashFilesHeight is how many lines the panel takes from the diff viewport
+func (m model) stashFilesHeight() int {
+	if !m.showStashFiles {
+		return 0
+	}
+	// A title line, the rows, and a blank line before the diff
+	return min(max(len(m.stashFiles), 1), stashFilesMaxRows) + 2
+}
+
+// stashFilesView renders the panel, scrolled so the cursor stays visible
+func (m model) stashFilesView() string {
+	if !m.showStashFiles {
+		return ""
+	}
+	var b strings.Builder
+	title := fmt.Sprintf("Files in %s (%d)", m.stashFilesRef, len(m.stashFiles))
+	if m.stashFilesRef == "" {
+		title = "Files"
+	}
+	b.WriteString(titleStyle.Render(title) + "\n")
+	if len(m.stashFiles) == 0 {
+		b.WriteString(statusStyle.Render("  (show a stash to list its files)") + "\n\n")
+		return b.String()
+	}
+
+	rows := min(len(m.stashFiles), stashFilesMaxRows)
+	start := min(max(m.stashFileCursor-rows/2, 0), len(m.stashFiles)-rows)
+	for i := start; i < start+rows; i++ {
+		f := m.stashFiles[i]
+		row := ansi.Truncate(fmt.Sprintf("%s %s", f.Status, f.Path), max(m.viewport.Width-2, 0), "…")
+		switch {
+		case i == m.stashFileCursor && m.focus == PaneFiles:
+			row = stashFileCursorStyle.Render("> " + row)
+		case i == m.stashFileCursor:
+			row = "> " + row
+		default:
+			row = "  " + row
+		}
+		b.WriteString(row + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// updateStashFiles handles keys while the panel has focus. handled is false for keys
+// it leaves to Explore Mode.
+func (m *model) updateStashFiles(msg tea.KeyMsg) (cmd tea.Cmd, handled bool) {
+	switch msg.String() {
+	case "up", "k":
+		m.stashFileCursor = max(m.stashFileCursor-1, 0)
+	case "down", "j":
+		m.stashFileCursor = min(m.stashFileCursor+1, max(len(m.stashFiles)-1, 0))
+	case "enter":
+		if m.stashFileCursor >= len(m.stashFiles) {
+			return nil, true
+		}
+		f := m.stashFiles[m.stashFileCursor]
+		// Scroll to the file when the whole stash is on screen, otherwise load just the file
+		if m.displayedRef == m.stashFilesRef && !m.diffSummarized {
+			if line := fileHeaderLine(m.renderedDiff(), f.Path); line >= 0 {
+				m.viewport.SetYOffset(line)
+				return nil, true
+			}
+		}
+		m.loading = true
+		return getStashFileDiff(m.stashFilesRef, f), true
+	default:
+		return nil, false
+	}
+	return nil, true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Build Mode File Tree
+// ---------------------------------------------------------------------------
+
+// fileDirRow is a directory of changed files in the grouped Build Mode list
+type fileDirRow struct {
+	Path     string
+	Depth    int
+	Expanded bool
+	Files    []FileChange // every change beneath it, at any depth
+}
+
+func (r fileDirRow) Title() string {
+	indicator := cfg.Glyphs.Collapsed
+	if r.Expanded {
+		indicator = cfg.Glyphs.Expanded
+	}
+	return fmt.Sprintf("%s%s %s/", strings.Repeat("  ", r.Depth), indicator, path.Base(r.Path))
+}
+func (r fileDirRow) Description() string { return fmt.Sprintf("%d change(s)", len(r.Files)) }
+func (r fileDirRow) FilterValue() string { return r.Path }
+
+// fileTreeItems lays the changes out by directory, directories first and both in path
+// order. A path changed in the index and in the working tree is listed twice, staged
+// first, under the same directory. Directories in collapsed hide what's beneath them.
+func fileTreeItems(files []FileChange, collapsed map[string]bool) []list.Item {
+	files = slices.Clone(files)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].IsStaged && !files[j].IsStaged })
+	// an untracked directory is one entry, named like a file without its slash
+	root := buildTree(files, func(f FileChange) string { return strings.TrimSuffix(f.Path, "/") })
+
+	var items []list.Item
+	walkTree(root, collapsed, func(n *treeNode[FileChange], depth int, expanded bool) {
+		if n.IsDir {
+			items = append(items, fileDirRow{Path: n.Path, Depth: depth, Expanded: expanded, Files: n.files()})
+		} else {
+			items = append(items, n.File)
+		}
+	})
+	return items
+}
+
+// fileTreeDelegate renders the grouped list: files indented under their directory and
+// named without it, and directories with how many of their files are selected
+type fileTreeDelegate struct {
+	fileDelegate
+	selected map[string]FileChange // shared with the model, so it must be cleared rather than replaced
+}
+
+// treeFileChange is a FileChange as a row of the grouped list
+type treeFileChange struct {
+	FileChange
+	reviewed bool
+}
+
+func (f treeFileChange) Title() string {
+	glyph := cfg.Glyphs.Unstaged
+	switch {
+	case f.IsConflicted:
+		glyph = cfg.Glyphs.Conflicted
+	case f.IsStaged:
+		glyph = cfg.Glyphs.Staged
+	case f.IsUntracked:
+		glyph = cfg.Glyphs.Untracked
+	}
+	// An untracked directory comes as one path ending in a slash, and stays one row
+	trimmed := strings.TrimSuffix(f.Path, "/")
+	name := path.Base(trimmed)
+	if trimmed != f.Path {
+		name += "/"
+	}
+	if f.OldPath != "" {
+		name = fmt.Sprintf("%s → %s", f.OldPath, name)
+	}
+	title := fmt.Sprintf("%s%s %s %s", strings.Repeat("  ", strings.Count(trimmed, "/")), glyph, f.Status, name)
+	if f.reviewed {
+		title += " ✓"
+	}
+	return title
+}
+
+// selectedDirRow is a directory row with its selection count in the title
+type selectedDirRow struct {
+	fileDirRow
+	selected int
+}
+
+func (r selectedDirRow) Title() string {
+	if r.selected == 0 {
+		return r.fileDirRow.Title()
+	}
+	return fmt.Sprintf("%s (%d/%d)", r.fileDirRow.Title(), r.selected, len(r.Files))
+}
+
+func (d fileTreeDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	switch it := item.(type) {
+	case FileChange:
+		item = treeFileChange{it, d.reviewed[it.Path]}
+	case fileDirRow:
+		n := 0
+		for _, f := range it.Files {
+			if _, ok := d.selected[f.Path]; ok {
+				n++
+			}
+		}
+		item = selectedDirRow{it, n}
+	}
+	conflictStyled(d.DefaultDelegate, item).Render(w, m, index, item)
+}
+
+// toggleFileTree switches the Build Mode list between flat and grouped by directory,
+// staying on the same file
+func (m *model) toggleFileTree() {
+	m.fileTree = !m.fileTree
+	if m.fileTree {
+		m.fileList.SetDelegate(fileTreeDelegate{fileDelegate{list.NewDefaultDelegate(), m.reviewedFiles}, m.selectedFiles})
+	} else {
+		m.fileList.SetDelegate(fileDelegate{list.NewDefaultDelegate(), m.reviewedFiles})
+	}
+	m.refreshFileItems()
+}
+
+// refreshFileItems rebuilds the file list, keeping the cursor on the row it was on
+func (m *model) refreshFileItems() {
+	current := ""
+	switch it := m.fileList.SelectedItem().(type) {
+	case FileChange:
+		current = it.Path
+	case fileDirRow:
+		current = it.Path
+	}
+	m.setFileItems()
+	for i, item := range m.fileList.Items() {
+		switch it := item.(type) {
+		case FileChange:
+			if it.Path == current {
+				m.fileList.Select(i)
+				return
+			}
+		case fileDirRow:
+			if it.Path == current {
+				m.fileList.Select(i)
+				return
+			}
+		}
+	}
+}
+
+// updateFileDir handles Enter and Space on a directory of the grouped list: Enter
+// selects everything beneath it, or deselects it all if it was all selected already,
+// and Space collapses or expands it. Like select all, it leaves diffs until they're
+// expanded.
+func (m *model) updateFileDir(dir fileDirRow, key string) tea.Cmd {
+	if key == " " {
+		m.fileTreeCollapsed[dir.Path] = dir.Expanded
+		m.refreshFileItems()
+		return nil
+	}
+
+	all := true
+	for _, f := range dir.Files {
+		if _, ok := m.selectedFiles[f.Path]; !ok {
+			all = false
+			break
+		}
+	}
+	for _, f := range dir.Files {
+		if all {
+			m.deselectFile(f.Path)
+		} else if _, ok := m.selectedFiles[f.Path]; !ok && !f.IsConflicted {
+			m.selectedFiles[f.Path] = f
+			m.expandedFiles[f.Path] = false
+		}
+	}
+	if all {
+		m.status = fmt.Sprintf("Deselected %s/", dir.Path)
+	} else {
+		m.status = fmt.Sprintf("Selected everything in %s/", dir.Path)
+	}
+	m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	m.buildViewport.GotoTop()
+	return tea.Batch(m.fetchMissingDiffs(), m.saveSession())
+}
+
+// deselectFile drops a file from the selection, along with everything loaded for it
+func (m *model) deselectFile(path string) {
+	delete(m.selectedFiles, path)
+	delete(m.expandedFiles, path)
+	delete(m.fileDiffs, path)
+	delete(m.summarizedFiles, path)
+	delete(m.fileMtimes, path)
+}
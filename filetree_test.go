@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFileTreeItems(t *testing.T) {
+	files := []FileChange{
+		{Path: "main.go", Status: "M"},
+		{Path: "src/b.go", Status: "M"},
+		{Path: "src/a.go", Status: "M"},
+		{Path: "src/a.go", Status: "M", IsStaged: true},
+		{Path: "src/new/", Status: "?", IsUntracked: true},
+		{Path: "docs/x/y.md", Status: "A", IsStaged: true},
+		{Path: "README.md", Status: "D"},
+	}
+	describe := func(items []any) []string {
+		var rows []string
+		for _, item := range items {
+			switch it := item.(type) {
+			case fileDirRow:
+				rows = append(rows, fmt.Sprintf("%d dir %s (%d)", it.Depth, it.Path, len(it.Files)))
+			case FileChange:
+				rows = append(rows, fmt.Sprintf("file %s staged=%v", it.Path, it.IsStaged))
+			}
+		}
+		return rows
+	}
+	items := func(collapsed map[string]bool) []any {
+		var out []any
+		for _, item := range fileTreeItems(files, collapsed) {
+			out = append(out, item)
+		}
+		return out
+	}
+
+	got := describe(items(nil))
+	want := []string{
+		"0 dir docs (1)",
+		"1 dir docs/x (1)",
+		"file docs/x/y.md staged=true",
+		"0 dir src (4)",
+		"file src/a.go staged=true",
+		"file src/a.go staged=false",
+		"file src/b.go staged=false",
+		"file src/new/ staged=false",
+		"file README.md staged=false",
+		"file main.go staged=false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expanded tree:\n got %q\nwant %q", got, want)
+	}
+
+	got = describe(items(map[string]bool{"src": true}))
+	want = []string{
+		"0 dir docs (1)",
+		"1 dir docs/x (1)",
+		"file docs/x/y.md staged=true",
+		"0 dir src (4)",
+		"file README.md staged=false",
+		"file main.go staged=false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("src collapsed:\n got %q\nwant %q", got, want)
+	}
+}
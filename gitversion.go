@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Git Version
+// ---------------------------------------------------------------------------
+
+// stagedStashVersion is the first git with `git stash push --staged`
+var stagedStashVersion = [2]int{2, 35}
+
+// gitVersion is the installed git's major and minor version, from output such as
+// "git version 2.39.5" or "git version 2.37.1 (Apple Git-137.1)"
+func gitVersion() ([2]int, error) {
+	out, err := exec.Command("git", "version").Output()
+	if err != nil {
+		return [2]int{}, err
+	}
+	var v [2]int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(strings.TrimSpace(string(out)), "git version "), "%d.%d", &v[0], &v[1]); err != nil {
+		return [2]int{}, fmt.Errorf("unrecognized git version %q", strings.TrimSpace(string(out)))
+	}
+	return v, nil
+}
+
+// gitAtLeast reports whether the installed git is version want or newer. If the
+// version can't be told, it's assumed to be new enough and git will say otherwise.
+func gitAtLeast(want [2]int) bool {
+	v, err := gitVersion()
+	if err != nil {
+		return true
+	}
+	return v[0] > want[0] || v[0] == want[0] && v[1] >= want[1]
+}
+
+// stagedStashBlocked explains why the index can't be stashed on its own right now,
+// or is "" if it can be
+func (m model) stagedStashBlocked() string {
+	if !m.stagedStash {
+		return fmt.Sprintf("Stashing only staged changes needs git %d.%d or newer", stagedStashVersion[0], stagedStashVersion[1])
+	}
+	return m.stashBlocked()
+}
+
+// stagedFiles counts the changed files with changes in the index
+func (m model) stagedFiles() int {
+	n := 0
+	for _, f := range m.changedFiles {
+		if f.IsStaged {
+			n++
+		}
+	}
+	return n
+}
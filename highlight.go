@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Diff Highlighting
+// ---------------------------------------------------------------------------
+
+// Diffs are fetched with --no-color and styled here, so they look the same in every
+// pane no matter how the user's git colors are set up.
+
+var (
+	diffNoteStyle     = lipgloss.NewStyle().Faint(true)
+	removedWordStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Strikethrough(true)
+	statBarPattern    = regexp.MustCompile(`^(.*\| +\d+ )(\+*)(-*)$`)
+	hunkHeaderPattern = regexp.MustCompile(`^(@@+ [^@]* @@+)(.*)$`)
+)
+
+// colorizeDiff styles plain unified diff output: file headers, hunk headers, additions
+// and deletions. Anything before the first file header is left alone.
+func colorizeDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	inHeader := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			inHeader = true
+			lines[i] = titleStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			inHeader = false
+			lines[i] = colorizeHunkHeader(line)
+		case inHeader:
+			lines[i] = titleStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = addedLineStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = removedLineStyle.Render(line)
+		case strings.HasPrefix(line, `\`):
+			lines[i] = diffNoteStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// colorizeHunkHeader styles the line ranges of a hunk header, leaving the function
+// context git adds after them plain
+func colorizeHunkHeader(line string) string {
+	match := hunkHeaderPattern.FindStringSubmatch(line)
+	if match == nil {
+		return hunkHeaderStyle.Render(line)
+	}
+	return hunkHeaderStyle.Render(match[1]) + match[2]
+}
+
+// colorizeWordDiff turns `--word-diff=porcelain` output into styled lines. In a hunk,
+// each line of porcelain output is a run of unchanged (" "), removed ("-") or added
+// ("+") text, and "~" ends a line of the file.
+func colorizeWordDiff(diff string) string {
+	var out []string
+	var line strings.Builder
+	inHunk := false
+	for _, raw := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(raw, "diff --git "):
+			inHunk = false
+			out = append(out, titleStyle.Render(raw))
+		case strings.HasPrefix(raw, "@@"):
+			inHunk = true
+			out = append(out, colorizeHunkHeader(raw))
+		case !inHunk:
+			out = append(out, titleStyle.Render(raw))
+		case raw == "~":
+			out = append(out, line.String())
+			line.Reset()
+		case strings.HasPrefix(raw, "-"):
+			line.WriteString(removedWordStyle.Render(raw[1:]))
+		case strings.HasPrefix(raw, "+"):
+			line.WriteString(addedLineStyle.Render(raw[1:]))
+		case strings.HasPrefix(raw, " "):
+			line.WriteString(raw[1:])
+		case raw != "":
+			// e.g. "\ No newline at end of file"
+			out = append(out, diffNoteStyle.Render(raw))
+		}
+	}
+	if line.Len() > 0 {
+		out = append(out, line.String())
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// colorizeStat colors the +/- bars of `--stat` output
+func colorizeStat(stat string) string {
+	lines := strings.Split(stat, "\n")
+	for i, line := range lines {
+		if match := statBarPattern.FindStringSubmatch(line); match != nil {
+			lines[i] = match[1] + addedLineStyle.Render(match[2]) + removedLineStyle.Render(match[3])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Stash Message History
+// ---------------------------------------------------------------------------
+
+// messageHistoryMax is how many stash messages packrat.messageHistory keeps
+const messageHistoryMax = 20
+
+type messageHistorySavedMsg struct {
+	err error
+}
+
+// rememberMessage puts a stash message at the front of the history, dropping an
+// earlier copy of it and anything past messageHistoryMax
+func (m *model) rememberMessage(message string) tea.Cmd {
+	history := []string{message}
+	for _, old := range m.messageHistory {
+		if old != message && len(history) < messageHistoryMax {
+			history = append(history, old)
+		}
+	}
+	m.messageHistory = history
+	return saveMessageHistory(history)
+}
+
+// saveMessageHistory rewrites packrat.messageHistory in the repository's config. git
+// config lists values in the order they were added, so the newest goes in last.
+func saveMessageHistory(history []string) tea.Cmd {
+	return func() tea.Msg {
+		// Exits 5 when there's nothing to unset yet, which is fine
+		exec.Command("git", "config", "--unset-all", "packrat.messageHistory").Run()
+		for _, message := range slices.Backward(history) {
+			if out, err := exec.Command("git", "config", "--add", "packrat.messageHistory", message).CombinedOutput(); err != nil {
+				return messageHistorySavedMsg{err: fmt.Errorf("%v: %s", err, out)}
+			}
+		}
+		return messageHistorySavedMsg{}
+	}
+}
+
+// prefillStashMessage starts the stash modal with the last message used, or when there
+// isn't one, a suggestion from the branch and what's being stashed. Either is selected,
+// so typing replaces it.
+func (m *model) prefillStashMessage() {
+	message := ""
+	if len(m.messageHistory) > 0 {
+		message = m.messageHistory[0]
+		m.historyIndex = 0
+	} else {
+		message = m.suggestedMessage()
+		m.historyIndex = -1
+	}
+	m.historyDraft = ""
+	m.stashInput.SetValue(message)
+	m.stashInput.CursorEnd()
+	m.stashPrefilled = message != ""
+}
+
+// suggestedMessage is a first stash message, e.g. "WIP on main: 3 files"
+func (m model) suggestedMessage() string {
+	what := ""
+	switch m.stashScope {
+	case ScopeSelection:
+		what = fmt.Sprintf("%d file(s)", len(m.selectedFiles))
+	case ScopeStaged:
+		what = "staged changes"
+	default:
+		what = "all changes"
+	}
+	if m.currentBranch == "" {
+		return "WIP: " + what
+	}
+	return fmt.Sprintf("WIP on %s: %s", m.currentBranch, what)
+}
+
+// stepHistory moves through the history with up (older) and down (newer). Going down
+// past the newest brings back what was in the box before.
+func (m *model) stepHistory(older bool) {
+	next := m.historyIndex - 1
+	if older {
+		next = m.historyIndex + 1
+	}
+	if next < -1 || next >= len(m.messageHistory) {
+		return
+	}
+	if m.historyIndex == -1 {
+		m.historyDraft = m.stashInput.Value()
+	}
+	m.historyIndex = next
+	if next == -1 {
+		m.stashInput.SetValue(m.historyDraft)
+	} else {
+		m.stashInput.SetValue(m.messageHistory[next])
+	}
+	m.stashInput.CursorEnd()
+	m.stashPrefilled = m.stashInput.Value() != ""
+}
+
+// updatePrefilled treats a prefilled message as selected: typing or deleting replaces
+// it whole, and any other key just deselects it
+func (m *model) updatePrefilled(msg tea.KeyMsg) (handled bool) {
+	if !m.stashPrefilled {
+		return false
+	}
+	m.stashPrefilled = false
+	switch msg.Type {
+	case tea.KeyRunes, tea.KeySpace:
+		m.stashInput.SetValue("")
+	case tea.KeyBackspace, tea.KeyDelete:
+		m.stashInput.SetValue("")
+		return true
+	}
+	return false
+}
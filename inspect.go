@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Inspect
+// ---------------------------------------------------------------------------
+
+// inspectFile is a file of the inspected stash in the left pane
+type inspectFile struct {
+	treeFile
+}
+
+func (f inspectFile) Title() string       { return f.Status + " " + f.Path }
+func (f inspectFile) Description() string { return "" }
+func (f inspectFile) FilterValue() string { return f.Path }
+
+type inspectFilesMsg struct {
+	ref   string
+	files []treeFile
+	err   error
+}
+
+// inspectDiffMsg is one file's diff, fetched when it's first expanded
+type inspectDiffMsg stashFileDiffMsg
+
+func getInspectFiles(ref string) tea.Cmd {
+	return func() tea.Msg {
+		files, err := listStashFiles(ref)
+		return inspectFilesMsg{ref: ref, files: files, err: err}
+	}
+}
+
+func getInspectDiff(ref string, file treeFile) tea.Cmd {
+	fetch := getStashFileDiff(ref, file)
+	return func() tea.Msg {
+		return inspectDiffMsg(fetch().(stashFileDiffMsg))
+	}
+}
+
+// updateInspect handles keys while a stash's files are listed one by one
+func (m model) updateInspect(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.appState = StateExplore
+		// Put the whole stash back in the diff pane
+		return m, m.showStashDiff(m.inspectRef, false)
+	case "enter", " ": // Expand or collapse the file, loading its diff the first time
+		f, ok := m.inspectList.SelectedItem().(inspectFile)
+		if !ok {
+			return m, nil
+		}
+		m.inspectExpanded[f.Path] = !m.inspectExpanded[f.Path]
+		m.showInspectView()
+		if _, loaded := m.inspectDiffs[f.Path]; m.inspectExpanded[f.Path] && !loaded {
+			return m, getInspectDiff(m.inspectRef, f.treeFile)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if m.focus == PaneDiff {
+		m.viewport, cmd = m.viewport.Update(msg)
+	} else {
+		m.inspectList, cmd = m.inspectList.Update(msg)
+		// The highlighted row on the right follows the list
+		m.showInspectView()
+	}
+	return m, cmd
+}
+
+// inspectView lists every file of the inspected stash, with the diffs of the expanded
+// ones under them, and reports which line the selected file is on
+func (m model) inspectView() (string, int) {
+	selected := ""
+	if f, ok := m.inspectList.SelectedItem().(inspectFile); ok {
+		selected = f.Path
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Files in %s: %d\n\n", m.inspectRef, len(m.inspectList.Items())))
+	line, selectedLine := 2, 0
+	for _, item := range m.inspectList.Items() {
+		f := item.(inspectFile)
+		indicator := cfg.Glyphs.Collapsed
+		if m.inspectExpanded[f.Path] {
+			indicator = cfg.Glyphs.Expanded
+		}
+		row := fmt.Sprintf("%s %s %s", indicator, f.Status, f.Path)
+		if f.Path == selected {
+			row = stashFileCursorStyle.Render(row)
+			selectedLine = line
+		}
+		b.WriteString(row + "\n")
+		line++
+		if !m.inspectExpanded[f.Path] {
+			continue
+		}
+		diff, loaded := m.inspectDiffs[f.Path]
+		if !loaded {
+			diff = statusStyle.Render("(loading)")
+		}
+		diff = strings.TrimRight(diff, "\n") + "\n\n"
+		b.WriteString(diff)
+		line += strings.Count(diff, "\n")
+	}
+	return b.String(), selectedLine
+}
+
+// showInspectView redraws the right pane, keeping the selected file in view
+func (m *model) showInspectView() {
+	content, line := m.inspectView()
+	m.viewport.SetContent(clampLines(content, cfg.MaxLineWidth))
+	if line < m.viewport.YOffset || line >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(line)
+	}
+}
+
+// newInspectList is Inspect's file list, one line per file like the stash tree
+func newInspectList() list.Model {
+	delegate := list.NewDefaultDelegate()
+	delegate.ShowDescription = false
+	delegate.SetSpacing(0)
+	l := list.New([]list.Item{}, delegate, 30, 10)
+	l.Title = "Packrat - Inspect"
+	return l
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Line Counts
+// ---------------------------------------------------------------------------
+
+// lineCount is how many lines a change adds and removes
+type lineCount struct {
+	Added, Deleted int
+	Binary         bool // git doesn't count lines in binary files
+}
+
+// numstat reads `git diff --numstat -z` for the index or the working tree, keyed by
+// path. With -z, a rename leaves the path field empty and gives the old and new paths
+// as records of their own.
+func numstat(staged bool) map[string]lineCount {
+	args := []string{"diff", "--numstat", "-z", "-M"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	out, err := exec.Command("git", args...).Output()
+	counts := make(map[string]lineCount)
+	if err != nil {
+		return counts
+	}
+	records := strings.Split(string(out), "\x00")
+	for i := 0; i < len(records); i++ {
+		fields := strings.SplitN(records[i], "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		path := fields[2]
+		if path == "" && i+2 < len(records) {
+			path = records[i+2]
+			i += 2
+		}
+		var c lineCount
+		if fields[0] == "-" {
+			c.Binary = true
+		} else {
+			c.Added, _ = strconv.Atoi(fields[0])
+			c.Deleted, _ = strconv.Atoi(fields[1])
+		}
+		counts[path] = c
+	}
+	return counts
+}
+
+// fileLineCount counts the lines in a file git doesn't track, where every line is an
+// addition. A NUL in the first block marks it binary, as git's own check does.
+func fileLineCount(path string) lineCount {
+	f, err := os.Open(path)
+	if err != nil {
+		return lineCount{}
+	}
+	defer f.Close()
+	var c lineCount
+	buf := make([]byte, 32*1024)
+	first, last := true, byte('\n')
+	for {
+		n, err := f.Read(buf)
+		if first && bytes.IndexByte(buf[:n], 0) >= 0 {
+			return lineCount{Binary: true}
+		}
+		first = false
+		if n > 0 {
+			c.Added += bytes.Count(buf[:n], []byte("\n"))
+			last = buf[n-1]
+		}
+		if err == io.EOF || err != nil {
+			break
+		}
+	}
+	if last != '\n' {
+		c.Added++ // a last line without a newline still counts
+	}
+	return c
+}
+
+// addLineCounts fills in each change's line counts. An untracked directory adds up
+// the files in it.
+func addLineCounts(files []FileChange) {
+	staged, unstaged := numstat(true), numstat(false)
+	for i, f := range files {
+		switch {
+		case f.IsConflicted:
+		case f.IsUntracked:
+			paths, _ := untrackedPaths(f)
+			for _, p := range paths {
+				c := fileLineCount(p)
+				files[i].Lines.Added += c.Added
+				files[i].Lines.Binary = files[i].Lines.Binary || c.Binary
+			}
+		case f.IsStaged:
+			files[i].Lines = staged[f.Path]
+		default:
+			files[i].Lines = unstaged[f.Path]
+		}
+	}
+}
+
+// String is "+12 −3", or "bin" for a binary file. A directory of untracked files with
+// binaries among them gets both.
+func (c lineCount) String() string {
+	switch {
+	case c.Binary && c.Added == 0 && c.Deleted == 0:
+		return "bin"
+	case c.Binary:
+		return fmt.Sprintf("+%d −%d, bin", c.Added, c.Deleted)
+	}
+	return fmt.Sprintf("+%d −%d", c.Added, c.Deleted)
+}
+
+// selectionTotal sums the selected files' line counts, e.g. "3 files, +142 −17"
+func (m model) selectionTotal() string {
+	var total lineCount
+	for _, f := range m.selectedFiles {
+		total.Added += f.Lines.Added
+		total.Deleted += f.Lines.Deleted
+	}
+	return fmt.Sprintf("%d files, %s", len(m.selectedFiles), total)
+}
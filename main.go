@@ -3,83 +3,371 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 type Stash struct {
 	Ref, Message, Created string
+	Branch                string    // branch the stash was taken on, parsed from the reflog subject
+	Extra                 string    // output of the user's packrat.stashFormat, if any
+	Sha, BaseSha          string    // the stash commit and the commit it was taken on top of
+	Timestamp             time.Time // committer date of the stash commit
+	Duplicates            int       // how many other listed stashes have the same message
+	Pinned                bool      // kept at the top of the list, toggled with *
 }
 
-func (s Stash) Title() string       { return s.Message }
-func (s Stash) Description() string { return fmt.Sprintf("%s (%s)", s.Ref, s.Created) }
-func (s Stash) FilterValue() string { return s.Message }
+func (s Stash) Title() string {
+	title := s.displayMessage()
+	if s.Duplicates > 0 {
+		// Identical messages need something on the title line to tell them apart
+		title = fmt.Sprintf("%s — %s", title, s.Created)
+	}
+	if i, ok := s.Index(); ok {
+		title = fmt.Sprintf("#%d %s", i, title)
+	}
+	if s.Pinned {
+		title = cfg.Glyphs.Pinned + " " + title
+	}
+	return title
+}
+func (s Stash) Description() string {
+	desc := fmt.Sprintf("%s (%s)", s.Ref, s.Created)
+	if label := stashAgeLabel[cfg.Ages.ageOf(s.Timestamp, time.Now())]; label != "" {
+		desc += " · " + label
+	}
+	if s.Extra != "" {
+		desc += " · " + s.Extra
+	}
+	return desc
+}
+func (s Stash) FilterValue() string {
+	if strings.TrimSpace(s.Message) == "" {
+		return s.Ref
+	}
+	if s.Duplicates > 0 {
+		// Let the filter narrow same-named stashes down by ref
+		return s.Message + " " + s.Ref
+	}
+	return s.Message
+}
+
+// displayMessage substitutes a placeholder for empty or whitespace-only messages so
+// the stash never renders as a blank list entry
+func (s Stash) displayMessage() string {
+	if strings.TrimSpace(s.Message) == "" {
+		return "(no message) " + s.Ref
+	}
+	return s.Message
+}
+
+// stashGroupHeader is a non-selectable row naming the branch of the stashes below it
+type stashGroupHeader struct {
+	Branch string
+	Count  int
+}
+
+func (h stashGroupHeader) Title() string { return h.Branch }
+func (h stashGroupHeader) Description() string {
+	if h.Count == 1 {
+		return "1 stash"
+	}
+	return fmt.Sprintf("%d stashes", h.Count)
+}
+func (h stashGroupHeader) FilterValue() string { return "" } // never matches a filter
+
+// markedStash is a Stash the user has marked for a bulk drop
+type markedStash struct {
+	Stash
+}
+
+func (s markedStash) Title() string { return "[x] " + s.Stash.Title() }
+
+// stashDelegate renders stashes with the default delegate and group headers as dividers
+type stashDelegate struct {
+	list.DefaultDelegate
+	marked map[string]bool // SHA -> marked; shared with the model, so it must be cleared rather than replaced
+}
+
+func (d stashDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	if h, ok := item.(stashGroupHeader); ok {
+		fmt.Fprintf(w, "%s\n%s", groupHeaderStyle.Render("── "+h.Title()+" ──"), groupCountStyle.Render(h.Description()))
+		return
+	}
+	if s, ok := item.(Stash); ok {
+		// d is a copy, so recoloring its styles only affects this entry
+		if color, ok := stashAgeColor[cfg.Ages.ageOf(s.Timestamp, time.Now())]; ok {
+			d.Styles.NormalTitle = d.Styles.NormalTitle.Foreground(color)
+			d.Styles.NormalDesc = d.Styles.NormalDesc.Foreground(color)
+		}
+		if d.marked[s.Sha] {
+			item = markedStash{s}
+		}
+	}
+	d.DefaultDelegate.Render(w, m, index, item)
+}
+
+// Index parses the stash number out of a ref like "stash@{3}"
+func (s Stash) Index() (int, bool) {
+	inner, ok := strings.CutPrefix(s.Ref, "stash@{")
+	if !ok || !strings.HasSuffix(inner, "}") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimSuffix(inner, "}"))
+	return i, err == nil
+}
 
 type FileChange struct {
 	Path     string
+	OldPath  string // where a staged rename or copy came from, empty otherwise
 	Status   string // e.g., "M" (modified), "A" (added), "D" (deleted), etc.
 	IsStaged bool
+	// IsUntracked marks a file git doesn't track yet (status "?"). A directory of them
+	// comes as one entry whose path ends in a slash.
+	IsUntracked bool
+	// IsConflicted marks a path left unmerged by a merge, rebase or the like. Its
+	// Status is both of git's letters, such as "UU", and it's listed once.
+	IsConflicted bool
+	// PartlyStaged marks a path with changes both in the index and the working tree,
+	// listed once for each. A stash takes both, so both sides' diffs are shown.
+	PartlyStaged bool
+	Lines        lineCount // lines added and removed on this side, from git diff --numstat
 }
 
 func (f FileChange) Title() string {
-	statusIndicator := "  "
-	if f.IsStaged {
-		statusIndicator = "● " // Staged
-	} else {
-		statusIndicator = "○ " // Unstaged
+	statusIndicator := cfg.Glyphs.Unstaged + " "
+	switch {
+	case f.IsConflicted:
+		statusIndicator = cfg.Glyphs.Conflicted + " "
+	case f.IsStaged:
+		statusIndicator = cfg.Glyphs.Staged + " "
+	case f.IsUntracked:
+		statusIndicator = cfg.Glyphs.Untracked + " "
+	}
+	if f.OldPath != "" {
+		return fmt.Sprintf("%s%s %s → %s", statusIndicator, f.Status, f.OldPath, f.Path)
 	}
 	return fmt.Sprintf("%s%s %s", statusIndicator, f.Status, f.Path)
 }
+
+// pathspec lists the paths a diff needs for this change; a rename needs both sides so
+// it's shown as a rename rather than a lone addition. A copy leaves its original
+// untouched, so it only needs the new path. Stashing only takes the new path, since git
+// rejects pathspecs for files that are gone from the index; renameCleanupArgs deals
+// with the original.
+func (f FileChange) pathspec() []string {
+	if f.OldPath != "" && f.Status == "R" {
+		return []string{f.OldPath, f.Path}
+	}
+	return []string{f.Path}
+}
 func (f FileChange) Description() string {
-	if f.IsStaged {
-		return "staged"
+	switch {
+	case f.IsConflicted:
+		return "conflicted, " + unmergedStatuses[f.Status]
+	case f.IsStaged && f.PartlyStaged:
+		return "staged, also unstaged · " + f.Lines.String()
+	case f.IsStaged:
+		return "staged · " + f.Lines.String()
+	case f.PartlyStaged:
+		return "unstaged, also staged · " + f.Lines.String()
+	case f.IsUntracked:
+		return "untracked · " + f.Lines.String()
 	}
-	return "unstaged"
+	return "unstaged · " + f.Lines.String()
 }
 func (f FileChange) FilterValue() string { return f.Path }
 
+// reviewedFile is a FileChange the user has marked as reviewed
+type reviewedFile struct {
+	FileChange
+}
+
+func (f reviewedFile) Title() string { return f.FileChange.Title() + " ✓" }
+
+// fileDelegate renders changed files with the default delegate, marking reviewed ones
+type fileDelegate struct {
+	list.DefaultDelegate
+	reviewed map[string]bool // shared with the model, so it must be cleared rather than replaced
+}
+
+func (d fileDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	if f, ok := item.(FileChange); ok && d.reviewed[f.Path] {
+		item = reviewedFile{f}
+	}
+	conflictStyled(d.DefaultDelegate, item).Render(w, m, index, item)
+}
+
+// applyConflict is a file that a dry-run apply couldn't patch, and why
+type applyConflict struct {
+	Path, Reason string
+}
+
+// conflictedFile is a file an apply left unmerged, with how many conflicts it holds
+type conflictedFile struct {
+	Path    string
+	Markers int // number of "<<<<<<<" markers in the working tree copy
+}
+
+// overviewEntry is a file that appears in one or more stashes
+type overviewEntry struct {
+	Path string
+	Refs []string // stashes that touch this file
+}
+
+func (o overviewEntry) Title() string { return fmt.Sprintf("%d× %s", len(o.Refs), o.Path) }
+func (o overviewEntry) Description() string {
+	return strings.Join(o.Refs, ", ")
+}
+func (o overviewEntry) FilterValue() string { return o.Path }
+
 // ---------------------------------------------------------------------------
 // Messages
 // ---------------------------------------------------------------------------
 type stashDiffMsg struct {
-	ref  string
-	diff string
-	err  error
+	ref        string
+	diff       string
+	summarized bool        // diff is a --stat summary because the full diff is over budget
+	stat       bool        // diff is the --stat view toggled with t
+	opts       diffOptions // how the diff was rendered; zero for --stat views
+	truncated  bool        // only the first chunks of a huge diff; L reads more
+	err        error
 }
 type stashDeletedMsg struct {
-	ref string
-	err error
-}
-type stashAppliedMsg struct {
 	ref    string
+	popped bool   // this drop finishes a pop
+	output string // the pop's apply output
+	err    error
+}
+type stashesDroppedMsg struct {
+	dropped  int
+	failures []string // one line per stash that couldn't be dropped
+	err      error
+}
+type stashCompareMsg struct {
+	base, other string // refs; the diff goes from base to other
+	diff        string
+	err         error
+}
+type stashRenamedMsg struct {
+	sha    string
 	output string
 	err    error
 }
+type stashAppliedMsg struct {
+	ref       string
+	sha       string // what ref resolved to when the user picked it
+	output    string
+	conflicts []conflictedFile // files left with conflict markers by a failed apply
+	pop       bool             // drop the stash once it has applied cleanly
+	index     bool             // applied with --index to restore the staged changes
+	err       error
+}
 type changedFilesMsg struct {
-	files []FileChange
-	err   error
+	files     []FileChange
+	stat      worktreeStat
+	operation repoOp               // merge, rebase or the like stopped partway, if any
+	mtimes    map[string]time.Time // path -> modification time, for spotting edits git reports the same way
+	err       error
 }
 type fileDiffMsg struct {
-	path string
-	diff string
-	err  error
+	path       string
+	diff       string
+	summarized bool // diff is a --stat summary because the full diff is over budget
+	refreshed  bool // re-fetched because the file changed on disk
+	keepScroll bool // re-fetched with other diff options, so the pane stays put
+	staged     bool // the index side of the path, rather than the working tree
+	opts       diffOptions
+	err        error
 }
-type stashCreatedMsg struct {
+type stashBranchedMsg struct {
+	ref    string
+	branch string
 	output string
 	err    error
 }
+type stashCreatedMsg struct {
+	output   string
+	sha      string // commit of the new stash, for --print-ref
+	message  string // remembered in packrat.messageHistory once the stash is made
+	saved    bool   // the stash was made even though something failed afterwards
+	snapshot bool   // the stash was applied back, leaving the working tree as it was
+	err      error
+}
 type workingDirectoryRestoredMsg struct {
 	output string
 	err    error
 }
+type fileHunksMsg struct {
+	file  FileChange
+	patch filePatch
+	err   error
+}
+type hunksStagedMsg struct {
+	patch  string // what was staged, so it can be taken back out
+	output string
+	err    error
+}
+type stashesLoadedMsg struct {
+	stashes     []Stash
+	shared      bool   // listed from cfg.SharedRefs rather than refs/stash
+	selectFirst bool   // move the cursor to the newest stash, e.g. after creating one
+	branch      string // branch checked out now, as stash subjects name it
+	err         error
+}
+type rawStashListMsg struct {
+	raw string // git's own listing, byte for byte
+	err error
+}
+type typeAheadTimeoutMsg struct {
+	seq int // only the most recent keystroke's timer may clear the buffer
+}
+type applyPreviewMsg struct {
+	ref       string
+	conflicts []applyConflict // files that wouldn't apply cleanly
+	output    string          // raw `git apply --check` output, for anything unparsed
+	err       error           // set when the check itself couldn't run
+}
+type stashTreeMsg struct {
+	ref   string
+	files []treeFile
+	err   error
+}
+type stashFileDiffMsg struct {
+	ref  string
+	path string
+	diff string
+	err  error
+}
+type diffOpenedMsg struct {
+	path string
+	err  error
+}
+type stashOverviewMsg struct {
+	entries []overviewEntry
+	err     error
+}
 
 // ---------------------------------------------------------------------------
 // States
@@ -92,6 +380,15 @@ const (
 	ModeBuild
 )
 
+// Pane identifies which side of the screen receives navigation keys
+type Pane int
+
+const (
+	PaneList  Pane = iota // the stash/file list on the left
+	PaneDiff              // the diff viewport on the right
+	PaneFiles             // the stash files panel above the diff, when it's shown
+)
+
 type AppState int
 
 const (
@@ -99,15 +396,72 @@ const (
 	StateDelete
 	StateInspect
 	StateCleanUp
+	StateHunkSelect
+	StateOverview
+	StateStashTree
+	StatePartialApply
+	StateRecover
 )
 
 var stateName = map[AppState]string{
-	StateExplore: "explore",
-	StateDelete:  "delete",
-	StateInspect: "inspect",
-	StateCleanUp: "cleanup",
+	StateExplore:      "explore",
+	StateDelete:       "delete",
+	StateInspect:      "inspect",
+	StateCleanUp:      "cleanup",
+	StateHunkSelect:   "hunks",
+	StateOverview:     "overview",
+	StateStashTree:    "tree",
+	StatePartialApply: "partial",
+	StateRecover:      "recover",
+}
+
+// fileSortMode is the order of the Build Mode file list, cycled with o
+type fileSortMode int
+
+const (
+	SortGitStatus fileSortMode = iota
+	SortByPath
+	SortByStatus
+	SortByDirectory
+	SortStagedFirst
+	fileSortModeCount
+)
+
+var fileSortModeName = map[fileSortMode]string{
+	SortGitStatus:   "git order",
+	SortByPath:      "by path",
+	SortByStatus:    "by status",
+	SortByDirectory: "by directory",
+	SortStagedFirst: "staged first",
+}
+
+// stashSortMode is the order of the Explore Mode stash list, cycled with o
+type stashSortMode int
+
+const (
+	SortReflog stashSortMode = iota
+	SortNewest
+	SortOldest
+	SortByMessage
+	stashSortModeCount
+)
+
+var stashSortModeName = map[stashSortMode]string{
+	SortReflog:    "reflog",
+	SortNewest:    "newest",
+	SortOldest:    "oldest",
+	SortByMessage: "message",
 }
 
+// StashScope decides which changes a new stash is built from
+type StashScope int
+
+const (
+	ScopeSelection StashScope = iota // Only the files selected in Build Mode
+	ScopeStaged                      // Everything currently in the index (git stash push --staged)
+	ScopeAll                         // Every change in the working tree, untracked files too unless turned off
+)
+
 // ---------------------------------------------------------------------------
 // Modal Types
 // ---------------------------------------------------------------------------
@@ -120,6 +474,20 @@ const (
 	ModalApplyConfirm
 	ModalStashMessage
 	ModalRestoreConfirm
+	ModalApplyPreview
+	ModalRawStashList
+	ModalRestoreSession
+	ModalCheatsheet
+	ModalGlobSelect
+	ModalPopConfirm
+	ModalStashBranch
+	ModalExportStash
+	ModalRenameStash
+	ModalPartialOverwrite
+	ModalStaleStash
+	ModalPathFilter
+	ModalClearStashes
+	ModalRecoverConfirm
 )
 
 // ---------------------------------------------------------------------------
@@ -134,41 +502,206 @@ type model struct {
 	activeModal ModalType // The type of modal currently displayed (ModalNone if no modal)
 	appState    AppState  // The state of the app at any given moment
 	mode        Mode      // Current mode: Explore or Build
+	status      string    // One-line feedback shown under the help header, cleared on the next key
+	fullSHA     bool      // show 40-character SHAs instead of abbreviated ones
+	showHeader  bool      // show the key help above the diff pane
+	focus       Pane      // Pane that receives navigation keys
 
 	// Explore Mode fields
-	stashList   list.Model
-	viewport    viewport.Model
-	diff        string
-	selectedRef string
+	stashList      list.Model
+	viewport       viewport.Model
+	diff           string
+	selectedRef    string
+	displayedRef   string          // stash whose diff is currently in the viewport ("" if something else is shown)
+	preview        applyPreviewMsg // result of the last dry-run apply, shown in ModalApplyPreview
+	modalViewport  viewport.Model  // scrollable body for modals too tall for the screen
+	headerHeight   int             // lines the help header wrapped to when the panes were last sized
+	diffSummarized bool            // the displayed diff is a --stat summary; F loads the full diff
+	stashes        []Stash         // every stash, in reflog order, as last loaded
+	stashesLoaded  bool            // the first listing has arrived
+	showShared     bool            // list shared stashes from cfg.SharedRefs instead of the local stash
+	groupByBranch  bool            // show the stash list under per-branch headers
+	branchOnly     bool            // list only stashes taken on currentBranch
+	stashSortMode  stashSortMode   // how stashList orders the stashes
+	currentBranch  string          // branch checked out when the stashes were last loaded
+	branchInput    textinput.Model // text input for the name of a branch made from a stash
+	branchSha      string          // SHA of the stash the branch modal was opened for
+	markedStashes  map[string]bool // SHA -> marked with x for a bulk drop
+	selectSha      string          // stash to put the cursor on when the list next loads
+	compareBase    string          // stash picked with c to compare the next one against
+	comparing      bool            // the viewport shows a comparison instead of a single stash
+
+	// Apply fields (Explore Mode)
+	applyIndexError string          // why the last apply --index failed; ModalApplyConfirm offers a plain apply
+	staleStash      staleStashError // the stash that changed under an apply or drop, shown in ModalStaleStash
+
+	// Patch export fields (Explore Mode)
+	exportInput     textinput.Model // text input for the file a stash is exported to
+	exportOverwrite bool            // the export file exists and the user was warned; enter again overwrites
+
+	// Stash diff fields (Explore Mode)
+	diffFiles      []diffFileHeader              // where each file starts in the displayed stash diff, for [ and ]
+	statView       bool                          // stashes are shown as --stat summaries instead of diffs
+	diffOpts       diffOptions                   // how stash diffs are rendered, e.g. word diff
+	splitView      bool                          // show diffs side by side when the pane is wide enough
+	stashDiffCache map[stashDiffKey]stashDiffMsg // diffs and --stat views fetched since the list last loaded
+
+	// Path filter fields (Explore Mode)
+	pathInput         textinput.Model // path typed into ModalPathFilter
+	pathFilter        string          // list only stashes touching a path containing this
+	pathHits          map[string]bool // SHA -> touches pathFilter
+	pathSearchSeq     int             // numbers searches, so answers to an old one are dropped
+	pathSearchPending int             // stashes still to answer the current search
+	pathSearchErrs    []string        // stashes that couldn't be read in the current search
+
+	// Pin fields (Explore Mode)
+	pinned map[string]bool // SHA -> pinned, as saved in packrat.pinned
+
+	// Empty state fields
+	changedFilesLoaded bool // the Build Mode list has been filled at least once, so an empty one means a clean tree
+
+	// List title fields; the titles themselves are redone after every update
+	stashListNote string // filters, grouping and sort order of the stash list, after its counts
+	fileListNote  string // sort order of the Build Mode list, after its counts
+
+	// Diff streaming fields (Explore Mode)
+	diffStream    *diffStream // git process the displayed diff is still being read from
+	diffTruncated bool        // only part of the displayed diff is loaded; L loads more, F all of it
+
+	// Diff context fields
+	pendingDiffPosition *diffPosition // where to scroll back to once a re-fetched stash diff arrives
+
+	// Stash metadata fields (Explore Mode)
+	metaRef   string    // stash whose metadata was last requested; other replies are stale
+	stashMeta stashMeta // shown above the diff once loaded
+
+	// Stash files panel fields (Explore Mode)
+	showStashFiles  bool       // the files panel is shown above the stash diff
+	stashFiles      []treeFile // files of the stash in the panel
+	stashFilesRef   string     // stash the panel lists
+	stashFileCursor int        // highlighted row of the panel
+
+	// Diff search fields (Explore Mode)
+	searchInput   textinput.Model // the / prompt, drawn in the status line
+	searchActive  bool            // the prompt is taking keys
+	searchQuery   string          // last query searched for
+	searchedDiff  string          // diff the matches were found in; n and N only work while it's shown
+	searchMatches []diffMatch
+	searchIndex   int // current match
+
+	// Type-ahead jump fields (Explore Mode)
+	typeAheadActive bool   // started with ', ends on timeout, esc or enter
+	typeAhead       string // prefix typed so far
+	typeAheadSeq    int    // bumped on every keystroke to invalidate older timers
+
+	// Stash file tree fields (Explore Mode)
+	treeList      list.Model          // visible rows of the tree
+	treeRoot      *treeNode[treeFile] // files of treeRef arranged by directory
+	treeCollapsed map[string]bool     // directory path -> collapsed
+	treeRef       string              // stash the tree belongs to
+
+	// Partial apply fields (Explore Mode)
+	partialList    list.Model // files of partialRef, picked with space
+	partialRef     string     // stash the picker lists
+	partialPending []treeFile // picked files waiting on ModalPartialOverwrite
+	partialDirty   []string   // paths among partialPending with local changes
+
+	// Inspect fields (Explore Mode)
+	inspectList     list.Model        // files of inspectRef
+	inspectRef      string            // stash being inspected
+	inspectExpanded map[string]bool   // path -> diff shown under the file
+	inspectDiffs    map[string]string // path -> diff, fetched on first expand
+
+	// Cleanup fields (Explore Mode)
+	cleanupList    list.Model     // stashes found in HEAD or that couldn't be checked
+	cleanupSeq     int            // numbers each run of checks, so stale verdicts are dropped
+	cleanupPending int            // checks of the current run still running
+	cleanupResults []cleanupEntry // verdicts in so far
+
+	// Recovery fields (Explore Mode)
+	recoverList list.Model // unreachable commits that look like dropped stashes
+
+	// Overview fields (Explore Mode)
+	overviewList   list.Model // every file touched by any stash
+	overviewByPath bool       // sort the overview by path instead of stash count
 
 	// Build Mode fields
-	fileList      list.Model
-	selectedFiles map[string]FileChange // map of path -> FileChange for selected files
-	expandedFiles map[string]bool       // map of path -> expanded state
-	fileDiffs     map[string]string     // map of path -> diff content
-	buildViewport viewport.Model        // viewport for the build mode right pane
-	stashInput    textinput.Model       // text input for stash message
+	fileList          list.Model
+	selectedFiles     map[string]FileChange // map of path -> FileChange for selected files
+	expandedFiles     map[string]bool       // map of path -> expanded state
+	fileDiffs         map[string]string     // map of path -> diff content, for selected and previewed files
+	diffStaged        map[string]bool       // map of path -> its fileDiffs entry is the staged side
+	summarizedFiles   map[string]bool       // map of path -> diff is only a --stat summary
+	reviewedFiles     map[string]bool       // map of path -> user marked the diff as reviewed
+	changedFiles      []FileChange          // working tree changes in git's status order
+	worktreeStat      worktreeStat          // totals for every change, shown in the Build status line
+	fileSortMode      fileSortMode          // how fileList orders changedFiles
+	unifiedPatch      bool                  // show the selection as one patch instead of collapsible files
+	fileTree          bool                  // group the file list by directory
+	cursorFile        *FileChange           // entry to put the cursor on once the file list reloads
+	fileTreeCollapsed map[string]bool       // directory path -> collapsed in the grouped list
+	showSelection     bool                  // v: list the selected files' diffs instead of previewing the highlighted one
+	repoOperation     repoOp                // merge, rebase or the like stopped partway, shown as a banner
+	listMtimes        map[string]time.Time  // map of path -> mtime when the file list last loaded
+	previewFile       *FileChange           // file the right pane is previewing, nil for none
+	previewDiff       string                // its diff, once previewLoaded
+	previewLoaded     bool
+	previewSeq        int                  // bumped on every cursor move, so only the last one's timer fetches
+	fileMtimes        map[string]time.Time // map of path -> mtime the displayed diff was fetched at
+	pendingMtimes     map[string]time.Time // map of path -> newer mtime waiting to settle
+	updatedFiles      map[string]time.Time // map of path -> when its diff was auto-refreshed
+	buildViewport     viewport.Model       // viewport for the build mode right pane
+	stashInput        textinput.Model      // text input for stash message
+	flagsInput        textinput.Model      // text input for extra `git stash push` flags
+	globInput         textinput.Model      // text input for selecting files by glob
+	pendingSession    session              // selection saved by an earlier run, offered in ModalRestoreSession
+	sessionOffered    bool                 // the saved selection is only offered once per run
+	confirmInput      textinput.Model      // text input for type-to-confirm modals
+	stashScope        StashScope           // which changes the stash message modal will stash
+	stashUntracked    bool                 // whether the stash modal passes --include-untracked; Ctrl+u flips it
+	stashKeepIndex    bool                 // whether the stash modal passes --keep-index; Ctrl+k flips it
+	stashSnapshot     bool                 // whether the stash modal re-applies the stash at once, leaving the working tree be; Ctrl+s flips it
+	stagedStash       bool                 // git is new enough for `git stash push --staged`, checked at startup
+	messageHistory    []string             // recent stash messages, newest first, as saved in packrat.messageHistory
+	historyIndex      int                  // the history entry in the stash modal's message, or -1 for none
+	historyDraft      string               // what was typed before Up went into the history
+	stashPrefilled    bool                 // the stash modal's message was filled in and is still selected
+	createdShas       []string             // SHAs of stashes created this session, in order
+
+	// Hunk selection fields (Build Mode)
+	hunkFile      FileChange   // file whose hunks are being picked
+	hunkPatch     filePatch    // parsed unstaged diff of hunkFile
+	hunkCursor    int          // index of the highlighted hunk
+	selectedHunks map[int]bool // hunk index -> picked for staging
+	stagedHunks   string       // patch staged by the hunk picker, unstaged again if its stash is cancelled
 }
 
 func initialModel() model {
-	stashes, err := listStashes()
-	items := make([]list.Item, len(stashes))
-	for i, s := range stashes {
-		items[i] = s
-	}
-	l := list.New(items, list.NewDefaultDelegate(), 30, 10)
+	marked := make(map[string]bool)
+	l := list.New([]list.Item{}, stashDelegate{list.NewDefaultDelegate(), marked}, 30, 10)
 	l.Title = "Packrat - Explore Mode"
 
 	vp := viewport.New(80, 20)
-	vp.Style = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(1)
+	vp.Style = viewportStyle
+
+	// Stash file tree list, one line per row
+	treeDelegate := list.NewDefaultDelegate()
+	treeDelegate.ShowDescription = false
+	treeDelegate.SetSpacing(0)
+	tree := list.New([]list.Item{}, treeDelegate, 30, 10)
+	tree.Title = "Packrat - Stash Files"
+
+	overview := list.New([]list.Item{}, list.NewDefaultDelegate(), 30, 10)
+	overview.Title = "Packrat - Files Across Stashes"
 
 	// Build mode list
-	fileList := list.New([]list.Item{}, list.NewDefaultDelegate(), 30, 10)
+	reviewed := make(map[string]bool)
+	fileList := list.New([]list.Item{}, fileDelegate{list.NewDefaultDelegate(), reviewed}, 30, 10)
 	fileList.Title = "Packrat - Build Mode"
 
 	// Build mode viewport
 	buildVp := viewport.New(80, 20)
-	buildVp.Style = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(1)
+	buildVp.Style = viewportStyle
 
 	// Text input for stash message
 	ti := textinput.New()
@@ -177,37 +710,121 @@ func initialModel() model {
 	ti.CharLimit = 200
 	ti.Width = 50
 
-	return model{
-		stashList:     l,
-		viewport:      vp,
-		appState:      StateExplore,
-		mode:          ModeExplore,
-		err:           err,
-		fileList:      fileList,
-		selectedFiles: make(map[string]FileChange),
-		expandedFiles: make(map[string]bool),
-		fileDiffs:     make(map[string]string),
-		buildViewport: buildVp,
-		stashInput:    ti,
+	// Text input for advanced stash push flags
+	fi := textinput.New()
+	fi.Placeholder = "Extra flags, e.g. --keep-index"
+	fi.CharLimit = 100
+	fi.Width = 50
+
+	// Text input for a glob of files to select
+	gi := textinput.New()
+	gi.Placeholder = "src/**/*.go"
+	gi.CharLimit = 200
+	gi.Width = 50
+
+	// Text input for the name of a branch made from a stash
+	bi := textinput.New()
+	bi.Placeholder = "new-branch-name"
+	bi.CharLimit = 100
+	bi.Width = 50
+
+	// Text input for the file a stash's patch is written to
+	ei := textinput.New()
+	ei.CharLimit = 255
+	ei.Width = 50
+
+	// Text input for searching the stash diff
+	si := textinput.New()
+	si.Prompt = "/"
+	si.CharLimit = 200
+
+	// Text input for the path stashes are filtered by
+	pi := textinput.New()
+	pi.Placeholder = "config/database.yml"
+	pi.CharLimit = 255
+	pi.Width = 50
+	pi.ShowSuggestions = true
+
+	// Text input for typing an operation's name to confirm it
+	ci := textinput.New()
+	ci.CharLimit = 20
+	ci.Width = 20
+
+	m := model{
+		stashList:         l,
+		overviewList:      overview,
+		treeList:          tree,
+		partialList:       newPartialList(),
+		inspectList:       newInspectList(),
+		recoverList:       newRecoverList(),
+		cleanupList:       newCleanupList(),
+		viewport:          vp,
+		appState:          StateExplore,
+		mode:              ModeExplore,
+		fileList:          fileList,
+		selectedFiles:     make(map[string]FileChange),
+		expandedFiles:     make(map[string]bool),
+		fileDiffs:         make(map[string]string),
+		diffStaged:        make(map[string]bool),
+		fileTreeCollapsed: make(map[string]bool),
+		summarizedFiles:   make(map[string]bool),
+		stashDiffCache:    make(map[stashDiffKey]stashDiffMsg),
+		markedStashes:     marked,
+		reviewedFiles:     reviewed,
+		fileMtimes:        make(map[string]time.Time),
+		pendingMtimes:     make(map[string]time.Time),
+		updatedFiles:      make(map[string]time.Time),
+		buildViewport:     buildVp,
+		stashInput:        ti,
+		flagsInput:        fi,
+		globInput:         gi,
+		searchInput:       si,
+		branchInput:       bi,
+		exportInput:       ei,
+		confirmInput:      ci,
+		pathInput:         pi,
+		modalViewport:     viewport.New(60, 20),
+		showHeader:        cfg.ShowHeader,
+		pinned:            make(map[string]bool),
+		stagedStash:       gitAtLeast(stagedStashVersion),
+		diffOpts:          diffOptions{Context: defaultDiffContext},
 	}
+	for _, sha := range cfg.Pinned {
+		m.pinned[sha] = true
+	}
+	for _, message := range slices.Backward(cfg.MessageHistory) {
+		m.messageHistory = append(m.messageHistory, message)
+	}
+	// Stashes arrive from Init; until then the list shows its spinner
+	m.stashList.StartSpinner()
+	m.updateListTitles()
+	if len(cfg.Problems) > 0 {
+		m.status = "Config: " + strings.Join(cfg.Problems, "; ")
+	}
+	return m
 }
 
 // ---------------------------------------------------------------------------
 // Init
 // ---------------------------------------------------------------------------
 func (m model) Init() tea.Cmd {
-	if len(m.stashList.Items()) > 0 {
-		ref := m.stashList.SelectedItem().(Stash).Ref
-		return getStashDiff(ref)
+	cmds := []tea.Cmd{m.stashList.StartSpinner(), loadStashes(false, false)}
+	if cfg.AutoRefresh {
+		cmds = append(cmds, watchFiles(nil))
 	}
-	return nil
+	if cfg.RefreshInterval > 0 {
+		cmds = append(cmds, pollChangedFiles())
+	}
+	return tea.Batch(cmds...)
 }
 
 // ---------------------------------------------------------------------------
 // Helper Functions
 // ---------------------------------------------------------------------------
 func listStashes() ([]Stash, error) {
-	cmd := exec.Command("git", "stash", "list", "--pretty=format:%gd|%gs|%cr")
+	// Fields are split on the unit separator, which can't appear in a ref, timestamp or message.
+	// Whatever the user asked for in packrat.stashFormat comes last.
+	cmd := exec.Command("git", "stash", "list", "--pretty=format:%gd%x1f%ct%x1f%H%x1f%P%x1f%gs%x1f"+cfg.StashFormat)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	if err := cmd.Run(); err != nil {
@@ -216,488 +833,3532 @@ func listStashes() ([]Stash, error) {
 	var stashes []Stash
 	scanner := bufio.NewScanner(&out)
 	for scanner.Scan() {
-		parts := strings.SplitN(scanner.Text(), "|", 3)
-		if len(parts) == 3 {
-			stashes = append(stashes, Stash{parts[0], parts[1], parts[2]})
+		parts := strings.SplitN(scanner.Text(), "\x1f", 6)
+		if len(parts) == 6 {
+			unix, _ := strconv.ParseInt(parts[1], 10, 64)
+			ts := time.Unix(unix, 0)
+			base, _, _ := strings.Cut(parts[3], " ")
+			stashes = append(stashes, Stash{
+				Ref:       parts[0],
+				Message:   parts[4],
+				Created:   formatTimestamp(ts, cfg.DateFormat, time.Now()),
+				Branch:    parseStashBranch(parts[4]),
+				Extra:     parts[5],
+				Sha:       parts[2],
+				BaseSha:   base,
+				Timestamp: ts,
+			})
 		}
 	}
 	return stashes, scanner.Err()
 }
 
+// openInBrowser hands a file to the platform's default opener
+func openInBrowser(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	// Don't wait around for the browser to exit
+	return cmd.Start()
+}
+
+// loadStashes lists local or shared stashes in the background
+func loadStashes(shared, selectFirst bool) tea.Cmd {
+	return func() tea.Msg {
+		var stashes []Stash
+		var err error
+		if shared {
+			stashes, err = listSharedStashes(cfg.SharedRefs)
+		} else {
+			stashes, err = source.Stashes()
+		}
+		msg := stashesLoadedMsg{stashes: stashes, shared: shared, selectFirst: selectFirst, err: err}
+		if source.Live() {
+			msg.branch = currentBranch()
+		}
+		return msg
+	}
+}
+
+// currentBranch names the checked out branch the way stash subjects do, including
+// "(no branch)" for a detached HEAD
+func currentBranch() string {
+	out, err := exec.Command("git", "branch", "--show-current").Output()
+	if err != nil {
+		return ""
+	}
+	if branch := strings.TrimSpace(string(out)); branch != "" {
+		return branch
+	}
+	return "(no branch)"
+}
+
+// listSharedStashes lists stash-like commits stored under a ref namespace such as
+// refs/stashes/, which is how some teams push stashes to share them
+func listSharedStashes(namespace string) ([]Stash, error) {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(refname)|%(committerdate:unix)|%(objectname)|%(parent)|%(subject)", namespace)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var stashes []Stash
+	for _, line := range splitLines(string(out)) {
+		parts := strings.SplitN(line, "|", 5)
+		if len(parts) != 5 {
+			continue
+		}
+		unix, _ := strconv.ParseInt(parts[1], 10, 64)
+		ts := time.Unix(unix, 0)
+		base, _, _ := strings.Cut(parts[3], " ")
+		stashes = append(stashes, Stash{
+			Ref:       parts[0],
+			Message:   parts[4],
+			Created:   formatTimestamp(ts, cfg.DateFormat, time.Now()),
+			Branch:    parseStashBranch(parts[4]),
+			Sha:       parts[2],
+			BaseSha:   base,
+			Timestamp: ts,
+		})
+	}
+	return stashes, nil
+}
+
+// parseStashBranch pulls the branch out of a reflog subject such as "WIP on main: abc123 msg"
+// or "On feature/x: custom message". Stashes taken on a detached HEAD report "(no branch)".
+func parseStashBranch(subject string) string {
+	rest, ok := strings.CutPrefix(subject, "WIP on ")
+	if !ok {
+		rest, ok = strings.CutPrefix(subject, "On ")
+	}
+	if !ok {
+		return ""
+	}
+	branch, _, found := strings.Cut(rest, ": ")
+	if !found {
+		return ""
+	}
+	return branch
+}
+
+// formatTimestamp renders t as "relative" (like git's %cr), "iso", or a strftime-style pattern
+func formatTimestamp(t time.Time, format string, now time.Time) string {
+	switch format {
+	case "relative":
+		return relativeTime(t, now)
+	case "iso":
+		return t.Format("2006-01-02 15:04:05 -0700")
+	}
+	return strftime(t, format)
+}
+
+// relativeTime approximates git's relative dates ("5 minutes ago", "3 weeks ago")
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	ago := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	switch {
+	case d < 0:
+		return "in the future"
+	case d < 90*time.Second:
+		return ago(int(d.Seconds()), "second")
+	case d < 90*time.Minute:
+		return ago(int(d.Round(time.Minute).Minutes()), "minute")
+	case d < 36*time.Hour:
+		return ago(int(d.Round(time.Hour).Hours()), "hour")
+	case d < 14*24*time.Hour:
+		return ago(int((d+12*time.Hour)/(24*time.Hour)), "day")
+	case d < 70*24*time.Hour:
+		return ago(int((d+84*time.Hour)/(7*24*time.Hour)), "week")
+	case d < 365*24*time.Hour:
+		return ago(int((d+15*24*time.Hour)/(30*24*time.Hour)), "month")
+	}
+	return ago(int(d/(365*24*time.Hour)), "year")
+}
+
+// strftimeVerbs maps strftime directives to Go layout fragments
+var strftimeVerbs = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'b': "Jan", 'B': "January",
+	'd': "02", 'e': "_2", 'a': "Mon", 'A': "Monday",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'Z': "MST", 'z': "-0700",
+}
+
+// strftime formats t with a strftime-style pattern such as "%Y-%m-%d %H:%M".
+// Unknown directives are kept as-is.
+func strftime(t time.Time, pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i == len(pattern)-1 {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		if pattern[i] == '%' {
+			b.WriteByte('%')
+		} else if layout, ok := strftimeVerbs[pattern[i]]; ok {
+			b.WriteString(t.Format(layout))
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// splitLines splits command output into its non-empty lines
+func splitLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// unquotePath undoes git's quoting of a path in its output. Unless -z is used, git
+// wraps a path in double quotes when it has a tab, newline, quote or backslash in it,
+// or any byte over 0x7f with core.quotePath on (the default), and writes those as C
+// escapes such as \t and \303\244. Go's own string escapes are a superset of those.
+func unquotePath(path string) string {
+	if len(path) < 2 || path[0] != '"' || path[len(path)-1] != '"' {
+		return path
+	}
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		return unquoted
+	}
+	return path
+}
+
+// splitPaths splits git output with one path per line, unquoting each
+func splitPaths(out string) []string {
+	paths := splitLines(out)
+	for i, path := range paths {
+		paths[i] = unquotePath(path)
+	}
+	return paths
+}
+
 func listChangedFiles() ([]FileChange, error) {
-	cmd := exec.Command("git", "status", "--porcelain")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
+	// -z separates entries with NULs and leaves paths unquoted, so any file name
+	// comes through as is
+	out, err := exec.Command("git", "status", "--porcelain=v1", "-z").Output()
+	if err != nil {
 		return nil, err
 	}
+	files := parseStatus(string(out))
+	addLineCounts(files)
+	return files, nil
+}
 
+// parseStatus reads `git status --porcelain=v1 -z` output into an entry per side of
+// each changed path
+func parseStatus(out string) []FileChange {
 	var files []FileChange
-	scanner := bufio.NewScanner(&out)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) < 4 {
+	records := strings.Split(out, "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+
+		// Each record is "XY path"
+		// X = staged status, Y = unstaged status, or ?? for an untracked file
+		stagedStatus := record[0:1]
+		unstagedStatus := record[1:2]
+		path := record[3:]
+		if stagedStatus == "?" {
+			files = append(files, FileChange{Path: path, Status: "?", IsUntracked: true})
+			continue
+		}
+		// An unmerged path has no staged or unstaged side to speak of yet
+		if _, ok := unmergedStatuses[record[:2]]; ok {
+			files = append(files, FileChange{Path: path, Status: record[:2], IsConflicted: true})
 			continue
 		}
 
-		// Git status --porcelain format: XY filename
-		// X = staged status, Y = unstaged status
-		stagedStatus := line[0:1]
-		unstagedStatus := line[1:2]
-		path := strings.TrimSpace(line[3:])
+		// A rename or copy is followed by a record of its own with the original path.
+		// It's the index's unless only the working tree side is one, which happens
+		// with files added with --intent-to-add.
+		oldPath := ""
+		if strings.ContainsAny(record[:2], "RC") && i+1 < len(records) {
+			i++
+			oldPath = records[i]
+		}
+		unstagedOldPath := ""
+		if !strings.ContainsAny(stagedStatus, "RC") {
+			oldPath, unstagedOldPath = "", oldPath
+		}
 
 		// Add staged file if it has staged changes
-		if stagedStatus != " " && stagedStatus != "?" {
+		both := stagedStatus != " " && unstagedStatus != " "
+		if stagedStatus != " " {
 			files = append(files, FileChange{
-				Path:     path,
-				Status:   stagedStatus,
-				IsStaged: true,
+				Path:         path,
+				OldPath:      oldPath,
+				Status:       stagedStatus,
+				IsStaged:     true,
+				PartlyStaged: both,
 			})
 		}
 
 		// Add unstaged file if it has unstaged changes
 		if unstagedStatus != " " {
 			files = append(files, FileChange{
-				Path:     path,
-				Status:   unstagedStatus,
-				IsStaged: false,
+				Path:         path,
+				OldPath:      unstagedOldPath,
+				Status:       unstagedStatus,
+				IsStaged:     false,
+				PartlyStaged: both,
 			})
 		}
 	}
-	return files, scanner.Err()
+	return files
 }
 
 // ---------------------------------------------------------------------------
 // Tea Messages
 // ---------------------------------------------------------------------------
-func getStashDiff(ref string) tea.Cmd {
+func getStashDiff(ref string, opts diffOptions) tea.Cmd {
+	return fetchStashDiff(ref, false, opts)
+}
+
+// getStashStat loads the --stat view of a stash
+func getStashStat(ref string) tea.Cmd {
 	return func() tea.Msg {
-		// -c color.ui=always tells git to include the ANSI colors even though it's not going direct to a terminal
-		cmd := exec.Command("git", "-c", "color.ui=always", "stash", "show", "-u", "-p", ref)
+		cmd := exec.Command("git", "stash", "show", "--no-color", "--stat", "-u", ref)
 		out, err := cmd.CombinedOutput()
-		return stashDiffMsg{ref: ref, diff: string(out), err: err}
+		return stashDiffMsg{ref: ref, diff: colorizeStat(string(out)), stat: true, err: err}
 	}
 }
 
-func dropStash(ref string) tea.Cmd {
-	return func() tea.Msg {
-		cmd := exec.Command("git", "stash", "drop", ref)
-		err := cmd.Run()
-		return stashDeletedMsg{ref: ref, err: err}
+// stashDiffKey identifies a fetched stash diff or --stat view in the cache
+type stashDiffKey struct {
+	ref  string
+	stat bool
+	opts diffOptions // always zero for --stat views
+}
+
+// showStashDiff displays a stash in whichever of the diff and --stat views is on,
+// reusing what was fetched before unless reload is set
+func (m *model) showStashDiff(ref string, reload bool) tea.Cmd {
+	m.closeDiffStream()
+	if cached, ok := m.stashDiffCache[m.stashDiffKey(ref)]; ok && !reload {
+		return tea.Batch(func() tea.Msg { return cached }, m.fetchStashMeta(ref))
 	}
+	m.loading = true
+	fetch := getStashDiff(ref, m.diffOpts)
+	if m.statView {
+		fetch = getStashStat(ref)
+	} else if source.Live() && cfg.MaxDiffBytes > 0 {
+		m.diffStream = newDiffStream(ref, m.diffOpts)
+		fetch = streamStashDiff(m.diffStream)
+	}
+	return tea.Batch(fetch, m.fetchStashMeta(ref))
 }
 
-func applyStash(ref string) tea.Cmd {
-	return func() tea.Msg {
-		cmd := exec.Command("git", "stash", "apply", ref)
-		out, err := cmd.CombinedOutput()
-		return stashAppliedMsg{ref: ref, output: string(out), err: err}
+// stashDiffKey is the cache key for ref as it would be shown now
+func (m model) stashDiffKey(ref string) stashDiffKey {
+	if m.statView {
+		return stashDiffKey{ref: ref, stat: true}
 	}
+	return stashDiffKey{ref: ref, opts: m.diffOpts}
 }
 
-func getChangedFiles() tea.Cmd {
+// fetchStashDiff loads a stash's diff. Unless full is set, a diff bigger than
+// cfg.MaxDiffLines is replaced by a --stat summary to keep the UI responsive.
+func fetchStashDiff(ref string, full bool, opts diffOptions) tea.Cmd {
 	return func() tea.Msg {
-		files, err := listChangedFiles()
-		return changedFilesMsg{files: files, err: err}
+		diff, summarized, err := source.Diff(ref, full, opts)
+		return stashDiffMsg{ref: ref, diff: diff, summarized: summarized, opts: opts, err: err}
 	}
 }
 
-func getFileDiff(file FileChange) tea.Cmd {
-	return func() tea.Msg {
-		var cmd *exec.Cmd
-		if file.IsStaged {
-			cmd = exec.Command("git", "-c", "color.ui=always", "diff", "--cached", "--", file.Path)
-		} else {
-			cmd = exec.Command("git", "-c", "color.ui=always", "diff", "--", file.Path)
+// overLineBudget runs a --numstat git command and reports whether the diff it describes
+// changes more lines than cfg.MaxDiffLines. Errors count as within budget so the real
+// diff command gets to report them.
+func overLineBudget(args ...string) bool {
+	if cfg.MaxDiffLines <= 0 {
+		return false
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return false
+	}
+	total := 0
+	for _, line := range splitLines(string(out)) {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
 		}
-		out, err := cmd.CombinedOutput()
-		return fileDiffMsg{path: file.Path, diff: string(out), err: err}
+		// Binary files report "-" for both counts
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		total += added + deleted
 	}
+	return total > cfg.MaxDiffLines
 }
 
-func createStash(files []FileChange, message string) tea.Cmd {
-	return func() tea.Msg {
-		// Build the git stash push command with file paths
-		args := []string{"stash", "push", "--include-untracked", "-m", message, "--"}
-		for _, f := range files {
-			args = append(args, f.Path)
-		}
+// staleStashError means a ref no longer names the stash it did when the list loaded,
+// because stashes were made or dropped outside Packrat since
+type staleStashError struct {
+	Ref, Want, Got string // Got is empty when the ref is gone altogether
+}
 
-		cmd := exec.Command("git", args...)
-		out, err := cmd.CombinedOutput()
-		return stashCreatedMsg{output: string(out), err: err}
+func (e staleStashError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("%s no longer exists", e.Ref)
 	}
+	return fmt.Sprintf("%s is now %.7s, not %.7s", e.Ref, e.Got, e.Want)
 }
 
-func restoreWorkingDirectory() tea.Cmd {
-	return func() tea.Msg {
-		var output bytes.Buffer
+// verifyStash checks that ref still resolves to sha before something acts on it. A
+// stash without a known SHA can't be checked and passes.
+func verifyStash(ref, sha string) error {
+	if sha == "" {
+		return nil
+	}
+	out, err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref).Output()
+	got := strings.TrimSpace(string(out))
+	if err != nil {
+		got = ""
+	}
+	if got != sha {
+		return staleStashError{Ref: ref, Want: sha, Got: got}
+	}
+	return nil
+}
+
+// dropStash drops ref, provided it's still the stash with the given SHA
+func dropStash(ref, sha string) tea.Cmd {
+	return func() tea.Msg {
+		if err := verifyStash(ref, sha); err != nil {
+			return stashDeletedMsg{ref: ref, err: err}
+		}
+		cmd := exec.Command("git", "stash", "drop", ref)
+		err := cmd.Run()
+		return stashDeletedMsg{ref: ref, err: err}
+	}
+}
+
+// dropStashes drops the stashes with the given SHAs. Every drop shifts the indexes of
+// the stashes after it, so the SHAs are resolved to indexes up front and dropped from
+// the highest index down.
+func dropStashes(shas []string) tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "stash", "list", "--format=%H").Output()
+		if err != nil {
+			return stashesDroppedMsg{err: err}
+		}
+		indexes := make(map[string]int)
+		for i, sha := range splitLines(string(out)) {
+			indexes[sha] = i
+		}
+
+		var msg stashesDroppedMsg
+		var toDrop []int
+		for _, sha := range shas {
+			if i, ok := indexes[sha]; ok {
+				toDrop = append(toDrop, i)
+			} else {
+				msg.failures = append(msg.failures, fmt.Sprintf("%s: no longer in the stash list", sha))
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(toDrop)))
+		for _, i := range toDrop {
+			ref := fmt.Sprintf("stash@{%d}", i)
+			if out, err := exec.Command("git", "stash", "drop", ref).CombinedOutput(); err != nil {
+				msg.failures = append(msg.failures, fmt.Sprintf("%s: %s", ref, strings.TrimSpace(string(out))))
+			} else {
+				msg.dropped++
+			}
+		}
+		return msg
+	}
+}
+
+// compareStashes diffs one stash's changes against another's
+func compareStashes(base, other string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("git", "diff", "--no-color", "-M", base, other)
+		out, err := cmd.CombinedOutput()
+		return stashCompareMsg{base: base, other: other, diff: decorateRenames(colorizeDiff(string(out))), err: err}
+	}
+}
+
+// renameStash gives a stash a new message. git can't edit a stash entry, so the same
+// commit is stored again under the new message and the old entry is dropped; the
+// commit keeps all its parents, untracked files included. Storing first means the
+// stash is never missing if something fails halfway.
+func renameStash(s Stash, message string) tea.Cmd {
+	return func() tea.Msg {
+		if _, ok := s.Index(); !ok {
+			return stashRenamedMsg{sha: s.Sha, err: fmt.Errorf("%s isn't in the stash list", s.Ref)}
+		}
+		if err := verifyStash(s.Ref, s.Sha); err != nil {
+			return stashRenamedMsg{sha: s.Sha, err: err}
+		}
+		if out, err := exec.Command("git", "stash", "store", "-m", message, s.Sha).CombinedOutput(); err != nil {
+			return stashRenamedMsg{sha: s.Sha, output: string(out), err: err}
+		}
+		// The new entry went on top. The old one is found by SHA below it rather than
+		// by index, in case the list moved since it was checked.
+		out, err := exec.Command("git", "stash", "list", "--format=%H").Output()
+		if err != nil {
+			return stashRenamedMsg{sha: s.Sha, err: err}
+		}
+		for i, sha := range splitLines(string(out)) {
+			if i > 0 && sha == s.Sha {
+				old := fmt.Sprintf("stash@{%d}", i)
+				out, err := exec.Command("git", "stash", "drop", old).CombinedOutput()
+				return stashRenamedMsg{sha: s.Sha, output: string(out), err: err}
+			}
+		}
+		return stashRenamedMsg{sha: s.Sha, err: fmt.Errorf("stored the renamed stash, but the old entry for %.7s wasn't in the list to drop", s.Sha)}
+	}
+}
+
+// renamedMessage keeps git's "On <branch>: " prefix so the stash still groups and
+// filters under its branch
+func renamedMessage(s Stash, message string) string {
+	if s.Branch == "" {
+		return message
+	}
+	return fmt.Sprintf("On %s: %s", s.Branch, message)
+}
+
+// popStash applies a stash and leaves the drop to the stashAppliedMsg handler, which only
+// does it when the apply went cleanly
+func popStash(ref, sha string) tea.Cmd {
+	apply := applyStash(ref, sha, false)
+	return func() tea.Msg {
+		msg := apply().(stashAppliedMsg)
+		msg.pop = true
+		return msg
+	}
+}
+
+// dropPoppedStash finishes a pop, carrying the apply's output along for display
+func dropPoppedStash(ref, sha, output string) tea.Cmd {
+	drop := dropStash(ref, sha)
+	return func() tea.Msg {
+		msg := drop().(stashDeletedMsg)
+		msg.popped = true
+		msg.output = output
+		return msg
+	}
+}
+
+// branchFromStash runs `git stash branch`, which checks out a new branch at the stash's
+// base commit, applies the stash there and drops it, provided ref is still the stash
+// with the given SHA
+func branchFromStash(name, ref, sha string) tea.Cmd {
+	return func() tea.Msg {
+		if err := verifyStash(ref, sha); err != nil {
+			return stashBranchedMsg{ref: ref, branch: name, err: err}
+		}
+		cmd := exec.Command("git", "stash", "branch", name, ref)
+		out, err := cmd.CombinedOutput()
+		return stashBranchedMsg{ref: ref, branch: name, output: string(out), err: err}
+	}
+}
+
+// applyStash runs `git stash apply`. withIndex adds --index, which restores what was
+// staged when the stash was made instead of leaving every change unstaged.
+func applyStash(ref, sha string, withIndex bool) tea.Cmd {
+	return func() tea.Msg {
+		if err := verifyStash(ref, sha); err != nil {
+			return stashAppliedMsg{ref: ref, sha: sha, index: withIndex, err: err}
+		}
+		args := []string{"stash", "apply", ref}
+		if withIndex {
+			args = []string{"stash", "apply", "--index", ref}
+		}
+		cmd := exec.Command("git", args...)
+		out, err := cmd.CombinedOutput()
+		msg := stashAppliedMsg{ref: ref, sha: sha, output: string(out), index: withIndex, err: err}
+		if err != nil {
+			msg.conflicts = reportedConflicts(listConflictedFiles(), string(out))
+		}
+		return msg
+	}
+}
+
+// reportedConflicts adds the paths of git's "CONFLICT (...)" lines to the unmerged files,
+// for conflicts such as modify/delete that can leave nothing unmerged in the index
+func reportedConflicts(files []conflictedFile, output string) []conflictedFile {
+	seen := make(map[string]bool)
+	for _, f := range files {
+		seen[f.Path] = true
+	}
+	for _, line := range splitLines(output) {
+		if !strings.HasPrefix(line, "CONFLICT (") {
+			continue
+		}
+		_, rest, ok := strings.Cut(line, "): ")
+		if !ok {
+			continue
+		}
+		path, ok := strings.CutPrefix(rest, "Merge conflict in ")
+		if !ok {
+			// e.g. "CONFLICT (modify/delete): main.go deleted in Updated upstream and ..."
+			path, _, _ = strings.Cut(rest, " ")
+		}
+		if path != "" && !seen[path] {
+			seen[path] = true
+			files = append(files, conflictedFile{Path: path, Markers: countConflictMarkers(path)})
+		}
+	}
+	return files
+}
+
+// listConflictedFiles finds unmerged paths and counts the conflict markers in each
+func listConflictedFiles() []conflictedFile {
+	out, err := exec.Command("git", "diff", "--name-only", "--diff-filter=U").Output()
+	if err != nil {
+		return nil
+	}
+	var files []conflictedFile
+	for _, path := range splitPaths(string(out)) {
+		files = append(files, conflictedFile{Path: path, Markers: countConflictMarkers(path)})
+	}
+	return files
+}
+
+// countConflictMarkers counts the conflict regions git wrote into a file
+func countConflictMarkers(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "<<<<<<<") {
+			count++
+		}
+	}
+	return count
+}
+
+func getChangedFiles() tea.Cmd {
+	return func() tea.Msg {
+		files, err := listChangedFiles()
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = f.Path
+		}
+		return changedFilesMsg{files: files, stat: getWorktreeStat(files), operation: repoOperation(), mtimes: statFiles(paths), err: err}
+	}
+}
+
+// worktreeStat sums up every uncommitted change, staged or not
+type worktreeStat struct {
+	Files, Insertions, Deletions, Untracked int
+}
+
+var shortstatNumber = regexp.MustCompile(`(\d+) (file|insertion|deletion)`)
+
+// getWorktreeStat reads `git diff HEAD --shortstat` and counts untracked files in the list
+func getWorktreeStat(files []FileChange) worktreeStat {
+	var stat worktreeStat
+	for _, f := range files {
+		if f.IsUntracked {
+			stat.Untracked++
+		}
+	}
+	out, err := exec.Command("git", "diff", "HEAD", "--shortstat").Output()
+	if err != nil {
+		// No HEAD yet, so everything is staged against the empty tree
+		out, _ = exec.Command("git", "diff", "--cached", "--shortstat").Output()
+	}
+	for _, match := range shortstatNumber.FindAllStringSubmatch(string(out), -1) {
+		n, _ := strconv.Atoi(match[1])
+		switch match[2] {
+		case "file":
+			stat.Files = n
+		case "insertion":
+			stat.Insertions = n
+		case "deletion":
+			stat.Deletions = n
+		}
+	}
+	return stat
+}
+
+func (s worktreeStat) String() string {
+	text := fmt.Sprintf("Working tree: %d file(s) +%d -%d", s.Files, s.Insertions, s.Deletions)
+	if s.Untracked > 0 {
+		text += fmt.Sprintf(" · %d untracked", s.Untracked)
+	}
+	return text
+}
+
+func getFileDiff(file FileChange, opts diffOptions) tea.Cmd {
+	return fetchFileDiff(file, false, opts)
+}
+
+// fetchFileDiff loads a file's diff, falling back to a --stat summary when it's over
+// cfg.MaxDiffLines unless full is set
+func fetchFileDiff(file FileChange, full bool, opts diffOptions) tea.Cmd {
+	return func() tea.Msg {
+		return fileDiff(file, full, opts)
+	}
+}
+
+func fileDiff(file FileChange, full bool, opts diffOptions) fileDiffMsg {
+	switch {
+	case file.IsUntracked:
+		return fetchUntrackedDiff(file, full, opts)
+	case file.PartlyStaged:
+		return fetchPairDiff(file, full, opts)
+	}
+	diffArgs := []string{"diff", "--no-color"}
+	if file.IsStaged {
+		diffArgs = append(diffArgs, "--cached")
+	}
+
+	if !full && overLineBudget(append(append(diffArgs, "--numstat", "-M", "--"), file.pathspec()...)...) {
+		cmd := exec.Command("git", append(append(diffArgs, "--stat", "-M", "--"), file.pathspec()...)...)
+		out, err := cmd.CombinedOutput()
+		return fileDiffMsg{path: file.Path, diff: colorizeStat(string(out)), summarized: true, staged: file.IsStaged, opts: opts, err: err}
+	}
+
+	args := append(append(diffArgs, opts.args()...), "-M", "--")
+	cmd := exec.Command("git", append(args, file.pathspec()...)...)
+	out, err := cmd.CombinedOutput()
+	return fileDiffMsg{path: file.Path, diff: decorateRenames(opts.colorize(string(out))), staged: file.IsStaged, opts: opts, err: err}
+}
+
+func getFileHunks(file FileChange) tea.Cmd {
+	return func() tea.Msg {
+		// Hunks are staged with `git apply`, so they need to come from an uncolored diff
+		cmd := exec.Command("git", "diff", "--no-color", "--", file.Path)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fileHunksMsg{file: file, err: fmt.Errorf("%v: %s", err, out)}
+		}
+		patches := parseDiff(string(out))
+		if len(patches) == 0 || len(patches[0].Hunks) == 0 {
+			return fileHunksMsg{file: file, err: fmt.Errorf("no unstaged hunks in %s", file.Path)}
+		}
+		return fileHunksMsg{file: file, patch: patches[0]}
+	}
+}
+
+func stageHunks(patch string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("git", "apply", "--cached", "-")
+		cmd.Stdin = strings.NewReader(patch)
+		out, err := cmd.CombinedOutput()
+		return hunksStagedMsg{patch: patch, output: string(out), err: err}
+	}
+}
+
+// hunksUnstagedMsg reports taking picked hunks back out of the index
+type hunksUnstagedMsg struct {
+	output string
+	err    error
+}
+
+// unstageHunks reverses stageHunks when the stash they were staged for is cancelled
+func unstageHunks(patch string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("git", "apply", "--cached", "--reverse", "-")
+		cmd.Stdin = strings.NewReader(patch)
+		out, err := cmd.CombinedOutput()
+		return hunksUnstagedMsg{output: strings.TrimSpace(string(out)), err: err}
+	}
+}
+
+// typeAheadDelay is how long the type-ahead buffer waits for the next keystroke
+const typeAheadDelay = time.Second
+
+func typeAheadTimeout(seq int) tea.Cmd {
+	return tea.Tick(typeAheadDelay, func(time.Time) tea.Msg {
+		return typeAheadTimeoutMsg{seq: seq}
+	})
+}
+
+// getRawStashList fetches the stash listing exactly as git prints it
+func getRawStashList(shared bool) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("git", "stash", "list")
+		if shared {
+			cmd = exec.Command("git", "for-each-ref", cfg.SharedRefs)
+		}
+		out, err := cmd.Output()
+		return rawStashListMsg{raw: string(out), err: err}
+	}
+}
+
+func getStashTree(ref string) tea.Cmd {
+	return func() tea.Msg {
+		files, err := listStashFiles(ref)
+		if err != nil {
+			return stashTreeMsg{ref: ref, err: err}
+		}
+		return stashTreeMsg{ref: ref, files: files}
+	}
+}
+
+func getStashFileDiff(ref string, file treeFile) tea.Cmd {
+	return func() tea.Msg {
+		var cmd *exec.Cmd
+		if file.Status == "?" {
+			// The untracked parent is a root commit, so showing it diffs against nothing
+			cmd = exec.Command("git", "show", "--no-color", "--format=", ref+"^3", "--", file.Path)
+		} else {
+			cmd = exec.Command("git", "diff", "--no-color", "-M", ref+"^1", ref, "--", file.Path)
+		}
+		out, err := cmd.CombinedOutput()
+		return stashFileDiffMsg{ref: ref, path: file.Path, diff: decorateRenames(colorizeDiff(string(out))), err: err}
+	}
+}
+
+func getStashOverview(stashes []Stash) tea.Cmd {
+	return func() tea.Msg {
+		// One `git stash show` per stash, run a few at a time
+		const workers = 8
+		names := make([][]string, len(stashes))
+		errs := make([]error, len(stashes))
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+
+		for i, s := range stashes {
+			wg.Add(1)
+			go func(i int, ref string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				out, err := exec.Command("git", "stash", "show", "--name-only", "-u", ref).Output()
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %v", ref, err)
+					return
+				}
+				names[i] = splitPaths(string(out))
+			}(i, s.Ref)
+		}
+		wg.Wait()
+
+		byPath := make(map[string]*overviewEntry)
+		var entries []overviewEntry
+		for i, s := range stashes {
+			if errs[i] != nil {
+				return stashOverviewMsg{err: errs[i]}
+			}
+			for _, path := range names[i] {
+				if e, ok := byPath[path]; ok {
+					e.Refs = append(e.Refs, s.Ref)
+				} else {
+					byPath[path] = &overviewEntry{Path: path, Refs: []string{s.Ref}}
+				}
+			}
+		}
+		for _, e := range byPath {
+			entries = append(entries, *e)
+		}
+		return stashOverviewMsg{entries: entries}
+	}
+}
+
+// saveShowHeader remembers the ctrl+h choice in the user's global git config
+func saveShowHeader(show bool) tea.Cmd {
+	return func() tea.Msg {
+		exec.Command("git", "config", "--global", "packrat.showHeader", strconv.FormatBool(show)).Run()
+		return nil
+	}
+}
+
+type difftoolClosedMsg struct {
+	file     FileChange
+	modified bool // the file's mtime changed while the tool was open
+	err      error
+}
+
+// openDifftool hands the terminal to `git difftool` for one file and takes it back when
+// the tool exits. packrat.diffTool picks the tool; otherwise git's diff.tool applies.
+func openDifftool(file FileChange) tea.Cmd {
+	args := []string{"difftool", "--no-prompt"}
+	if cfg.DiffTool != "" {
+		args = append(args, "--tool="+cfg.DiffTool)
+	}
+	if file.IsStaged {
+		args = append(args, "--cached")
+	}
+	args = append(append(args, "-M", "--"), file.pathspec()...)
+
+	var before time.Time
+	if info, err := os.Stat(file.Path); err == nil {
+		before = info.ModTime()
+	}
+	return tea.ExecProcess(exec.Command("git", args...), func(err error) tea.Msg {
+		modified := false
+		if info, statErr := os.Stat(file.Path); statErr == nil {
+			modified = !info.ModTime().Equal(before)
+		}
+		return difftoolClosedMsg{file: file, modified: modified, err: err}
+	})
+}
+
+// openDiffInBrowser runs each git command (without color), writes the combined diff
+// to a temporary HTML file and opens it
+func openDiffInBrowser(title string, gitArgs [][]string) tea.Cmd {
+	return func() tea.Msg {
+		var diff strings.Builder
+		for _, args := range gitArgs {
+			out, err := exec.Command("git", args...).CombinedOutput()
+			if err != nil {
+				return diffOpenedMsg{err: fmt.Errorf("%v: %s", err, out)}
+			}
+			diff.Write(out)
+		}
+
+		f, err := os.CreateTemp("", "packrat-*.html")
+		if err != nil {
+			return diffOpenedMsg{err: err}
+		}
+		defer f.Close()
+		if _, err := f.WriteString(renderDiffHTML(title, diff.String())); err != nil {
+			return diffOpenedMsg{err: err}
+		}
+		return diffOpenedMsg{path: f.Name(), err: openInBrowser(f.Name())}
+	}
+}
+
+// previewApply checks whether a stash would apply cleanly without touching the working
+// tree or the real index: the current index is copied into a temporary one with
+// `git read-tree`, and the stash's patch is checked against it with `git apply --check`
+func previewApply(ref string) tea.Cmd {
+	return func() tea.Msg {
+		tree, err := exec.Command("git", "write-tree").CombinedOutput()
+		if err != nil {
+			return applyPreviewMsg{ref: ref, err: fmt.Errorf("index has unresolved conflicts: %s", tree)}
+		}
+
+		tmp, err := os.CreateTemp("", "packrat-index-*")
+		if err != nil {
+			return applyPreviewMsg{ref: ref, err: err}
+		}
+		tmp.Close()
+		os.Remove(tmp.Name()) // read-tree wants to create the file itself
+		defer os.Remove(tmp.Name())
+		env := append(os.Environ(), "GIT_INDEX_FILE="+tmp.Name())
+
+		readTree := exec.Command("git", "read-tree", strings.TrimSpace(string(tree)))
+		readTree.Env = env
+		if out, err := readTree.CombinedOutput(); err != nil {
+			return applyPreviewMsg{ref: ref, err: fmt.Errorf("%v: %s", err, out)}
+		}
+		// Fill in stat info so unchanged files aren't reported as not matching the index
+		refresh := exec.Command("git", "update-index", "-q", "--refresh")
+		refresh.Env = env
+		refresh.Run()
+
+		patch, err := exec.Command("git", "stash", "show", "-p", "--binary", "-u", ref).Output()
+		if err != nil {
+			return applyPreviewMsg{ref: ref, err: err}
+		}
+
+		check := exec.Command("git", "apply", "--check", "--index", "-")
+		check.Env = env
+		check.Stdin = bytes.NewReader(patch)
+		out, err := check.CombinedOutput()
+		if err == nil {
+			return applyPreviewMsg{ref: ref}
+		}
+		return applyPreviewMsg{ref: ref, conflicts: parseApplyCheck(string(out)), output: string(out)}
+	}
+}
+
+// parseApplyCheck pulls the failing paths out of `git apply --check` errors such as
+// "error: patch failed: main.go:12" or "error: notes.txt: already exists in working directory"
+func parseApplyCheck(out string) []applyConflict {
+	var conflicts []applyConflict
+	seen := make(map[string]bool)
+	for _, line := range splitLines(out) {
+		rest, ok := strings.CutPrefix(line, "error: ")
+		if !ok {
+			continue
+		}
+		var path, reason string
+		if failed, ok := strings.CutPrefix(rest, "patch failed: "); ok {
+			if i := strings.LastIndex(failed, ":"); i >= 0 {
+				failed = failed[:i]
+			}
+			path, reason = failed, "changes don't apply"
+		} else if i := strings.LastIndex(rest, ": "); i >= 0 {
+			path, reason = rest[:i], rest[i+2:]
+			if reason == "does not match index" {
+				reason = "has local changes the stash would overwrite"
+			}
+		} else {
+			continue
+		}
+		if !seen[path] {
+			seen[path] = true
+			conflicts = append(conflicts, applyConflict{Path: path, Reason: reason})
+		}
+	}
+	return conflicts
+}
+
+// stashPushFlags are the extra `git stash push` flags accepted in the advanced flags
+// input. Only flags without values are allowed, so nothing typed there can smuggle in a
+// pathspec, message or config override.
+var stashPushFlags = map[string]bool{
+	"--keep-index": true, "-k": true, "--no-keep-index": true,
+	"--include-untracked": true, "-u": true, "--all": true, "-a": true,
+	"--staged": true, "-S": true, "--quiet": true, "-q": true,
+}
+
+// parseStashFlags splits the advanced flags input and checks every token against stashPushFlags
+func parseStashFlags(input string) ([]string, error) {
+	flags := strings.Fields(input)
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "-") {
+			return nil, fmt.Errorf("%q is not a flag", flag)
+		}
+		if !stashPushFlags[flag] {
+			return nil, fmt.Errorf("%s is not an allowed stash push flag", flag)
+		}
+	}
+	return flags, nil
+}
+
+// stashPushArgs builds the `git stash push` arguments for a scope, with any extra flags
+// placed before the pathspec. untracked adds --include-untracked, which a staged stash
+// never takes.
+func stashPushArgs(files []FileChange, message string, scope StashScope, untracked bool, flags []string) []string {
+	args := []string{"stash", "push"}
+	switch {
+	case scope == ScopeStaged:
+		args = append(args, "--staged")
+	case untracked:
+		args = append(args, "--include-untracked")
+	}
+	args = append(args, flags...)
+	args = append(args, "-m", message)
+	if scope == ScopeSelection {
+		// A partly staged path can come in once for each side; git stashes it whole
+		// either way, so it's named once
+		args = append(args, "--")
+		seen := make(map[string]bool)
+		for _, f := range files {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				args = append(args, f.Path)
+			}
+		}
+	}
+	return args
+}
+
+// shellQuote quotes an argument for display in a copy-pasteable command
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=@:+,", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// createStash runs git stash push. A snapshot applies the new stash straight back, so
+// it's recorded without the working tree changing.
+func createStash(files []FileChange, message string, scope StashScope, untracked, snapshot bool, flags []string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("git", stashPushArgs(files, message, scope, untracked, flags)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			// --staged can save the stash and then fail to take the staged changes back
+			// out of a file that was edited further since, leaving them in place
+			msg := stashCreatedMsg{output: string(out), message: message, err: err}
+			if strings.Contains(msg.output, "Saved working directory and index state") {
+				sha, _ := exec.Command("git", "rev-parse", "stash@{0}").Output()
+				msg.saved, msg.sha = true, strings.TrimSpace(string(sha))
+			}
+			return msg
+		}
+
+		// The new stash is always stash@{0}; its SHA stays valid after later pushes
+		sha, err := exec.Command("git", "rev-parse", "stash@{0}").Output()
+		msg := stashCreatedMsg{output: string(out), sha: strings.TrimSpace(string(sha)), message: message, snapshot: snapshot, err: err}
+		if args := renameCleanupArgs(files, scope, flags); args != nil && err == nil {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				// Nothing was applied back, so it's reported like any stash git half cleaned up
+				msg.output, msg.err, msg.saved, msg.snapshot = string(out), err, true, false
+				return msg
+			}
+		}
+		if snapshot && err == nil {
+			if out, err := exec.Command("git", snapshotApplyArgs(msg.sha)...).CombinedOutput(); err != nil {
+				msg.output, msg.err, msg.saved = string(out), err, true
+			}
+		}
+		return msg
+	}
+}
+
+// restoreEntry is a file a restore would revert or delete
+type restoreEntry struct {
+	Path           string
+	Added, Deleted int   // line counts for tracked files
+	Binary         bool  // tracked file git can't count lines for
+	New            bool  // staged as a new file, so restoring deletes it
+	Untracked      bool  // would be deleted by git clean
+	Size           int64 // bytes, for untracked files
+	Dir            bool  // untracked directory, removed whole
+}
+
+type restorePreviewMsg struct {
+	entries []restoreEntry
+	noHead  bool // there's no commit yet, so staged changes can't be restored and are kept
+	err     error
+}
+
+// headExists reports whether there's a commit checked out. Before the first one, the
+// index has nothing to go back to.
+func headExists() bool {
+	return exec.Command("git", "rev-parse", "--verify", "-q", "HEAD").Run() == nil
+}
+
+// restoreArgs builds the `git restore` arguments for tracked paths. Staged changes go
+// too, back to HEAD, unless there's no HEAD to go back to.
+func restoreArgs(tracked []string, head bool) []string {
+	args := []string{"--literal-pathspecs", "restore"}
+	if head {
+		args = append(args, "--staged", "--worktree")
+	}
+	return append(append(args, "--"), tracked...)
+}
+
+// restoreScope splits the files a restore is limited to into pathspecs for git restore
+// and git clean. With no files, it's the whole working tree: "." for restore and
+// nothing for clean. Paths that would mean the repo root are dropped, so a scoped
+// restore can't turn into a whole-tree one.
+func restoreScope(files []FileChange) (tracked, untracked []string) {
+	if len(files) == 0 {
+		return []string{"."}, nil
+	}
+	for _, f := range files {
+		if p := path.Clean(f.Path); p == "." || p == "/" {
+			continue
+		}
+		if f.IsUntracked {
+			untracked = append(untracked, f.Path)
+		} else {
+			tracked = append(tracked, f.pathspec()...)
+		}
+	}
+	return tracked, untracked
+}
+
+// cleanArgs builds `git clean` arguments with excludes. A scoped clean gets its paths
+// literally after --; without them, it's the whole tree.
+func cleanArgs(flag string, excludes, untracked []string) []string {
+	args := []string{"clean", flag, "-d"}
+	for _, pattern := range excludes {
+		args = append(args, "-e", pattern)
+	}
+	if len(untracked) > 0 {
+		args = append(append([]string{"--literal-pathspecs"}, args...), "--")
+		args = append(args, untracked...)
+	}
+	return args
+}
+
+// previewRestore lists what restoreWorkingDirectory would throw away for the same files
+// and excludes, without changing anything
+func previewRestore(files []FileChange, excludes []string) tea.Cmd {
+	return func() tea.Msg {
+		tracked, untracked := restoreScope(files)
+		var entries []restoreEntry
+		if len(tracked) == 0 && len(untracked) == 0 {
+			return restorePreviewMsg{}
+		}
+		head := headExists()
+		var out []byte
+		var err error
+		if len(tracked) > 0 {
+			// Against HEAD, so staged changes are counted along with the rest
+			args := []string{"--literal-pathspecs", "diff", "--numstat"}
+			if head {
+				args = append(args, "HEAD")
+			}
+			out, err = exec.Command("git", append(append(args, "--"), tracked...)...).Output()
+		}
+		if err != nil {
+			return restorePreviewMsg{err: err}
+		}
+		added := make(map[string]bool)
+		if head && len(tracked) > 0 {
+			names, _ := exec.Command("git", append([]string{"--literal-pathspecs", "diff", "HEAD", "--name-only", "--diff-filter=A", "--"}, tracked...)...).Output()
+			for _, path := range splitPaths(string(names)) {
+				added[path] = true
+			}
+		}
+		for _, line := range splitLines(string(out)) {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			path := unquotePath(fields[2])
+			entry := restoreEntry{Path: path, Binary: fields[0] == "-", New: added[path]}
+			entry.Added, _ = strconv.Atoi(fields[0])
+			entry.Deleted, _ = strconv.Atoi(fields[1])
+			entries = append(entries, entry)
+		}
+
+		if len(files) > 0 && len(untracked) == 0 {
+			return restorePreviewMsg{entries: entries, noHead: !head}
+		}
+		out, err = exec.Command("git", cleanArgs("-n", excludes, untracked)...).Output()
+		if err != nil {
+			return restorePreviewMsg{err: err}
+		}
+		for _, line := range splitLines(string(out)) {
+			path, ok := strings.CutPrefix(line, "Would remove ")
+			if !ok {
+				continue
+			}
+			path = unquotePath(path)
+			entry := restoreEntry{Path: path, Untracked: true, Dir: strings.HasSuffix(path, "/")}
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				entry.Size = info.Size()
+			}
+			entries = append(entries, entry)
+		}
+		return restorePreviewMsg{entries: entries, noHead: !head}
+	}
+}
+
+// restorePreviewView lists each file a restore would cost, for the restore modal
+func restorePreviewView(msg restorePreviewMsg) string {
+	if msg.err != nil {
+		return fmt.Sprintf("Couldn't list affected files: %v", msg.err)
+	}
+	if len(msg.entries) == 0 {
+		return "Nothing to restore."
+	}
+	var b strings.Builder
+	if msg.noHead {
+		b.WriteString(statusStyle.Render("There's no commit yet, so staged changes are kept; only unstaged ones are reverted.") + "\n")
+	}
+	for _, e := range msg.entries {
+		switch {
+		case e.Dir:
+			b.WriteString(removedLineStyle.Render("  delete  "+e.Path) + " (directory)\n")
+		case e.Untracked:
+			b.WriteString(removedLineStyle.Render("  delete  "+e.Path) + fmt.Sprintf(" (%d bytes)\n", e.Size))
+		case e.New:
+			b.WriteString(removedLineStyle.Render("  delete  "+e.Path) + " (new, staged)\n")
+		case e.Binary:
+			b.WriteString("  revert  " + e.Path + " (binary)\n")
+		default:
+			b.WriteString(fmt.Sprintf("  revert  %s (%s %s)\n", e.Path,
+				addedLineStyle.Render(fmt.Sprintf("+%d", e.Added)), removedLineStyle.Render(fmt.Sprintf("-%d", e.Deleted))))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// restoreWorkingDirectory throws away the changes to files, or to the whole working
+// tree when files is empty: tracked files go back to HEAD's version, in the index and
+// the working tree, and untracked ones are deleted, sparing anything matching excludes
+func restoreWorkingDirectory(files []FileChange, excludes []string) tea.Cmd {
+	return func() tea.Msg {
+		var output bytes.Buffer
+		tracked, untracked := restoreScope(files)
+
+		// First, restore the modified tracked files
+		if len(tracked) > 0 {
+			restoreCmd := exec.Command("git", restoreArgs(tracked, headExists())...)
+			restoreOut, restoreErr := restoreCmd.CombinedOutput()
+			output.Write(restoreOut)
+
+			if restoreErr != nil {
+				return workingDirectoryRestoredMsg{output: output.String(), err: restoreErr}
+			}
+		}
+
+		// Then, clean untracked files and directories. A scoped restore only cleans the
+		// untracked files it was given, and skips this if there are none.
+		if len(files) > 0 && len(untracked) == 0 {
+			return workingDirectoryRestoredMsg{output: output.String()}
+		}
+		cleanCmd := exec.Command("git", cleanArgs("-f", excludes, untracked)...)
+		cleanOut, cleanErr := cleanCmd.CombinedOutput()
+		output.Write(cleanOut)
+
+		return workingDirectoryRestoredMsg{output: output.String(), err: cleanErr}
+	}
+}
+
+// gitCheatsheet maps Packrat's actions to the git commands behind them, shown with F1
+const gitCheatsheet = `What Packrat runs for you
+
+Looking at stashes
+  Stash list ............ git stash list
+  [Enter] Show stash .... git stash show -u -p <stash>
+  [T] Tree .............. git stash show --name-status <stash>
+  [C] Check apply ....... git apply --check against a scratch index (nothing changes)
+
+Using a stash
+  [a] Apply ............. git stash apply <stash>
+                          Changes come back; the stash is kept.
+                          [i] instead: git stash apply --index <stash>,
+                          which also restores what was staged.
+  [P] Apply files ....... git diff <stash>^ <stash> -- <files> | git apply
+                          [o] instead: git checkout <stash> -- <files>
+                          Either way the stash is kept.
+  [p] Pop ............... git stash apply <stash>, then git stash drop <stash>
+                          The drop is skipped if the apply hit conflicts.
+  [d] Drop .............. git stash drop <stash>
+                          The stash is deleted. Its commit lingers until git
+                          garbage-collects it and can be found with git fsck.
+  [b] Branch ............ git stash branch <name> <stash>
+                          New branch from the stash's base, stash applied,
+                          then dropped.
+  [m] Rename ............ git stash store -m <msg> <stash commit>
+                          then git stash drop <old entry>
+  [Ctrl+y] Copy cmd ..... git stash show -p --include-untracked <stash> | git apply
+
+Making a stash (Build Mode)
+  [s] Save selection .... git stash push -m <msg> -- <files>
+                          with --include-untracked if any file is untracked
+  [S] Save staged ....... git stash push --staged -m <msg>
+                          Unstaged changes stay in the working tree.
+  [h] Hunks ............. git apply --cached <picked hunks>
+                          then git stash push --staged -m <msg>
+                          Esc in the message box: git apply --cached --reverse
+  [Ctrl+s] Stash all .... git stash push --include-untracked -m <msg>
+                          Ctrl+u in the message box drops --include-untracked
+                          Ctrl+k in the message box adds --keep-index
+                          Ctrl+s in the message box keeps the changes too:
+                          git stash apply --index stash@{0} right after
+  [g] Stage/unstage ..... git add -- <file>
+                          or, if it's staged, git restore --staged -- <file>
+
+Throwing changes away (Build Mode)
+  [r] Restore ........... git restore --staged --worktree .
+                          then git clean -f -d
+                          With files selected, only those:
+                          git restore --staged --worktree -- <files>,
+                          git clean -f -d -- <files>
+                          Uncommitted work and untracked files are gone for
+                          good; git can't bring them back.
+
+Handy by hand
+  git stash show -p stash@{1} | git apply --3way
+                          apply with a three-way merge for conflicts`
+
+// liveOnlyKeys run git against the stashes, so they're disabled when the stashes came
+// from a fixture instead of the repository
+var liveOnlyKeys = map[string]bool{
+	"tab": true, "ctrl+s": true, "ctrl+g": true, "a": true, "d": true, "p": true, "b": true,
+	"C": true, "O": true, "T": true, "S": true, "B": true, "f": true, "t": true, "x": true, "e": true, "m": true, "c": true,
+	"P": true, "w": true, "E": true, "i": true, "*": true, "ctrl+p": true, "D": true, "U": true, "X": true,
+}
+
+// ---------------------------------------------------------------------------
+// Update (the game loop)
+// ---------------------------------------------------------------------------
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.layout()
+		if m.splitView {
+			// The columns are laid out for the old width
+			m.rerenderDiff()
+		}
+
+	case tea.KeyMsg:
+		m.status = ""
+
+		if m.activeModal == ModalNone && msg.String() != "ctrl+c" {
+			// While a list is being filtered, keys belong to its filter input
+			if m.mode == ModeExplore && m.appState == StateOverview && m.overviewList.FilterState() == list.Filtering {
+				m.overviewList, cmd = m.overviewList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.appState == StateStashTree && m.treeList.FilterState() == list.Filtering {
+				m.treeList, cmd = m.treeList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.appState == StatePartialApply && m.partialList.FilterState() == list.Filtering {
+				m.partialList, cmd = m.partialList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.appState == StateInspect && m.inspectList.FilterState() == list.Filtering {
+				m.inspectList, cmd = m.inspectList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.appState == StateCleanUp && m.cleanupList.FilterState() == list.Filtering {
+				m.cleanupList, cmd = m.cleanupList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.appState == StateRecover && m.recoverList.FilterState() == list.Filtering {
+				m.recoverList, cmd = m.recoverList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.stashList.FilterState() == list.Filtering {
+				m.stashList, cmd = m.stashList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeBuild && m.fileList.FilterState() == list.Filtering {
+				m.fileList, cmd = m.fileList.Update(msg)
+				return m, cmd
+			}
+			if m.mode == ModeExplore && m.typeAheadActive {
+				return m.updateTypeAhead(msg)
+			}
+			if m.mode == ModeExplore && m.searchActive {
+				return m.updateSearch(msg)
+			}
+		}
+
+		if !source.Live() && m.activeModal == ModalNone && liveOnlyKeys[msg.String()] {
+			m.status = "Not available with --from-stdin"
+			return m, nil
+		}
+
+		switch {
+		case msg.String() == "ctrl+c" || msg.String() == "q" && !m.modalTakesText():
+			if m.activeModal == ModalStashMessage {
+				return m, m.cancelStashModal()
+			} else if m.activeModal != ModalNone {
+				m.activeModal = ModalNone
+			} else {
+				return m, tea.Quit
+			}
+		case msg.String() == "tab" && m.activeModal == ModalNone: // Got this idea from Opencode.ai, you should try Opencode yourself btw
+			// Toggle between modes
+			if m.mode == ModeExplore {
+				m.mode = ModeBuild
+				if m.focus == PaneFiles {
+					m.focus = PaneDiff
+				}
+				// The selection waits here from last time; reloading the list drops
+				// files that are no longer changed and re-fetches diffs that moved on
+				if len(m.selectedFiles) > 0 {
+					m.status = "Your selection is as you left it; X clears it"
+				}
+				return m, getChangedFiles()
+			} else {
+				// Build Mode is left as it is, selection and all, to come back to
+				m.mode = ModeExplore
+				m.appState = StateExplore
+			}
+		case m.activeModal == ModalDeleteConfirm:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				if marked := m.markedStashList(); len(marked) > 0 {
+					shas := make([]string, len(marked))
+					for i, s := range marked {
+						shas[i] = s.Sha
+					}
+					m.loading = true
+					return m, dropStashes(shas)
+				}
+				ref := m.selectedRef
+				return m, dropStash(ref, m.stashSha(ref))
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalPartialOverwrite:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				m.loading = true
+				return m, applyStashFiles(m.partialRef, m.partialPending, true)
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalPathFilter:
+			return m.updatePathFilterModal(msg)
+		case m.activeModal == ModalClearStashes:
+			return m.updateClearModal(msg)
+		case m.activeModal == ModalRecoverConfirm:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				if c, ok := m.recoverList.SelectedItem().(recoverCandidate); ok {
+					m.loading = true
+					return m, recoverStash(c)
+				}
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalStaleStash:
+			switch msg.String() {
+			case "enter", "esc", "y", "Y", "n", "N":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalPopConfirm:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				m.loading = true
+				return m, popStash(m.selectedRef, m.stashSha(m.selectedRef))
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalApplyConfirm:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				m.applyIndexError = ""
+				m.loading = true
+				ref := m.selectedRef
+				return m, applyStash(ref, m.stashSha(ref), false)
+			case "i", "I":
+				if m.applyIndexError != "" {
+					// --index just failed; only the plain apply is on offer
+					return m, nil
+				}
+				m.activeModal = ModalNone
+				m.loading = true
+				return m, applyStash(m.selectedRef, m.stashSha(m.selectedRef), true)
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+				m.applyIndexError = ""
+			}
+		case m.activeModal == ModalStashMessage:
+			switch msg.String() {
+			case "enter":
+				message := m.stashInput.Value()
+				flags, err := m.stashFlags()
+				if message != "" && err == nil {
+					m.activeModal = ModalNone
+					m.loading = true
+					files := m.sortedSelectedFiles()
+					untracked := m.includeUntracked()
+					m.clearStashInputs()
+					return m, createStash(files, message, m.stashScope, untracked, m.stashSnapshot, flags)
+				}
+			case "up", "down": // Step through the messages used before
+				if m.stashInput.Focused() {
+					m.stashPrefilled = false
+					m.stepHistory(msg.String() == "up")
+				}
+			case "esc":
+				return m, m.cancelStashModal()
+			case "ctrl+u": // Include untracked files or leave them be
+				if m.stashScope != ScopeStaged {
+					m.stashUntracked = !m.stashUntracked
+				}
+			case "ctrl+s": // Keep every change in the working tree: a snapshot
+				m.stashSnapshot = !m.stashSnapshot
+			case "ctrl+k": // Keep the staged changes in the index as well as stashing them
+				if m.stashScope != ScopeStaged {
+					m.stashKeepIndex = !m.stashKeepIndex
+				}
+			case "tab": // Switch between the message and the advanced flags
+				if m.flagsInput.Focused() {
+					m.flagsInput.Blur()
+					return m, m.stashInput.Focus()
+				}
+				m.stashInput.Blur()
+				return m, m.flagsInput.Focus()
+			default:
+				var cmd tea.Cmd
+				if m.flagsInput.Focused() {
+					m.flagsInput, cmd = m.flagsInput.Update(msg)
+				} else if !m.updatePrefilled(msg) {
+					m.stashInput, cmd = m.stashInput.Update(msg)
+				}
+				return m, cmd
+			}
+		case m.activeModal == ModalApplyPreview:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				if m.preview.err == nil {
+					m.loading = true
+					return m, applyStash(m.preview.ref, m.stashSha(m.preview.ref), false)
+				}
+			case "m": // Show how the conflicting files would merge
+				if len(m.preview.conflicts) > 0 {
+					m.activeModal = ModalNone
+					m.loading = true
+					paths := make([]string, len(m.preview.conflicts))
+					for i, c := range m.preview.conflicts {
+						paths[i] = c.Path
+					}
+					return m, previewMerge(m.preview.ref, paths)
+				}
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+			}
+		case m.activeModal == ModalRestoreConfirm:
+			switch msg.String() {
+			case "up", "down", "pgup", "pgdown": // Scroll the affected files
+				m.modalViewport, cmd = m.modalViewport.Update(msg)
+				return m, cmd
+			}
+			if confirmed, done := m.updateConfirm(msg, "restore"); done {
+				m.activeModal = ModalNone
+				if confirmed {
+					m.loading = true
+					return m, restoreWorkingDirectory(m.sortedSelectedFiles(), cfg.CleanExcludes)
+				}
+			}
+			return m, nil
+		case m.activeModal == ModalRestoreSession:
+			switch msg.String() {
+			case "y", "Y":
+				m.activeModal = ModalNone
+				cmd := m.restoreSession(m.pendingSession)
+				m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+				return m, tea.Batch(cmd, m.saveSession())
+			case "n", "N", "esc":
+				m.activeModal = ModalNone
+				return m, clearSession()
+			}
+			return m, nil
+		case m.activeModal == ModalStashBranch:
+			switch msg.String() {
+			case "enter":
+				name := strings.TrimSpace(m.branchInput.Value())
+				if name == "" {
+					return m, nil
+				}
+				m.activeModal = ModalNone
+				m.branchInput.SetValue("")
+				m.loading = true
+				return m, branchFromStash(name, m.selectedRef, m.branchSha)
+			case "esc":
+				m.activeModal = ModalNone
+				m.branchInput.SetValue("")
+				return m, nil
+			}
+			m.branchInput, cmd = m.branchInput.Update(msg)
+			return m, cmd
+		case m.activeModal == ModalRenameStash:
+			switch msg.String() {
+			case "enter":
+				message := strings.TrimSpace(m.stashInput.Value())
+				sel, ok := m.stashByRef(m.selectedRef)
+				if message == "" || !ok {
+					return m, nil
+				}
+				m.activeModal = ModalNone
+				m.clearStashInputs()
+				m.loading = true
+				return m, renameStash(sel, renamedMessage(sel, message))
+			case "esc":
+				m.activeModal = ModalNone
+				m.clearStashInputs()
+				return m, nil
+			}
+			m.stashInput, cmd = m.stashInput.Update(msg)
+			return m, cmd
+		case m.activeModal == ModalExportStash:
+			switch msg.String() {
+			case "enter":
+				path := strings.TrimSpace(m.exportInput.Value())
+				if path == "" {
+					return m, nil
+				}
+				m.activeModal = ModalNone
+				m.loading = true
+				return m, exportStash(m.selectedRef, path, m.exportOverwrite)
+			case "esc":
+				m.activeModal = ModalNone
+				return m, nil
+			}
+			before := m.exportInput.Value()
+			m.exportInput, cmd = m.exportInput.Update(msg)
+			if m.exportInput.Value() != before {
+				// The warning was about the old path
+				m.exportOverwrite = false
+			}
+			return m, cmd
+		case m.activeModal == ModalGlobSelect:
+			switch msg.String() {
+			case "enter":
+				matches := m.globMatches()
+				if len(matches) == 0 {
+					return m, nil
+				}
+				m.activeModal = ModalNone
+				m.globInput.SetValue("")
+				var cmds []tea.Cmd
+				for _, f := range matches {
+					m.selectedFiles[f.Path] = f
+					m.expandedFiles[f.Path] = false
+					cmds = append(cmds, getFileDiff(f, m.fileDiffOptions()))
+				}
+				m.status = fmt.Sprintf("Selected %d file(s)", len(matches))
+				return m, tea.Batch(append(cmds, m.saveSession())...)
+			case "esc":
+				m.activeModal = ModalNone
+				m.globInput.SetValue("")
+				return m, nil
+			}
+			m.globInput, cmd = m.globInput.Update(msg)
+			return m, cmd
+		case m.activeModal == ModalRawStashList || m.activeModal == ModalCheatsheet:
+			switch msg.String() {
+			case "esc", "ctrl+g", "f1":
+				m.activeModal = ModalNone
+			default:
+				var cmd tea.Cmd
+				m.modalViewport, cmd = m.modalViewport.Update(msg)
+				return m, cmd
+			}
+			return m, nil
+		case msg.String() == "f1" && m.activeModal == ModalNone: // What git runs behind each action
+			m.modalViewport.SetContent(gitCheatsheet)
+			m.modalViewport.GotoTop()
+			m.activeModal = ModalCheatsheet
+			return m, nil
+		case msg.String() == "ctrl+g" && m.activeModal == ModalNone && m.mode == ModeExplore: // Compare git's raw stash list with what Packrat parsed
+			return m, getRawStashList(m.showShared)
+		case msg.String() == "ctrl+s" && m.activeModal == ModalNone: // Stash everything, skipping file selection
+			if reason := m.stashBlocked(); reason != "" && m.mode == ModeBuild {
+				m.status = reason
+				return m, nil
+			}
+			m.openStashModal(ScopeAll)
+			return m, nil
+		case msg.String() == "ctrl+h" && m.activeModal == ModalNone: // Hide or show the help header
+			m.showHeader = !m.showHeader
+			m.layout()
+			return m, saveShowHeader(m.showHeader)
+		case msg.String() == "H" && m.activeModal == ModalNone: // Toggle abbreviated/full SHAs
+			m.fullSHA = !m.fullSHA
+			return m, nil
+		case msg.String() == "shift+tab": // Move focus between the list, the files panel and the diff
+			switch {
+			case m.focus == PaneList && m.mode == ModeExplore && m.showStashFiles:
+				m.focus = PaneFiles
+			case m.focus == PaneList || m.focus == PaneFiles:
+				m.focus = PaneDiff
+			default:
+				m.focus = PaneList
+			}
+			return m, nil
+		case msg.String() == "alt+j" || msg.String() == "alt+down": // Scroll the diff without leaving the list
+			if m.mode == ModeBuild {
+				m.buildViewport.ScrollDown(1)
+			} else {
+				m.viewport.ScrollDown(1)
+			}
+			return m, nil
+		case msg.String() == "alt+k" || msg.String() == "alt+up":
+			if m.mode == ModeBuild {
+				m.buildViewport.ScrollUp(1)
+			} else {
+				m.viewport.ScrollUp(1)
+			}
+			return m, nil
+		case msg.String() == "Y" && m.mode == ModeExplore && m.renderedDiff() != m.diff:
+			m.status = "Hunks can only be copied from the unified diff; press | first"
+			return m, nil
+		case msg.String() == "Y" && m.appState != StateHunkSelect: // Copy the hunk at the top of the diff pane
+			content, offset := m.activeDiffContent()
+			if hunk, ok := hunkAtLine(content, offset); ok {
+				return m, copyText(hunk, "hunk")
+			}
+			m.status = "No hunk at the current scroll position"
+			return m, nil
+		default:
+			if m.mode == ModeExplore && m.appState == StateOverview {
+				return m.updateOverview(msg)
+			} else if m.mode == ModeExplore && m.appState == StateStashTree {
+				return m.updateStashTree(msg)
+			} else if m.mode == ModeExplore && m.appState == StatePartialApply {
+				return m.updatePartialApply(msg)
+			} else if m.mode == ModeExplore && m.appState == StateInspect {
+				return m.updateInspect(msg)
+			} else if m.mode == ModeExplore && m.appState == StateRecover {
+				return m.updateRecover(msg)
+			} else if m.mode == ModeExplore && m.appState == StateCleanUp {
+				return m.updateCleanup(msg)
+			} else if m.mode == ModeExplore {
+				if m.focus == PaneFiles {
+					if cmd, handled := m.updateStashFiles(msg); handled {
+						return m, cmd
+					}
+				}
+
+				// Explore Mode key handlers
+				switch msg.String() {
+				case "enter": // View a stash's contents
+					if sel, ok := m.selectedStash(); ok {
+						if sel.Ref == m.displayedRef && !cfg.ReloadOnEnter {
+							return m, nil
+						}
+						return m, m.showStashDiff(sel.Ref, cfg.ReloadOnEnter)
+					}
+				case "d": // Delete a stash
+					if m.showShared {
+						m.status = "Shared stashes can't be dropped from Packrat"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						m.activeModal = ModalDeleteConfirm
+					}
+				case "D": // Drop every stash
+					if m.showShared {
+						m.status = "Shared stashes can't be dropped from Packrat"
+						return m, nil
+					}
+					if len(m.stashes) > 0 {
+						if cfg.confirmLevelFor("clear") == confirmNone {
+							return m, m.clearAll()
+						}
+						m.confirmInput.SetValue("")
+						m.activeModal = ModalClearStashes
+						return m, m.confirmInput.Focus()
+					}
+				case "X": // Find stashes that are already in HEAD, to drop them
+					if m.showShared {
+						m.status = "Shared stashes can't be dropped from Packrat"
+						return m, nil
+					}
+					if len(m.stashes) > 0 {
+						return m, m.startCleanup()
+					}
+				case "U": // Look for dropped stashes to bring back
+					if m.showShared {
+						m.status = "Dropped stashes can only be recovered into your own stash list"
+						return m, nil
+					}
+					return m, m.startRecover()
+				case "x": // Mark a stash for dropping several at once
+					if m.showShared {
+						m.status = "Shared stashes can't be dropped from Packrat"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok && sel.Sha != "" {
+						if m.markedStashes[sel.Sha] {
+							delete(m.markedStashes, sel.Sha)
+						} else {
+							m.markedStashes[sel.Sha] = true
+						}
+						m.status = fmt.Sprintf("%d stash(es) marked; d drops them all", len(m.markedStashes))
+					}
+					return m, nil
+				case "c": // Pick a stash to compare, then the one to compare it with
+					sel, ok := m.selectedStash()
+					switch {
+					case !ok:
+					case m.compareBase == "":
+						m.compareBase = sel.Ref
+						m.status = fmt.Sprintf("Comparing from %s; press c on another stash", sel.Ref)
+					case m.compareBase == sel.Ref:
+						m.compareBase = ""
+						m.status = "Comparison cancelled"
+					default:
+						base := m.compareBase
+						m.compareBase = ""
+						m.loading = true
+						return m, compareStashes(base, sel.Ref)
+					}
+					return m, nil
+				case "esc": // Leave a comparison for the selected stash's own diff, or clear the path filter
+					if m.pathFilter != "" && m.compareBase == "" && !m.comparing {
+						m.clearPathFilter()
+						return m, nil
+					}
+					if m.compareBase != "" || m.comparing {
+						m.compareBase = ""
+						m.comparing = false
+						if sel, ok := m.selectedStash(); ok {
+							return m, m.showStashDiff(sel.Ref, false)
+						}
+						return m, nil
+					}
+				case "m": // Edit a stash's message
+					if m.showShared {
+						m.status = "Shared stashes can't be renamed from Packrat"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						message := sel.Message
+						if sel.Branch != "" {
+							_, message, _ = strings.Cut(message, ": ")
+						}
+						m.stashInput.SetValue(message)
+						m.stashInput.CursorEnd()
+						m.activeModal = ModalRenameStash
+						return m, m.stashInput.Focus()
+					}
+				case "e": // Export a stash as a patch file
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						m.exportInput.SetValue(patchFileName(sel))
+						m.exportInput.CursorEnd()
+						m.exportOverwrite = false
+						m.activeModal = ModalExportStash
+						return m, m.exportInput.Focus()
+					}
+				case "a": // Apply a stash
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						m.applyIndexError = ""
+						m.activeModal = ModalApplyConfirm
+					}
+				case "P": // Pick files to apply from a stash
+					if sel, ok := m.selectedStash(); ok {
+						m.loading = true
+						return m, getPartialFiles(sel.Ref)
+					}
+				case "p": // Pop a stash: apply it, then drop it if that went cleanly
+					if m.showShared {
+						m.status = "Shared stashes can't be dropped from Packrat"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						m.activeModal = ModalPopConfirm
+					}
+				case "b": // Turn a stash into a branch
+					if m.showShared {
+						m.status = "Shared stashes can't be branched from Packrat"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok {
+						m.selectedRef = sel.Ref
+						m.branchSha = sel.Sha
+						m.branchInput.SetValue("")
+						m.activeModal = ModalStashBranch
+						return m, m.branchInput.Focus()
+					}
+					// b would otherwise page the list
+					return m, nil
+				case "]", "[": // Jump to the next or previous file in the stash diff
+					if m.displayedRef == "" && !m.comparing || len(m.diffFiles) == 0 {
+						return m, nil
+					}
+					current := m.currentDiffFile()
+					target := current + 1
+					if msg.String() == "[" {
+						target = current - 1
+						// Go back to the start of the current file first, like most pagers do
+						if current >= 0 && m.viewport.YOffset > m.diffFiles[current].Line {
+							target = current
+						}
+					}
+					if target >= 0 && target < len(m.diffFiles) {
+						m.viewport.SetYOffset(m.diffFiles[target].Line)
+					}
+					return m, nil
+				case "/": // Search the diff; with the list focused, / filters the list instead
+					if m.focus != PaneList {
+						return m, m.startSearch()
+					}
+				case "n": // Next search match
+					m.jumpToMatch(1)
+					return m, nil
+				case "N": // Previous search match
+					m.jumpToMatch(-1)
+					return m, nil
+				case "t": // Switch between the full diff and a --stat summary
+					m.statView = !m.statView
+					ref := m.displayedRef
+					if sel, ok := m.selectedStash(); ok && ref == "" {
+						ref = sel.Ref
+					}
+					if ref != "" {
+						return m, m.showStashDiff(ref, false)
+					}
+				case "f": // Show or hide the files panel above the diff
+					m.showStashFiles = !m.showStashFiles
+					if !m.showStashFiles && m.focus == PaneFiles {
+						m.focus = PaneDiff
+					}
+					m.layout()
+					if m.showStashFiles && m.displayedRef != "" && m.displayedRef != m.stashFilesRef {
+						return m, getStashFiles(m.displayedRef)
+					}
+					// f would otherwise page the list
+					return m, nil
+				case "F": // Load the full diff when only a summary is shown
+					if (m.diffSummarized || m.diffTruncated) && m.displayedRef != "" {
+						m.closeDiffStream()
+						m.loading = true
+						return m, fetchStashDiff(m.displayedRef, true, m.diffOpts)
+					}
+				case "L": // Load the next chunk of a truncated diff
+					// One chunk at a time; another L while it loads is ignored
+					if s := m.diffStream; m.diffTruncated && !m.loading && s != nil && s.ref == m.displayedRef {
+						m.loading = true
+						m.pendingDiffPosition = &diffPosition{File: -1, Offset: m.viewport.YOffset}
+						return m, nextDiffChunk(s)
+					}
+				case "|": // Switch between unified and side-by-side diffs
+					if reason := m.splitUnavailable(); !m.splitView && reason != "" {
+						m.status = reason
+						return m, nil
+					}
+					m.splitView = !m.splitView
+					m.rerenderDiff()
+					return m, nil
+				case "w": // Switch between line and word diffs
+					m.diffOpts.Words = !m.diffOpts.Words
+					if m.diffOpts.Words {
+						m.status = "Word diff"
+					} else {
+						m.status = "Line diff"
+					}
+					if m.displayedRef != "" && !m.statView {
+						return m, m.showStashDiff(m.displayedRef, false)
+					}
+					return m, nil
+				case "+", "-": // Show more or fewer lines of context around changes
+					if m.changeDiffContext(contextStep(msg.String())) {
+						return m, m.refetchStashDiff()
+					}
+					return m, nil
+				case "W": // Cycle through ignoring whitespace changes
+					m.cycleWhitespace()
+					return m, m.refetchStashDiff()
+				case "y": // Copy the displayed stash's diff as plain text
+					switch {
+					case m.displayedRef == "" && !m.comparing || strings.TrimSpace(m.diff) == "":
+						m.status = "No stash diff loaded to copy; press Enter on a stash first"
+					case m.statView || m.diffSummarized || m.diffTruncated:
+						m.status = "Only a summary is loaded; press t or F for the full diff first"
+					default:
+						what := "diff of " + m.displayedRef
+						if m.comparing {
+							what = "comparison"
+						}
+						return m, copyText(ansi.Strip(m.diff), what)
+					}
+					return m, nil
+				case "ctrl+y": // Copy a shell command that re-applies the stash
+					if sel, ok := m.selectedStash(); ok {
+						return m, copyText(applyCommand(sel.Ref), "apply command")
+					}
+				case "C": // Check whether the stash would apply cleanly
+					if sel, ok := m.selectedStash(); ok {
+						m.loading = true
+						m.status = fmt.Sprintf("Checking %s...", sel.Ref)
+						return m, previewApply(sel.Ref)
+					}
+				case "'": // Start a type-ahead jump
+					m.typeAheadActive = true
+					m.typeAhead = ""
+					m.status = "Jump to: "
+					m.typeAheadSeq++
+					return m, typeAheadTimeout(m.typeAheadSeq)
+				case "B": // Open the stash's diff in the browser
+					if sel, ok := m.selectedStash(); ok {
+						args := [][]string{{"stash", "show", "--no-color", "-u", "-p", "-M", sel.Ref}}
+						return m, openDiffInBrowser(fmt.Sprintf("%s: %s", sel.Ref, sel.Message), args)
+					}
+				case "E": // Open the file as the stash has it in $VISUAL or $EDITOR
+					if _, err := editorCommand(); err != nil {
+						m.status = "Can't open an editor: " + err.Error()
+						return m, nil
+					}
+					ref, path, untracked, ok := m.fileToOpen()
+					if !ok {
+						m.status = "Scroll the diff to a file, or pick one in the files panel (f), to open it"
+						return m, nil
+					}
+					return m, writeStashFile(ref, path, untracked)
+				case "O": // Overview of files across all stashes
+					if len(m.stashes) > 0 {
+						m.loading = true
+						m.status = "Collecting files from all stashes..."
+						return m, getStashOverview(m.stashes)
+					}
+				case "ctrl+p": // Find the stashes that touch a path
+					m.activeModal = ModalPathFilter
+					m.pathInput.SetValue("")
+					return m, tea.Batch(m.pathInput.Focus(), getTrackedFiles())
+				case "*": // Pin the stash to the top of the list, or unpin it
+					if m.showShared {
+						m.status = "Only your own stashes can be pinned"
+						return m, nil
+					}
+					if sel, ok := m.selectedStash(); ok && sel.Sha != "" {
+						return m, m.togglePin(sel)
+					}
+				case "i": // Inspect the stash one file at a time
+					if sel, ok := m.selectedStash(); ok {
+						m.loading = true
+						return m, getInspectFiles(sel.Ref)
+					}
+				case "T": // Browse the stash's files as a tree
+					if sel, ok := m.selectedStash(); ok {
+						m.loading = true
+						return m, getStashTree(sel.Ref)
+					}
+				case "S": // Switch between local and shared stashes
+					return m, tea.Batch(m.stashList.StartSpinner(), loadStashes(!m.showShared, false))
+				case "ctrl+f": // Show only the stashes taken on the current branch, or all of them
+					if m.currentBranch == "" {
+						m.status = "Couldn't tell which branch is checked out"
+						return m, nil
+					}
+					m.branchOnly = !m.branchOnly
+					m.setStashItems(m.stashes)
+					if m.branchOnly {
+						shown := 0
+						for _, s := range m.stashes {
+							if s.Branch == m.currentBranch {
+								shown++
+							}
+						}
+						m.status = fmt.Sprintf("Showing %d of %d stashes, the ones taken on %s", shown, len(m.stashes), m.currentBranch)
+					} else {
+						m.status = "Showing stashes from every branch"
+					}
+					return m, nil
+				case "R": // Reload the stash list, e.g. after stashing in another terminal
+					if sel, ok := m.selectedStash(); ok {
+						m.selectSha = sel.Sha
+					}
+					return m, tea.Batch(m.stashList.StartSpinner(), loadStashes(m.showShared, false))
+				case "z": // Group stashes under their branches
+					m.groupByBranch = !m.groupByBranch
+					m.setStashItems(m.stashes)
+					return m, nil
+				case "o": // Cycle the stash list's sort order, staying on the selected stash
+					sel, ok := m.selectedStash()
+					m.stashSortMode = (m.stashSortMode + 1) % stashSortModeCount
+					m.setStashItems(m.stashes)
+					if ok {
+						m.selectStashWhere(func(s Stash) bool { return s.Ref == sel.Ref })
+					}
+					m.status = "Sorted by " + stashSortModeName[m.stashSortMode]
+					return m, nil
+				}
+			} else if m.mode == ModeBuild && m.appState == StateHunkSelect {
+				return m.updateHunkSelect(msg)
+			} else if m.mode == ModeBuild {
+				// Build Mode key handlers
+				switch msg.String() {
+				case "enter", " ": // Select/deselect a file or toggle expansion
+					if dir, ok := m.fileList.SelectedItem().(fileDirRow); ok {
+						return m, m.updateFileDir(dir, msg.String())
+					}
+					if sel, ok := m.fileList.SelectedItem().(FileChange); ok {
+						key := sel.Path
+						if _, exists := m.selectedFiles[key]; exists {
+							// File already selected - treat space as toggle expansion
+							if msg.String() == " " {
+								m.expandedFiles[key] = !m.expandedFiles[key]
+								m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+								m.buildViewport.GotoTop()
+								// Files from select all have no diff until they're first expanded
+								cmds = append(cmds, m.fetchMissingDiffs(), m.saveSession())
+							} else if msg.String() == "enter" {
+								// Enter deselects
+								m.deselectFile(key)
+								m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+								m.buildViewport.GotoTop()
+								cmds = append(cmds, m.saveSession())
+							}
+						} else if sel.IsConflicted {
+							m.status = sel.Path + " is conflicted and can't be stashed; resolve it and git add it first"
+						} else {
+							// File not selected - select it and fetch diff
+							m.selectedFiles[key] = sel
+							m.expandedFiles[key] = false // Start collapsed
+							if sel.PartlyStaged {
+								m.status = fmt.Sprintf("Selected %s with both its staged and unstaged changes; a stash takes the whole file", sel.Path)
+							}
+							return m, tea.Batch(getFileDiff(sel, m.fileDiffOptions()), m.saveSession())
+						}
+					}
+				case "s": // Save stash (open modal)
+					if reason := m.stashBlocked(); reason != "" {
+						m.status = reason
+					} else if len(m.selectedFiles) > 0 {
+						m.openStashModal(ScopeSelection)
+					}
+				case "S": // Stash everything staged, whatever's selected
+					if reason := m.stagedStashBlocked(); reason != "" {
+						m.status = reason
+					} else if m.stagedFiles() == 0 {
+						m.status = "Nothing is staged; stage changes with g first"
+					} else {
+						m.openStashModal(ScopeStaged)
+					}
+				case "B": // Open the selected files' diffs in the browser
+					if len(m.selectedFiles) > 0 {
+						var args [][]string
+						for _, f := range m.sortedSelectedFiles() {
+							// A partly staged file is stashed whole, so it shows both sides
+							if f.IsStaged || f.PartlyStaged {
+								args = append(args, append([]string{"diff", "--no-color", "--cached", "-M", "--"}, f.pathspec()...))
+							}
+							if !f.IsStaged || f.PartlyStaged {
+								args = append(args, []string{"diff", "--no-color", "-M", "--", f.Path})
+							}
+						}
+						return m, openDiffInBrowser("Packrat - selected changes", args)
+					}
+				case "d": // Open the file's diff in an external diff tool
+					if sel, ok := m.fileList.SelectedItem().(FileChange); ok && !sel.IsUntracked {
+						return m, openDifftool(sel)
+					}
+					return m, nil // the list would otherwise page down on d
+				case "g": // Stage or unstage the file
+					if sel, ok := m.fileList.SelectedItem().(FileChange); ok {
+						return m, toggleStaged(sel)
+					}
+					return m, nil // the list would otherwise jump to the top on g
+				case "A": // Select every file in the list
+					return m, m.selectAllFiles()
+				case "ctrl+a", "N": // Deselect every file
+					return m, m.deselectAllFiles()
+				case "X": // Start over: deselect everything and forget what was reviewed
+					return m, m.startOver()
+				case "*": // Select every changed file matching a glob
+					m.globInput.SetValue("")
+					m.activeModal = ModalGlobSelect
+					return m, m.globInput.Focus()
+				case "z": // Group the file list by directory
+					m.toggleFileTree()
+					if m.fileTree {
+						m.status = "Grouped by directory; Space collapses one, Enter selects everything in it"
+					}
+					return m, nil
+				case "o": // Cycle the file list's sort order
+					m.fileSortMode = (m.fileSortMode + 1) % fileSortModeCount
+					m.setFileItems()
+					m.status = "Sorted " + fileSortModeName[m.fileSortMode]
+					return m, nil
+				case "v": // Switch between previewing the highlighted file and the selected files' diffs
+					return m, m.toggleSelectionView()
+				case "u": // Switch between collapsible diffs and one unified patch
+					m.unifiedPatch = !m.unifiedPatch
+					m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+					m.buildViewport.GotoTop()
+					return m, m.fetchMissingDiffs() // the list would otherwise page up on u
+				case "x": // Mark a file as reviewed
+					if sel, ok := m.fileList.SelectedItem().(FileChange); ok {
+						if m.reviewedFiles[sel.Path] {
+							delete(m.reviewedFiles, sel.Path)
+						} else {
+							m.reviewedFiles[sel.Path] = true
+						}
+						m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+					}
+				case "F": // Load the full diffs of files that only show a summary
+					var cmds []tea.Cmd
+					for path := range m.summarizedFiles {
+						if file, ok := m.selectedFiles[path]; ok {
+							cmds = append(cmds, fetchFileDiff(file, true, m.fileDiffOptions()))
+						}
+					}
+					if f := m.previewFile; f != nil && !m.showSelection {
+						if _, selected := m.selectedFiles[f.Path]; !selected {
+							cmds = append(cmds, fetchFileDiff(*f, true, m.fileDiffOptions()))
+						}
+					}
+					return m, tea.Batch(cmds...)
+				case "+", "-": // Show more or fewer lines of context around changes
+					if m.changeDiffContext(contextStep(msg.String())) {
+						return m, m.refetchFileDiffs()
+					}
+					return m, nil
+				case "W": // Cycle through ignoring whitespace changes
+					m.cycleWhitespace()
+					return m, m.refetchFileDiffs()
+				case "h": // Pick hunks to stage, then stash the index
+					if reason := m.stagedStashBlocked(); reason != "" {
+						m.status = reason
+					} else if m.stagedFiles() > 0 {
+						// The stash takes the whole index, not just the picked hunks
+						m.status = "Something is staged already and would be stashed with the hunks; unstage it with g first"
+					} else if sel, ok := m.fileList.SelectedItem().(FileChange); ok && !sel.IsStaged && !sel.IsUntracked {
+						m.loading = true
+						return m, getFileHunks(sel)
+					}
+				case "R": // Reload the file list, and any diffs whose files changed
+					return m, getChangedFiles()
+				case "r": // Restore the selected files, or the whole working directory
+					if cfg.confirmLevelFor("restore") == confirmNone {
+						m.loading = true
+						return m, restoreWorkingDirectory(m.sortedSelectedFiles(), cfg.CleanExcludes)
+					}
+					m.confirmInput.SetValue("")
+					m.confirmInput.Focus()
+					m.activeModal = ModalRestoreConfirm
+					m.modalViewport.SetContent("Listing affected files...")
+					m.modalViewport.GotoTop()
+					return m, previewRestore(m.sortedSelectedFiles(), cfg.CleanExcludes)
+				}
+			}
+		}
+
+	case restorePreviewMsg:
+		if m.activeModal == ModalRestoreConfirm {
+			m.modalViewport.SetContent(restorePreviewView(msg))
+			m.modalViewport.GotoTop()
+		}
+
+	case stashOverviewMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error building overview: %v", msg.err)
+		} else {
+			m.appState = StateOverview
+			m.setOverviewItems(msg.entries)
+		}
+
+	case stashTreeMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error listing stash files: %v", msg.err)
+		} else {
+			m.appState = StateStashTree
+			m.treeRef = msg.ref
+			m.treeRoot = buildFileTree(msg.files)
+			m.treeCollapsed = make(map[string]bool)
+			m.setTreeItems()
+			m.treeList.Select(0)
+		}
+
+	case inspectFilesMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error listing stash files: %v", msg.err)
+			break
+		}
+		m.appState = StateInspect
+		m.inspectRef = msg.ref
+		m.displayedRef = ""
+		m.inspectExpanded = make(map[string]bool)
+		m.inspectDiffs = make(map[string]string)
+		items := make([]list.Item, len(msg.files))
+		for i, f := range msg.files {
+			items[i] = inspectFile{f}
+		}
+		m.inspectList.SetItems(items)
+		m.inspectList.Title = "Packrat - Inspect " + msg.ref
+		m.inspectList.Select(0)
+		m.showInspectView()
+		m.viewport.GotoTop()
+
+	case inspectDiffMsg:
+		if m.appState != StateInspect || msg.ref != m.inspectRef {
+			break
+		}
+		if msg.err != nil {
+			m.inspectDiffs[msg.path] = fmt.Sprintf("Error loading diff: %v", msg.err)
+		} else {
+			m.inspectDiffs[msg.path] = msg.diff
+		}
+		m.showInspectView()
+
+	case partialFilesMsg:
+		m.loading = false
+		switch {
+		case msg.err != nil:
+			m.status = fmt.Sprintf("Error listing stash files: %v", msg.err)
+		case len(msg.files) == 0:
+			m.status = fmt.Sprintf("%s doesn't change any tracked files", msg.ref)
+		default:
+			m.appState = StatePartialApply
+			m.partialRef = msg.ref
+			items := make([]list.Item, len(msg.files))
+			for i, f := range msg.files {
+				items[i] = partialFile{treeFile: f}
+			}
+			m.partialList.SetItems(items)
+			m.partialList.Title = "Packrat - Apply files from " + msg.ref
+			m.partialList.Select(0)
+			cmds = append(cmds, m.showStashDiff(msg.ref, false))
+		}
+
+	case localChangesMsg:
+		m.loading = false
+		switch {
+		case msg.err != nil:
+			m.status = fmt.Sprintf("Error checking for local changes: %v", msg.err)
+		case len(msg.dirty) > 0:
+			m.partialPending = msg.files
+			m.partialDirty = msg.dirty
+			m.activeModal = ModalPartialOverwrite
+		default:
+			m.loading = true
+			cmds = append(cmds, applyStashFiles(msg.ref, msg.files, true))
+		}
+
+	case partialAppliedMsg:
+		m.loading = false
+		m.displayedRef = ""
+		m.appState = StateExplore
+		m.viewport.SetContent(partialAppliedView(msg))
+		m.viewport.GotoTop()
+
+	case stashFileDiffMsg:
+		m.loading = false
+		m.displayedRef = ""
+		if msg.err != nil {
+			m.diff = fmt.Sprintf("Error loading diff: %v", msg.err)
+		} else {
+			m.diff = msg.diff
+		}
+		m.viewport.SetContent(clampLines(m.diff, cfg.MaxLineWidth))
+		m.viewport.GotoTop()
+
+	case applyPreviewMsg:
+		m.loading = false
+		m.status = ""
+		m.preview = msg
+		m.activeModal = ModalApplyPreview
+
+	case fileMtimesMsg:
+		cmds = append(cmds, m.refreshChangedFiles(msg))
+
+	case rawStashListMsg:
+		m.modalViewport.SetContent(m.rawStashListView(msg))
+		m.modalViewport.GotoTop()
+		m.activeModal = ModalRawStashList
+
+	case fileListTickMsg:
+		if m.pollDue() {
+			cmds = append(cmds, getChangedFiles())
+		}
+		cmds = append(cmds, pollChangedFiles())
+
+	case previewTickMsg:
+		if msg.seq == m.previewSeq && m.previewFile != nil && !m.showSelection {
+			cmds = append(cmds, getFileDiff(*m.previewFile, m.fileDiffOptions()))
+		}
+
+	case typeAheadTimeoutMsg:
+		if msg.seq == m.typeAheadSeq && m.typeAheadActive {
+			m.typeAheadActive = false
+			m.typeAhead = ""
+			m.status = ""
+		}
+
+	case difftoolClosedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Diff tool failed: %v", msg.err)
+		}
+		if msg.modified {
+			// The tool edited the file, so the list and any shown diff are stale
+			m.status = fmt.Sprintf("%s changed in the diff tool; refreshed", msg.file.Path)
+			cmds = append(cmds, getChangedFiles())
+			if file, ok := m.selectedFiles[msg.file.Path]; ok {
+				cmds = append(cmds, refreshFileDiff(file, m.fileDiffOptions()))
+			}
+		}
+
+	case diffOpenedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error opening diff: %v", msg.err)
+		} else {
+			m.status = fmt.Sprintf("Opened %s", msg.path)
+		}
+
+	case copiedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Copy failed: %v", msg.err)
+		} else {
+			m.status = fmt.Sprintf("Copied %s (%s bytes)", msg.what, groupThousands(msg.bytes))
+		}
+
+	case stashDiffMsg:
+		if msg.err == nil && !msg.truncated {
+			m.stashDiffCache[stashDiffKey{msg.ref, msg.stat, msg.opts}] = msg
+		}
+		if msg.stat != m.statView || (!msg.stat && msg.opts != m.diffOpts) {
+			// The view or diff options changed while this was loading; a newer fetch
+			// is on its way
+			break
+		}
+		previousRef := m.displayedRef
+		m.loading = false
+		m.comparing = false
+		if msg.err != nil {
+			m.diff = fmt.Sprintf("Error loading diff: %v", msg.err)
+			m.displayedRef = ""
+		} else {
+			m.diff = msg.diff
+			m.displayedRef = msg.ref
+			if msg.summarized {
+				m.diff += "\n" + summaryNote()
+			}
+		}
+		m.diffSummarized = msg.err == nil && msg.summarized
+		m.diffTruncated = msg.err == nil && msg.truncated
+		m.showDiff()
+		if pos := m.pendingDiffPosition; pos != nil && msg.err == nil && msg.ref == previousRef {
+			m.restoreDiffPosition(*pos)
+		} else {
+			m.viewport.GotoTop()
+		}
+		m.pendingDiffPosition = nil
+		if m.showStashFiles && msg.err == nil && msg.ref != m.stashFilesRef {
+			cmds = append(cmds, getStashFiles(msg.ref))
+		}
+
+	case stashMetaMsg:
+		if msg.meta.Ref != m.metaRef {
+			// The selection moved on while this was loading
+			break
+		}
+		shown := m.stashMeta.Sha != ""
+		m.stashMeta = msg.meta
+		if msg.err != nil {
+			m.stashMeta = stashMeta{}
+		}
+		if shown != (m.stashMeta.Sha != "") {
+			m.layout()
+		}
+
+	case trackedFilesMsg:
+		m.pathInput.SetSuggestions(msg.paths)
+
+	case stashPathsMsg:
+		m.gotStashPaths(msg)
+
+	case pinSavedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Couldn't save pins: %v", msg.err)
+		}
+
+	case messageHistorySavedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Couldn't save the stash message history: %v", msg.err)
+		}
+
+	case stashFileWrittenMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Couldn't open %s: %v", msg.path, msg.err)
+			break
+		}
+		return m, openInEditor(msg)
+
+	case editorClosedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Editor failed on %s: %v", msg.path, msg.err)
+		}
+
+	case stashFilesMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error listing stash files: %v", msg.err)
+			break
+		}
+		m.stashFilesRef = msg.ref
+		m.stashFiles = msg.files
+		m.stashFileCursor = 0
+		m.layout()
+
+	case stashesLoadedMsg:
+		m.stashList.StopSpinner()
+		if msg.err != nil {
+			if !m.stashesLoaded {
+				// Nothing to show without the initial list, e.g. outside a repository
+				m.err = msg.err
+			} else {
+				m.status = fmt.Sprintf("Error loading stashes: %v", msg.err)
+			}
+			break
+		}
+		firstLoad := !m.stashesLoaded
+		m.stashesLoaded = true
+		m.showShared = msg.shared
+		m.currentBranch = msg.branch
+		m.displayedRef = ""
+		// Refs may point at different stashes now
+		clear(m.stashDiffCache)
+		m.closeDiffStream()
+		m.metaRef = ""
+		m.compareBase = ""
+		listed := make(map[string]bool, len(msg.stashes))
+		for _, s := range msg.stashes {
+			listed[s.Sha] = true
+		}
+		for sha := range m.markedStashes {
+			if !listed[sha] {
+				delete(m.markedStashes, sha)
+			}
+		}
+		if !msg.shared && source.Live() {
+			cmds = append(cmds, m.forgetGonePins(listed))
+		}
+		if m.pathFilter != "" {
+			// Stashes may have come or gone since the search
+			m.stashes = msg.stashes
+			cmds = append(cmds, m.searchStashPaths(m.pathFilter))
+		}
+		m.setStashItems(msg.stashes)
+		if msg.selectFirst {
+			m.stashList.Select(0)
+			m.skipGroupHeader(1)
+		}
+		if m.selectSha != "" {
+			m.selectStashWhere(func(s Stash) bool { return s.Sha == m.selectSha })
+			m.selectSha = ""
+		}
+		if sel, ok := m.selectedStash(); ok {
+			cmds = append(cmds, m.showStashDiff(sel.Ref, false))
+		} else if firstLoad && !msg.shared && cfg.EmptyStart == "build" && source.Live() {
+			// Nothing to explore yet, so the user most likely came to make a stash
+			m.mode = ModeBuild
+			m.status = "No stashes yet, so Packrat started in Build Mode"
+			cmds = append(cmds, getChangedFiles())
+		} else {
+			m.diff = ""
+			m.viewport.SetContent("")
+		}
+
+	case mergePreviewMsg:
+		m.loading = false
+		m.displayedRef = ""
+		m.diff = mergePreviewView(msg)
+		m.viewport.SetContent(clampLines(m.diff, cfg.MaxLineWidth))
+		m.viewport.GotoTop()
+
+	case stashDeletedMsg:
+		// Stash indexes shift after a drop, so the displayed ref no longer means the same stash
+		m.displayedRef = ""
+		if stale, ok := msg.err.(staleStashError); ok {
+			cmds = append(cmds, m.reportStaleStash(stale))
+			break
+		}
+		if msg.err != nil {
+			if msg.popped {
+				// The apply already happened, so don't hide it behind a fatal error
+				m.loading = false
+				m.viewport.SetContent(fmt.Sprintf("Stash applied, but %s couldn't be dropped: %v\n\n%s", msg.ref, msg.err, msg.output))
+				m.viewport.GotoTop()
+			} else {
+				m.err = msg.err
+			}
+		} else {
+			if msg.popped {
+				m.loading = false
+				m.status = fmt.Sprintf("Popped %s", msg.ref)
+				m.viewport.SetContent(fmt.Sprintf("Stash popped: %s was applied and dropped.\n\n%s", msg.ref, msg.output))
+				m.viewport.GotoTop()
+			}
+			// Re-fetch the list of stashes so that the indexes aren't messed up
+			cmds = append(cmds, loadStashes(false, false))
+		}
+
+	case stashBranchedMsg:
+		m.loading = false
+		m.displayedRef = ""
+		if stale, ok := msg.err.(staleStashError); ok {
+			cmds = append(cmds, m.reportStaleStash(stale))
+			break
+		}
+		if msg.err != nil {
+			m.viewport.SetContent(fmt.Sprintf("Couldn't create branch %s from %s:\n\n%s", msg.branch, msg.ref, msg.output))
+		} else {
+			m.viewport.SetContent(fmt.Sprintf("Created and checked out branch %s from %s.\nThe stash has been dropped.\n\n%s", msg.branch, msg.ref, msg.output))
+			// The stash is gone and the ones after it have shifted up
+			cmds = append(cmds, loadStashes(false, false))
+		}
+		m.viewport.GotoTop()
+
+	case stashCompareMsg:
+		m.loading = false
+		m.displayedRef = ""
+		m.comparing = true
+		header := titleStyle.Render(fmt.Sprintf("Comparing %s (base, -) with %s (+)", msg.base, msg.other))
+		for _, ref := range []string{msg.base, msg.other} {
+			header += "\n  " + ref + "  " + m.stashSummary(ref)
+		}
+		switch {
+		case msg.err != nil:
+			m.diff = fmt.Sprintf("%s\n\nError comparing stashes: %v\n%s", header, msg.err, msg.diff)
+		case strings.TrimSpace(msg.diff) == "":
+			m.diff = header + "\n\nThe two stashes leave the working tree identical."
+		default:
+			m.diff = header + "\n\n" + msg.diff
+		}
+		m.showDiff()
+		m.viewport.GotoTop()
+
+	case stashRenamedMsg:
+		m.loading = false
+		if stale, ok := msg.err.(staleStashError); ok {
+			cmds = append(cmds, m.reportStaleStash(stale))
+			break
+		}
+		if msg.err != nil {
+			m.displayedRef = ""
+			m.viewport.SetContent(fmt.Sprintf("Error renaming stash: %v\n\n%s", msg.err, msg.output))
+			m.viewport.GotoTop()
+		} else {
+			m.status = "Stash renamed"
+		}
+		// Either way the list may have changed, and the stash keeps its SHA
+		m.selectSha = msg.sha
+		cmds = append(cmds, loadStashes(false, false))
+
+	case stashExportedMsg:
+		m.loading = false
+		switch {
+		case msg.exists:
+			// Ask again before clobbering it
+			m.exportOverwrite = true
+			m.activeModal = ModalExportStash
+			cmds = append(cmds, m.exportInput.Focus())
+		case msg.err != nil:
+			m.status = fmt.Sprintf("Error exporting %s: %v", msg.ref, msg.err)
+		default:
+			m.displayedRef = ""
+			m.viewport.SetContent(fmt.Sprintf("Exported %s to\n\n  %s\n\nApply it elsewhere with: git apply %s", msg.ref, msg.path, shellQuote(msg.path)))
+			m.viewport.GotoTop()
+		}
+
+	case spinner.TickMsg:
+		// The stash list's own ticks reach it below with every other message
+		m.recoverList, cmd = m.recoverList.Update(msg)
+		cmds = append(cmds, cmd)
+		m.cleanupList, cmd = m.cleanupList.Update(msg)
+		cmds = append(cmds, cmd)
+
+	case cleanupCheckMsg:
+		m.gotCleanupCheck(msg)
+
+	case recoverCandidatesMsg:
+		m.gotDroppedStashes(msg)
+
+	case stashRecoveredMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error recovering stash: %v", msg.err)
+			break
+		}
+		m.appState = StateExplore
+		m.status = fmt.Sprintf("Recovered %q as stash@{0}", msg.candidate.Subject)
+		m.selectSha = msg.candidate.Sha
+		cmds = append(cmds, loadStashes(false, false))
+
+	case stashesClearedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error clearing stashes: %v", msg.err)
+			break
+		}
+		m.displayedRef = ""
+		clear(m.markedStashes)
+		m.status = fmt.Sprintf("Dropped all %d stash(es)", msg.cleared)
+		cmds = append(cmds, loadStashes(false, false))
+
+	case stashesDroppedMsg:
+		m.loading = false
+		m.appState = StateExplore // the drop may have come from the cleanup list
+		m.displayedRef = ""
+		clear(m.markedStashes)
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error dropping stashes: %v", msg.err)
+			break
+		}
+		content := fmt.Sprintf("Dropped %d stash(es).\n", msg.dropped)
+		if len(msg.failures) > 0 {
+			content += fmt.Sprintf("\n%d couldn't be dropped:\n  %s\n", len(msg.failures), strings.Join(msg.failures, "\n  "))
+		}
+		m.diff = content
+		m.viewport.SetContent(content)
+		m.viewport.GotoTop()
+		// One re-fetch for the whole batch
+		cmds = append(cmds, loadStashes(false, false))
+
+	case stashAppliedMsg:
+		m.loading = false
+		m.displayedRef = ""
+		if stale, ok := msg.err.(staleStashError); ok {
+			cmds = append(cmds, m.reportStaleStash(stale))
+			break
+		}
+		if msg.err == nil && msg.pop {
+			m.loading = true
+			cmds = append(cmds, dropPoppedStash(msg.ref, msg.sha, msg.output))
+			break
+		}
+		switch {
+		case msg.err != nil && len(msg.conflicts) > 0:
+			// git leaves the stash in place when the apply conflicts, pop included
+			m.status = fmt.Sprintf("%s applied with conflicts", msg.ref)
+			m.viewport.SetContent(appliedWithConflictsView(msg))
+			cmds = append(cmds, getChangedFiles())
+		case msg.err != nil && msg.index:
+			// The staged changes couldn't be restored. Nothing was applied, so offer the
+			// plain apply rather than quietly falling back to it.
+			m.selectedRef = msg.ref
+			m.applyIndexError = strings.TrimSpace(msg.output)
+			if m.applyIndexError == "" {
+				m.applyIndexError = msg.err.Error()
+			}
+			m.activeModal = ModalApplyConfirm
+		case msg.err != nil:
+			content := fmt.Sprintf("Error applying stash:\n\n%s", msg.output)
+			if msg.pop {
+				content = fmt.Sprintf("Error applying stash, so %s was kept:\n\n%s", msg.ref, msg.output)
+			}
+			m.viewport.SetContent(content)
+		default:
+			m.status = fmt.Sprintf("%s applied successfully", msg.ref)
+			m.viewport.SetContent(fmt.Sprintf("Stash applied successfully!\n\n%s", msg.output))
+			cmds = append(cmds, getChangedFiles())
+		}
+		m.viewport.GotoTop()
+
+	case changedFilesMsg:
+		if msg.err != nil {
+			m.err = msg.err
+		} else {
+			// An unchanged list is left alone, so a refresh doesn't redraw anything
+			changed := !m.changedFilesLoaded || !slices.Equal(msg.files, m.changedFiles)
+			m.changedFiles = msg.files
+			m.changedFilesLoaded = true
+			m.worktreeStat = msg.stat
+			if msg.operation != m.repoOperation {
+				m.repoOperation = msg.operation
+				m.layout() // the banner takes a line from the diff pane
+			}
+			cmds = append(cmds, m.refreshStaleDiffs(msg.mtimes))
+			if changed {
+				m.forgetPreviews()
+				m.refreshFileItems()
+			}
+			if m.cursorFile != nil {
+				m.selectFileRow(m.cursorFile.Path, m.cursorFile.IsStaged)
+				m.cursorFile = nil
+			}
+			if changed && len(m.selectedFiles) > 0 {
+				cmds = append(cmds, m.reconcileSelection(), m.saveSession())
+				m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+			}
+			if !m.sessionOffered && len(m.selectedFiles) == 0 {
+				m.sessionOffered = true
+				cmds = append(cmds, loadSession())
+			}
+		}
+
+	case fileStagedMsg:
+		m.status = msg.stagedStatus()
+		if msg.err == nil {
+			m.cursorFile = &FileChange{Path: msg.file.Path, IsStaged: !msg.file.IsStaged}
+			cmds = append(cmds, getChangedFiles())
+		}
+
+	case sessionLoadedMsg:
+		if m.mode == ModeBuild && len(m.selectedFiles) == 0 && m.activeModal == ModalNone {
+			m.pendingSession = msg.session
+			m.activeModal = ModalRestoreSession
+		}
+
+	case fileDiffMsg:
+		if msg.opts != m.fileDiffOptions() {
+			// The diff options changed while this was loading; a newer fetch is on its way
+			break
+		}
+		diff := msg.diff
+		if msg.err != nil {
+			diff = fmt.Sprintf("Error loading diff: %v", msg.err)
+		} else if msg.summarized {
+			diff += "\n" + summaryNote()
+		}
+		// A selected path keeps its selected side's diff, even if the other side is previewed
+		if sel, ok := m.selectedFiles[msg.path]; !ok || sel.IsStaged == msg.staged {
+			m.fileDiffs[msg.path] = diff
+			m.diffStaged[msg.path] = msg.staged
+			if msg.err == nil && msg.summarized {
+				m.summarizedFiles[msg.path] = true
+			} else {
+				delete(m.summarizedFiles, msg.path)
+			}
+		}
+		if !m.showSelection {
+			if m.gotPreviewDiff(msg, diff) {
+				m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+				if !msg.refreshed && !msg.keepScroll {
+					m.buildViewport.GotoTop()
+				}
+			}
+			break
+		}
+		if msg.refreshed || msg.keepScroll {
+			// Keep the scroll position so the user isn't yanked away mid-review
+			if msg.refreshed {
+				m.updatedFiles[msg.path] = time.Now()
+			}
+			m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+			break
+		}
+		m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+		m.buildViewport.GotoTop()
+
+	case fileHunksMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.buildViewport.SetContent(fmt.Sprintf("Error loading hunks:\n\n%v", msg.err))
+			m.buildViewport.GotoTop()
+		} else {
+			m.appState = StateHunkSelect
+			m.hunkFile = msg.file
+			m.hunkPatch = msg.patch
+			m.hunkCursor = 0
+			m.selectedHunks = make(map[int]bool)
+			m.buildViewport.SetContent(clampLines(m.hunkSelectView(), cfg.MaxLineWidth))
+			m.buildViewport.GotoTop()
+		}
 
-		// First, restore all modified tracked files
-		restoreCmd := exec.Command("git", "restore", ".")
-		restoreOut, restoreErr := restoreCmd.CombinedOutput()
-		output.Write(restoreOut)
+	case hunksStagedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.buildViewport.SetContent(fmt.Sprintf("Error staging hunks:\n\n%s", msg.output))
+			m.buildViewport.GotoTop()
+		} else {
+			// The picked hunks are in the index now, so stash exactly that
+			m.appState = StateExplore
+			m.stagedHunks = msg.patch
+			m.openStashModal(ScopeStaged)
+			cmds = append(cmds, getChangedFiles())
+		}
 
-		if restoreErr != nil {
-			return workingDirectoryRestoredMsg{output: output.String(), err: restoreErr}
+	case hunksUnstagedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Couldn't unstage the picked hunks: %v %s", msg.err, msg.output)
+		} else {
+			m.status = "The picked hunks were unstaged again"
 		}
+		cmds = append(cmds, getChangedFiles())
 
-		// Then, clean untracked files and directories
-		cleanCmd := exec.Command("git", "clean", "-f", "-d")
-		cleanOut, cleanErr := cleanCmd.CombinedOutput()
-		output.Write(cleanOut)
+	case stashCreatedMsg:
+		m.loading = false
+		if patch := m.stagedHunks; patch != "" {
+			m.stagedHunks = ""
+			if msg.err != nil && !msg.saved {
+				// Nothing was stashed, so leave the index as it was before the hunks were picked
+				m.loading = true
+				cmds = append(cmds, unstageHunks(patch))
+			}
+		}
+		if msg.err != nil && msg.saved && msg.snapshot {
+			m.buildViewport.SetContent(fmt.Sprintf("The snapshot was saved, but applying it back failed, so its changes are only in the stash now:\n\n%s\n\nApply the new stash from Explore Mode to get them back.", msg.output))
+			m.buildViewport.GotoTop()
+			m.createdShas = append(m.createdShas, msg.sha)
+			cmds = append(cmds, m.rememberMessage(msg.message), loadStashes(false, true), getChangedFiles())
+		} else if msg.err != nil && msg.saved {
+			m.buildViewport.SetContent(fmt.Sprintf("The stash was saved, but git couldn't remove its changes from the working tree, so they're still there as well:\n\n%s\n\nThis happens when a staged file was changed again after staging. Check the new stash, then restore or stage what's left as needed.", msg.output))
+			m.buildViewport.GotoTop()
+			m.createdShas = append(m.createdShas, msg.sha)
+			cmds = append(cmds, m.rememberMessage(msg.message), loadStashes(false, true), getChangedFiles())
+		} else if msg.err != nil {
+			m.buildViewport.SetContent(fmt.Sprintf("Error creating stash:\n\n%s", msg.output))
+		} else if msg.snapshot {
+			// Nothing in the working tree changed, so the selection still stands
+			m.createdShas = append(m.createdShas, msg.sha)
+			m.mode = ModeExplore
+			m.appState = StateExplore
+			m.displayedRef = ""
+			m.status = "Snapshot saved as stash@{0}; the working tree was not modified"
+			cmds = append(cmds, m.rememberMessage(msg.message), loadStashes(false, true))
+		} else {
+			// Success! Clear selections and return to Explore Mode
+			m.createdShas = append(m.createdShas, msg.sha)
+			cmds = append(cmds, m.rememberMessage(msg.message))
+			clear(m.selectedFiles)
+			m.expandedFiles = make(map[string]bool)
+			m.fileDiffs = make(map[string]string)
+			m.summarizedFiles = make(map[string]bool)
+			clear(m.reviewedFiles)
+			clear(m.fileMtimes)
+			clear(m.pendingMtimes)
+			clear(m.updatedFiles)
+			m.mode = ModeExplore
+			m.appState = StateExplore
+			m.displayedRef = ""
+			cmds = append(cmds, clearSession())
 
-		return workingDirectoryRestoredMsg{output: output.String(), err: cleanErr}
+			// Refresh stash list and show the new stash. The working tree isn't
+			// necessarily clean afterwards, with --keep-index or a partial selection, so
+			// reload what's left too.
+			cmds = append(cmds, loadStashes(false, true), getChangedFiles())
+		}
+
+	case workingDirectoryRestoredMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.buildViewport.SetContent(fmt.Sprintf("Error restoring working directory:\n\n%s", msg.output))
+		} else {
+			// Success! Clear selections and refresh file list
+			clear(m.selectedFiles)
+			m.expandedFiles = make(map[string]bool)
+			m.fileDiffs = make(map[string]string)
+			m.summarizedFiles = make(map[string]bool)
+			clear(m.reviewedFiles)
+			clear(m.fileMtimes)
+			clear(m.pendingMtimes)
+			clear(m.updatedFiles)
+
+			// Show success message
+			m.buildViewport.SetContent(fmt.Sprintf("Working directory restored successfully!\n\n%s", msg.output))
+			m.buildViewport.GotoTop()
+
+			// Refresh the file list (should be empty now)
+			return m, tea.Batch(getChangedFiles(), clearSession())
+		}
+
+	}
+
+	// Update viewports and lists if no modal active. Keys only go to the focused pane,
+	// so moving through the list doesn't also scroll the diff (and vice versa).
+	if m.activeModal == ModalNone {
+		_, isKey := msg.(tea.KeyMsg)
+		toList := !isKey || m.focus == PaneList
+		toDiff := !isKey || m.focus == PaneDiff
+
+		if m.mode == ModeExplore {
+			if toDiff {
+				m.viewport, cmd = m.viewport.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			if toList {
+				before := m.stashList.Index()
+				m.stashList, cmd = m.stashList.Update(msg)
+				cmds = append(cmds, cmd)
+				m.skipGroupHeader(m.stashList.Index() - before)
+			}
+		} else if m.mode == ModeBuild {
+			if toDiff {
+				m.buildViewport, cmd = m.buildViewport.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			if toList {
+				m.fileList, cmd = m.fileList.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+		}
 	}
+
+	return m, tea.Batch(cmds...)
 }
 
 // ---------------------------------------------------------------------------
-// Update (the game loop)
+// View
 // ---------------------------------------------------------------------------
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmds []tea.Cmd
-	var cmd tea.Cmd
+var (
+	borderStyle   = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(1)
+	viewportStyle = borderStyle
+	titleStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("36"))
 
-	switch msg := msg.(type) {
-	case tea.WindowSizeMsg:
-		m.width, m.height = msg.Width, msg.Height
+	focusedBorderStyle = borderStyle.BorderForeground(lipgloss.Color("36"))
+	modalStyle         = lipgloss.NewStyle().
+				Border(lipgloss.DoubleBorder()).
+				Padding(1, 2).
+				Foreground(lipgloss.Color("230")).
+				Background(lipgloss.Color("52"))
 
-		// Calculate dimensions accounting for borders and padding
-		// borderStyle adds: 2 for border (left+right or top+bottom) + 2 for padding = 4 total per dimension
-		const borderChrome = 4
+	hunkHeaderStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("36"))
+	addedLineStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	removedLineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	renameStyle      = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("33"))
+	statusStyle      = lipgloss.NewStyle().Faint(true)
+	groupHeaderStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214")).PaddingLeft(2)
+	groupCountStyle  = lipgloss.NewStyle().Faint(true).PaddingLeft(2)
+)
 
-		// Left pane (list) takes up about 75 columns
-		listPaneWidth := 75
-		listContentWidth := listPaneWidth - borderChrome
+// selectedStash returns the highlighted stash, if the cursor is on one
+func (m model) selectedStash() (Stash, bool) {
+	s, ok := m.stashList.SelectedItem().(Stash)
+	return s, ok
+}
 
-		// Right pane (viewport) takes the remaining width
-		rightPaneWidth := m.width - listPaneWidth
-		viewportContentWidth := rightPaneWidth - borderChrome
+// setStashItems shows stashes in the Explore list, grouped under branch headers when enabled
+func (m *model) setStashItems(stashes []Stash) {
+	counts := make(map[string]int)
+	for _, s := range stashes {
+		counts[s.Message]++
+	}
+	for i := range stashes {
+		stashes[i].Duplicates = counts[stashes[i].Message] - 1
+		stashes[i].Pinned = m.pinned[stashes[i].Sha] && !m.showShared
+	}
+	m.stashes = stashes
 
-		// Height calculations - both panes should have the same total height
-		// Content inside the border should be: m.height - borderChrome
-		totalContentHeight := m.height - borderChrome
+	// The branch filter only hides stashes from the list; everything else still sees them all
+	title := ""
+	if m.branchOnly {
+		var onBranch []Stash
+		for _, s := range stashes {
+			if s.Branch == m.currentBranch {
+				onBranch = append(onBranch, s)
+			}
+		}
+		stashes = onBranch
+		title = " · on " + m.currentBranch
+	}
+	if m.pathFilter != "" && m.pathSearchPending == 0 {
+		var touching []Stash
+		for _, s := range stashes {
+			if m.pathHits[s.Sha] {
+				touching = append(touching, s)
+			}
+		}
+		stashes = touching
+		title += fmt.Sprintf(" · touching %q (Esc clears)", m.pathFilter)
+	}
+	if m.stashSortMode != SortReflog {
+		stashes = sortedStashes(stashes, m.stashSortMode)
+		title += " (sorted: " + stashSortModeName[m.stashSortMode] + ")"
+	}
+	stashes = pinnedFirst(stashes)
 
-		// For the viewport, we need to account for the spacing (2 lines)
-		const headerAndSpacing = 2
-		viewportHeight := totalContentHeight - headerAndSpacing
-		if viewportHeight < 0 {
-			viewportHeight = 0
+	var items []list.Item
+	if m.groupByBranch {
+		// Groups appear in the order their newest stash does
+		var branches []string
+		groups := make(map[string][]Stash)
+		for _, s := range stashes {
+			branch := s.Branch
+			if branch == "" {
+				branch = "(unknown branch)"
+			}
+			if _, ok := groups[branch]; !ok {
+				branches = append(branches, branch)
+			}
+			groups[branch] = append(groups[branch], s)
+		}
+		for _, branch := range branches {
+			items = append(items, stashGroupHeader{Branch: branch, Count: len(groups[branch])})
+			for _, s := range groups[branch] {
+				items = append(items, s)
+			}
+		}
+		title = " (by branch)" + title
+	} else {
+		for _, s := range stashes {
+			items = append(items, s)
+		}
+		if m.showShared {
+			title = " (" + cfg.SharedRefs + ")" + title
 		}
+	}
 
-		// Set the actual component sizes for both modes
-		m.stashList.SetWidth(listContentWidth)
-		m.stashList.SetHeight(totalContentHeight)
-		m.viewport.Width = viewportContentWidth
-		m.viewport.Height = viewportHeight
+	m.stashListNote = title
+	m.stashList.SetItems(items)
+	m.skipGroupHeader(1)
+}
 
-		m.fileList.SetWidth(listContentWidth)
-		m.fileList.SetHeight(totalContentHeight)
-		m.buildViewport.Width = viewportContentWidth
-		m.buildViewport.Height = viewportHeight
+// sortedStashes returns a sorted copy of stashes. Ties keep reflog order.
+func sortedStashes(stashes []Stash, mode stashSortMode) []Stash {
+	sorted := slices.Clone(stashes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch mode {
+		case SortNewest:
+			return a.Timestamp.After(b.Timestamp)
+		case SortOldest:
+			return a.Timestamp.Before(b.Timestamp)
+		case SortByMessage:
+			return strings.ToLower(a.displayMessage()) < strings.ToLower(b.displayMessage())
+		}
+		return false
+	})
+	return sorted
+}
 
-	case tea.KeyMsg:
-		switch {
-		case msg.String() == "ctrl+c" || msg.String() == "q":
-			if m.activeModal != ModalNone {
-				m.activeModal = ModalNone
-			} else {
-				return m, tea.Quit
+// selectStashWhere puts the cursor on the first listed stash that matches
+func (m *model) selectStashWhere(match func(Stash) bool) bool {
+	for i, item := range m.stashList.Items() {
+		if s, ok := item.(Stash); ok && match(s) {
+			m.stashList.Select(i)
+			return true
+		}
+	}
+	return false
+}
+
+// skipGroupHeader moves the cursor off a group header, continuing in the direction it
+// was travelling (dir < 0 is up) and turning around at either end of the list
+func (m *model) skipGroupHeader(dir int) {
+	for _, up := range []bool{dir < 0, dir >= 0} {
+		for {
+			if _, isHeader := m.stashList.SelectedItem().(stashGroupHeader); !isHeader {
+				return
 			}
-		case msg.String() == "tab": // Got this idea from Opencode.ai, you should try Opencode yourself btw
-			// Toggle between modes
-			if m.mode == ModeExplore {
-				m.mode = ModeBuild
-				return m, getChangedFiles()
+			before := m.stashList.Index()
+			if up {
+				m.stashList.CursorUp()
 			} else {
-				m.mode = ModeExplore
-				// Clear build mode selections
-				m.selectedFiles = make(map[string]FileChange)
-				m.expandedFiles = make(map[string]bool)
-				m.fileDiffs = make(map[string]string)
-			}
-		case m.activeModal == ModalDeleteConfirm:
-			switch msg.String() {
-			case "y", "Y":
-				m.activeModal = ModalNone
-				ref := m.selectedRef
-				return m, dropStash(ref)
-			case "n", "N", "esc":
-				m.activeModal = ModalNone
-			}
-		case m.activeModal == ModalApplyConfirm:
-			switch msg.String() {
-			case "y", "Y":
-				m.activeModal = ModalNone
-				m.loading = true
-				ref := m.selectedRef
-				return m, applyStash(ref)
-			case "n", "N", "esc":
-				m.activeModal = ModalNone
-			}
-		case m.activeModal == ModalStashMessage:
-			switch msg.String() {
-			case "enter":
-				message := m.stashInput.Value()
-				if message != "" {
-					m.activeModal = ModalNone
-					m.loading = true
-					// Convert selectedFiles map to slice
-					files := make([]FileChange, 0, len(m.selectedFiles))
-					for _, f := range m.selectedFiles {
-						files = append(files, f)
-					}
-					m.stashInput.SetValue("") // Clear input
-					return m, createStash(files, message)
-				}
-			case "esc":
-				m.activeModal = ModalNone
-				m.stashInput.SetValue("") // Clear input
-			default:
-				var cmd tea.Cmd
-				m.stashInput, cmd = m.stashInput.Update(msg)
-				return m, cmd
-			}
-		case m.activeModal == ModalRestoreConfirm:
-			switch msg.String() {
-			case "y", "Y":
-				m.activeModal = ModalNone
-				m.loading = true
-				return m, restoreWorkingDirectory()
-			case "n", "N", "esc":
-				m.activeModal = ModalNone
+				m.stashList.CursorDown()
 			}
-		default:
-			if m.mode == ModeExplore {
-				// Explore Mode key handlers
-				switch msg.String() {
-				case "enter": // View a stash's contents
-					if sel, ok := m.stashList.SelectedItem().(Stash); ok {
-						m.loading = true
-						return m, getStashDiff(sel.Ref)
-					}
-				case "d": // Delete a stash
-					if sel, ok := m.stashList.SelectedItem().(Stash); ok {
-						m.selectedRef = sel.Ref
-						m.activeModal = ModalDeleteConfirm
-					}
-				case "a": // Apply a stash
-					if sel, ok := m.stashList.SelectedItem().(Stash); ok {
-						m.selectedRef = sel.Ref
-						m.activeModal = ModalApplyConfirm
-					}
-				}
-			} else if m.mode == ModeBuild {
-				// Build Mode key handlers
-				switch msg.String() {
-				case "enter", " ": // Select/deselect a file or toggle expansion
-					if sel, ok := m.fileList.SelectedItem().(FileChange); ok {
-						key := sel.Path
-						if _, exists := m.selectedFiles[key]; exists {
-							// File already selected - treat space as toggle expansion
-							if msg.String() == " " {
-								m.expandedFiles[key] = !m.expandedFiles[key]
-								m.buildViewport.SetContent(m.buildCollapsibleDiffsView())
-								m.buildViewport.GotoTop()
-							} else if msg.String() == "enter" {
-								// Enter deselects
-								delete(m.selectedFiles, key)
-								delete(m.expandedFiles, key)
-								delete(m.fileDiffs, key)
-								m.buildViewport.SetContent(m.buildCollapsibleDiffsView())
-								m.buildViewport.GotoTop()
-							}
-						} else {
-							// File not selected - select it and fetch diff
-							m.selectedFiles[key] = sel
-							m.expandedFiles[key] = false // Start collapsed
-							return m, getFileDiff(sel)
-						}
-					}
-				case "s", "S": // Save stash (open modal)
-					if len(m.selectedFiles) > 0 {
-						m.stashInput.Focus()
-						m.activeModal = ModalStashMessage
-					}
-				case "r", "R": // Restore working directory
-					m.activeModal = ModalRestoreConfirm
-				}
+			if m.stashList.Index() == before {
+				break // hit the end, try the other way
 			}
 		}
+	}
+}
 
-	case stashDiffMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.diff = fmt.Sprintf("Error loading diff: %v", msg.err)
-		} else {
-			m.diff = msg.diff
-		}
-		m.viewport.SetContent(m.diff)
-		m.viewport.GotoTop()
+// setTreeItems refreshes the tree list from the current expand/collapse state
+func (m *model) setTreeItems() {
+	rows := treeRows(m.treeRoot, m.treeCollapsed)
+	items := make([]list.Item, len(rows))
+	for i, r := range rows {
+		items[i] = r
+	}
+	m.treeList.SetItems(items)
+	m.treeList.Title = "Packrat - " + m.treeRef + " Files"
+}
 
-	case stashDeletedMsg:
-		if msg.err != nil {
-			m.err = msg.err
-		} else {
-			// Re-fetch the list of stashes so that the indexes aren't messed up
-			stashes, err := listStashes()
-			items := make([]list.Item, len(stashes))
-			for i, s := range stashes {
-				items[i] = s
-			}
-			m.stashList.SetItems(items)
+// updateStashTree handles keys while browsing a stash's files as a tree
+func (m model) updateStashTree(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.appState = StateExplore
+		// Put the whole stash back in the diff pane
+		return m, m.showStashDiff(m.treeRef, false)
+	case "enter", " ":
+		row, ok := m.treeList.SelectedItem().(treeRow)
+		if !ok {
+			return m, nil
+		}
+		if row.Node.IsDir {
+			m.treeCollapsed[row.Node.Path] = !m.treeCollapsed[row.Node.Path]
+			m.setTreeItems()
+			return m, nil
+		}
+		m.loading = true
+		return m, getStashFileDiff(m.treeRef, row.Node.File)
+	}
 
-			if len(m.stashList.Items()) > 0 {
-				ref := m.stashList.SelectedItem().(Stash).Ref
-				cmds = append(cmds, getStashDiff(ref))
-			} else {
-				m.viewport.SetContent("(no stashes)")
-			}
+	var cmd tea.Cmd
+	if m.focus == PaneDiff {
+		m.viewport, cmd = m.viewport.Update(msg)
+	} else {
+		m.treeList, cmd = m.treeList.Update(msg)
+	}
+	return m, cmd
+}
 
-			if err != nil {
-				m.viewport.SetContent(fmt.Sprintf("Error reloading stashes: %v", err))
-			}
+// globMatches are the changed files the glob input matches that aren't selected yet
+func (m model) globMatches() []FileChange {
+	pattern := strings.TrimSpace(m.globInput.Value())
+	if pattern == "" {
+		return nil
+	}
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil
+	}
+	var matches []FileChange
+	seen := make(map[string]bool)
+	for _, f := range m.changedFiles {
+		if seen[f.Path] {
+			continue
+		}
+		if _, selected := m.selectedFiles[f.Path]; selected || f.IsConflicted {
+			continue
+		}
+		target := f.Path
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(f.Path)
+		}
+		if re.MatchString(target) {
+			seen[f.Path] = true
+			matches = append(matches, f)
 		}
+	}
+	return matches
+}
 
-	case stashAppliedMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.viewport.SetContent(fmt.Sprintf("Error applying stash:\n\n%s", msg.output))
-		} else {
-			m.viewport.SetContent(fmt.Sprintf("Stash applied successfully!\n\n%s", msg.output))
+// globRegexp translates a glob where * stays within a directory and ** crosses them
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
 		}
-		m.viewport.GotoTop()
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
 
-	case changedFilesMsg:
-		if msg.err != nil {
-			m.err = msg.err
-		} else {
-			items := make([]list.Item, len(msg.files))
-			for i, f := range msg.files {
-				items[i] = f
+// setFileItems fills the Build Mode list from changedFiles in the chosen order
+func (m *model) setFileItems() {
+	if m.fileTree {
+		m.fileList.SetItems(fileTreeItems(slices.Clone(m.changedFiles), m.fileTreeCollapsed))
+		m.fileListNote = " (tree)"
+		return
+	}
+	files := slices.Clone(m.changedFiles)
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		// Conflicted files come first whatever the order, as they hold everything else up
+		if a.IsConflicted != b.IsConflicted {
+			return a.IsConflicted
+		}
+		switch m.fileSortMode {
+		case SortByPath:
+			return a.Path < b.Path
+		case SortByStatus:
+			if a.Status != b.Status {
+				return a.Status < b.Status
 			}
-			m.fileList.SetItems(items)
+			return a.Path < b.Path
+		case SortByDirectory:
+			if dirA, dirB := path.Dir(a.Path), path.Dir(b.Path); dirA != dirB {
+				return dirA < dirB
+			}
+			return a.Path < b.Path
+		case SortStagedFirst:
+			if a.IsStaged != b.IsStaged {
+				return a.IsStaged
+			}
+			return a.Path < b.Path
+		}
+		return false
+	})
+
+	items := make([]list.Item, len(files))
+	for i, f := range files {
+		items[i] = f
+	}
+	m.fileList.SetItems(items)
+	m.fileListNote = ""
+	if m.fileSortMode != SortGitStatus {
+		m.fileListNote = " (" + fileSortModeName[m.fileSortMode] + ")"
+	}
+}
+
+// setOverviewItems sorts the overview entries by the current sort order and shows them
+func (m *model) setOverviewItems(entries []overviewEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !m.overviewByPath && len(entries[i].Refs) != len(entries[j].Refs) {
+			return len(entries[i].Refs) > len(entries[j].Refs)
 		}
+		return entries[i].Path < entries[j].Path
+	})
+	items := make([]list.Item, len(entries))
+	for i, e := range entries {
+		items[i] = e
+	}
+	m.overviewList.SetItems(items)
+	if m.overviewByPath {
+		m.overviewList.Title = "Packrat - Files Across Stashes (by path)"
+	} else {
+		m.overviewList.Title = "Packrat - Files Across Stashes (by count)"
+	}
+}
+
+// overviewView describes the stashes touching the highlighted overview file
+func (m model) overviewView() string {
+	entry, ok := m.overviewList.SelectedItem().(overviewEntry)
+	if !ok {
+		return "No files found in any stash."
+	}
+
+	messages := make(map[string]string)
+	for _, s := range m.stashes {
+		messages[s.Ref] = s.Message
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("%s is touched by %d stash(es):\n\n", entry.Path, len(entry.Refs)))
+	for _, ref := range entry.Refs {
+		content.WriteString(fmt.Sprintf("  %s  %s\n", ref, messages[ref]))
+	}
+	return content.String()
+}
 
-	case fileDiffMsg:
-		if msg.err != nil {
-			m.fileDiffs[msg.path] = fmt.Sprintf("Error loading diff: %v", msg.err)
-		} else {
-			m.fileDiffs[msg.path] = msg.diff
+// updateOverview handles keys while the files-across-stashes overview is open
+func (m model) updateOverview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.overviewList.FilterState() == list.Filtering {
+		var cmd tea.Cmd
+		m.overviewList, cmd = m.overviewList.Update(msg)
+		return m, cmd
+	}
+
+	switch msg.String() {
+	case "esc":
+		m.appState = StateExplore
+		return m, nil
+	case "s": // Toggle sort order
+		m.overviewByPath = !m.overviewByPath
+		entries := make([]overviewEntry, 0, len(m.overviewList.Items()))
+		for _, item := range m.overviewList.Items() {
+			entries = append(entries, item.(overviewEntry))
 		}
-		m.buildViewport.SetContent(m.buildCollapsibleDiffsView())
-		m.buildViewport.GotoTop()
+		m.setOverviewItems(entries)
+		return m, nil
+	}
 
-	case stashCreatedMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.buildViewport.SetContent(fmt.Sprintf("Error creating stash:\n\n%s", msg.output))
-		} else {
-			// Success! Clear selections and return to Explore Mode
-			m.selectedFiles = make(map[string]FileChange)
-			m.expandedFiles = make(map[string]bool)
-			m.fileDiffs = make(map[string]string)
-			m.mode = ModeExplore
+	var cmd tea.Cmd
+	m.overviewList, cmd = m.overviewList.Update(msg)
+	return m, cmd
+}
 
-			// Refresh stash list
-			stashes, err := listStashes()
-			if err == nil {
-				items := make([]list.Item, len(stashes))
-				for i, s := range stashes {
-					items[i] = s
-				}
-				m.stashList.SetItems(items)
+// sortedSelectedFiles returns the Build Mode selection ordered by path
+func (m model) sortedSelectedFiles() []FileChange {
+	files := make([]FileChange, 0, len(m.selectedFiles))
+	for _, f := range m.selectedFiles {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
 
-				// Load the first stash's diff
-				if len(stashes) > 0 {
-					return m, getStashDiff(stashes[0].Ref)
-				}
-			}
+// updateTypeAhead extends the type-ahead prefix and moves the stash selection to the
+// next stash whose message starts with it
+func (m model) updateTypeAhead(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyEnter:
+		m.typeAheadActive = false
+		m.typeAhead = ""
+		return m, nil
+	case tea.KeyBackspace:
+		if len(m.typeAhead) > 0 {
+			runes := []rune(m.typeAhead)
+			m.typeAhead = string(runes[:len(runes)-1])
 		}
+	case tea.KeyRunes, tea.KeySpace:
+		first := m.typeAhead == ""
+		m.typeAhead += string(msg.Runes)
+		if msg.Type == tea.KeySpace {
+			m.typeAhead += " "
+		}
+		m.jumpToPrefix(m.typeAhead, first)
+	case tea.KeyTab: // Next match, e.g. among stashes with the same message
+		m.jumpToPrefix(m.typeAhead, true)
+	default:
+		return m, nil
+	}
 
-	case workingDirectoryRestoredMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.buildViewport.SetContent(fmt.Sprintf("Error restoring working directory:\n\n%s", msg.output))
-		} else {
-			// Success! Clear selections and refresh file list
-			m.selectedFiles = make(map[string]FileChange)
-			m.expandedFiles = make(map[string]bool)
-			m.fileDiffs = make(map[string]string)
-
-			// Show success message
-			m.buildViewport.SetContent(fmt.Sprintf("Working directory restored successfully!\n\n%s", msg.output))
-			m.buildViewport.GotoTop()
+	m.status = "Jump to: " + m.typeAhead
+	if s, ok := m.selectedStash(); ok && s.Duplicates > 0 {
+		m.status += fmt.Sprintf("  (%s is 1 of %d with this message, Tab for next)", s.Ref, s.Duplicates+1)
+	}
+	m.typeAheadSeq++
+	return m, typeAheadTimeout(m.typeAheadSeq)
+}
 
-			// Refresh the file list (should be empty now)
-			return m, getChangedFiles()
+// jumpToPrefix selects the first visible stash at or after the cursor (or strictly after
+// it when next is true) whose message starts with prefix, wrapping around the list
+func (m *model) jumpToPrefix(prefix string, next bool) {
+	items := m.stashList.VisibleItems()
+	if prefix == "" || len(items) == 0 {
+		return
+	}
+	prefix = strings.ToLower(prefix)
+	start := m.stashList.Index()
+	if next {
+		start++
+	}
+	for i := 0; i < len(items); i++ {
+		idx := (start + i) % len(items)
+		if s, ok := items[idx].(Stash); ok && strings.HasPrefix(strings.ToLower(s.Message), prefix) {
+			m.stashList.Select(idx)
+			return
 		}
+	}
+}
 
+// activeDiffContent returns the content of the current mode's diff pane and its scroll offset
+func (m model) activeDiffContent() (string, int) {
+	if m.mode == ModeBuild {
+		return m.buildDiffsView(), m.buildViewport.YOffset
 	}
+	return m.diff, m.viewport.YOffset
+}
 
-	// Update viewports and lists if no modal active
-	if m.activeModal == ModalNone {
-		if m.mode == ModeExplore {
-			m.viewport, cmd = m.viewport.Update(msg)
-			cmds = append(cmds, cmd)
+// buildDiffsView renders the Build Mode diff pane in the chosen layout
+func (m model) buildDiffsView() string {
+	if !m.showSelection {
+		return m.previewView()
+	}
+	if m.unifiedPatch {
+		return m.buildUnifiedPatchView()
+	}
+	return m.buildCollapsibleDiffsView()
+}
 
-			m.stashList, cmd = m.stashList.Update(msg)
-			cmds = append(cmds, cmd)
-		} else if m.mode == ModeBuild {
-			m.buildViewport, cmd = m.buildViewport.Update(msg)
-			cmds = append(cmds, cmd)
+// buildUnifiedPatchView joins the selected files' diffs into one continuous patch, in
+// the same order the collapsible view lists them
+func (m model) buildUnifiedPatchView() string {
+	if len(m.selectedFiles) == 0 {
+		return m.buildCollapsibleDiffsView()
+	}
 
-			m.fileList, cmd = m.fileList.Update(msg)
-			cmds = append(cmds, cmd)
+	var content strings.Builder
+	for _, file := range m.sortedSelectedFiles() {
+		diff, exists := m.fileDiffs[file.Path]
+		if !exists {
+			content.WriteString(statusStyle.Render("(loading "+file.Path+")") + "\n")
+			continue
 		}
+		content.WriteString(strings.TrimRight(diff, "\n") + "\n")
 	}
-
-	return m, tea.Batch(cmds...)
+	return content.String()
 }
 
-// ---------------------------------------------------------------------------
-// View
-// ---------------------------------------------------------------------------
-var (
-	borderStyle = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(1)
-	titleStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("36"))
-	modalStyle  = lipgloss.NewStyle().
-			Border(lipgloss.DoubleBorder()).
-			Padding(1, 2).
-			Foreground(lipgloss.Color("230")).
-			Background(lipgloss.Color("52"))
-)
-
 func (m model) buildCollapsibleDiffsView() string {
 	if len(m.selectedFiles) == 0 {
-		return "No files selected.\n\nSelect files from the list to see their diffs here.\n[Enter] Select file  [Space] Expand/collapse diff  [s] Create stash"
+		return "No files selected.\n\nSelect files from the list to see their diffs here.\n[Enter] Select file  [A] Select all  [Space] Expand/collapse diff  [s] Create stash  [v] Preview"
 	}
 
 	var content strings.Builder
 	content.WriteString(fmt.Sprintf("Selected files: %d\n\n", len(m.selectedFiles)))
 
 	// Sort files for consistent display
-	var sortedPaths []string
-	for path := range m.selectedFiles {
-		sortedPaths = append(sortedPaths, path)
-	}
-
-	for _, path := range sortedPaths {
-		file := m.selectedFiles[path]
+	for _, file := range m.sortedSelectedFiles() {
+		path := file.Path
 		expanded := m.expandedFiles[path]
 
 		// Show collapse/expand indicator
-		indicator := "▶"
+		indicator := cfg.Glyphs.Collapsed
 		if expanded {
-			indicator = "▼"
+			indicator = cfg.Glyphs.Expanded
 		}
 
-		statusStr := "unstaged"
-		if file.IsStaged {
-			statusStr = "staged"
+		statusStr := file.Description()
+		if file.IsUntracked {
+			statusStr += ", leaves the working tree when stashed"
 		}
 
-		content.WriteString(fmt.Sprintf("%s %s (%s)\n", indicator, path, statusStr))
+		reviewedMark := ""
+		if m.reviewedFiles[path] {
+			reviewedMark = " ✓ reviewed"
+		}
+		if _, ok := m.updatedFiles[path]; ok {
+			reviewedMark += statusStyle.Render(" • updated")
+		}
+
+		content.WriteString(fmt.Sprintf("%s %s (%s)%s\n", indicator, path, statusStr, reviewedMark))
 
 		if expanded {
 			diff, exists := m.fileDiffs[path]
@@ -713,28 +4374,662 @@ func (m model) buildCollapsibleDiffsView() string {
 	return content.String()
 }
 
+// hunkSelectView renders the hunks of the file being picked, with the cursor and picked markers
+func (m model) hunkSelectView() string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("Pick hunks to stage from %s; they're stashed on their own, or unstaged again if the stash is cancelled\n\n", m.hunkFile.Path))
+
+	for i, hunk := range m.hunkPatch.Hunks {
+		cursor := "  "
+		if i == m.hunkCursor {
+			cursor = "> "
+		}
+		check := "[ ]"
+		if m.selectedHunks[i] {
+			check = "[x]"
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s\n", cursor, check, hunkHeaderStyle.Render(hunk.Header)))
+		for _, line := range hunk.Lines {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				line = addedLineStyle.Render(line)
+			case strings.HasPrefix(line, "-"):
+				line = removedLineStyle.Render(line)
+			}
+			content.WriteString("      " + line + "\n")
+		}
+		content.WriteString("\n")
+	}
+	return content.String()
+}
+
+// hunkOffset returns the viewport line where hunk i starts in hunkSelectView
+func (m model) hunkOffset(i int) int {
+	offset := 2 // title and blank line
+	for _, hunk := range m.hunkPatch.Hunks[:i] {
+		offset += len(hunk.Lines) + 2
+	}
+	return offset
+}
+
+// updateHunkSelect handles keys while picking hunks in Build Mode
+func (m model) updateHunkSelect(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.hunkCursor > 0 {
+			m.hunkCursor--
+		}
+	case "down", "j":
+		if m.hunkCursor < len(m.hunkPatch.Hunks)-1 {
+			m.hunkCursor++
+		}
+	case " ":
+		m.selectedHunks[m.hunkCursor] = !m.selectedHunks[m.hunkCursor]
+	case "enter": // Stage the picked hunks and stash the index
+		picked := 0
+		for _, on := range m.selectedHunks {
+			if on {
+				picked++
+			}
+		}
+		if picked == 0 {
+			return m, nil
+		}
+		m.loading = true
+		return m, stageHunks(m.hunkPatch.buildPatch(m.selectedHunks))
+	case "esc":
+		m.appState = StateExplore
+		m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+		m.buildViewport.GotoTop()
+		return m, nil
+	}
+	m.buildViewport.SetContent(clampLines(m.hunkSelectView(), cfg.MaxLineWidth))
+	m.buildViewport.SetYOffset(m.hunkOffset(m.hunkCursor))
+	return m, nil
+}
+
+// updateConfirm feeds a key to a destructive operation's confirmation, honoring the
+// configured confirm level. done reports whether the modal should close.
+func (m *model) updateConfirm(msg tea.KeyMsg, operation string) (confirmed, done bool) {
+	if cfg.confirmLevelFor(operation) != confirmType {
+		switch msg.String() {
+		case "y", "Y":
+			return true, true
+		case "n", "N", "esc":
+			return false, true
+		}
+		return false, false
+	}
+
+	switch msg.String() {
+	case "enter":
+		if strings.TrimSpace(m.confirmInput.Value()) == operation {
+			return true, true
+		}
+		m.status = fmt.Sprintf("Type %q to confirm", operation)
+		return false, false
+	case "esc":
+		return false, true
+	}
+	m.confirmInput, _ = m.confirmInput.Update(msg)
+	return false, false
+}
+
+// modalTakesText reports whether the open modal is collecting typed text, so single-letter
+// shortcuts like q must be left to its input
+func (m model) modalTakesText() bool {
+	switch m.activeModal {
+	case ModalStashMessage, ModalGlobSelect, ModalStashBranch, ModalExportStash, ModalRenameStash, ModalPathFilter:
+		return true
+	case ModalRestoreConfirm:
+		return cfg.confirmLevelFor("restore") == confirmType
+	case ModalClearStashes:
+		return cfg.confirmLevelFor("clear") == confirmType
+	}
+	return false
+}
+
+// cancelStashModal closes the stash modal without stashing, unstaging the hunks it
+// was opened for, if any
+func (m *model) cancelStashModal() tea.Cmd {
+	m.activeModal = ModalNone
+	m.clearStashInputs()
+	patch := m.stagedHunks
+	if patch == "" {
+		return nil
+	}
+	// Leave the index as it was before the hunks were picked
+	m.stagedHunks = ""
+	m.loading = true
+	return unstageHunks(patch)
+}
+
+// clearStashInputs resets the stash modal for next time
+func (m *model) clearStashInputs() {
+	m.stashInput.SetValue("")
+	m.flagsInput.SetValue("")
+	m.flagsInput.Blur()
+	m.stashPrefilled = false
+	m.historyIndex = -1
+	m.historyDraft = ""
+}
+
+// stashCommandPreview shows the exact command the stash modal will run, or why the
+// advanced flags were rejected
+func (m model) stashCommandPreview() string {
+	flags, err := m.stashFlags()
+	if err != nil {
+		return removedLineStyle.Render("✗ " + err.Error())
+	}
+	message := m.stashInput.Value()
+	if message == "" {
+		message = "<message>"
+	}
+	args := stashPushArgs(m.sortedSelectedFiles(), message, m.stashScope, m.includeUntracked(), flags)
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	command := "$ git " + strings.Join(quoted, " ")
+	if cleanup := renameCleanupArgs(m.sortedSelectedFiles(), m.stashScope, flags); cleanup != nil {
+		for i, arg := range cleanup {
+			cleanup[i] = shellQuote(arg)
+		}
+		command += " && git " + strings.Join(cleanup, " ")
+	}
+	if m.stashSnapshot {
+		command += " && git " + strings.Join(snapshotApplyArgs("stash@{0}"), " ")
+	}
+	return statusStyle.Render(command)
+}
+
+// confirmPrompt is the closing line of a destructive operation's modal
+func (m model) confirmPrompt(operation string) string {
+	if cfg.confirmLevelFor(operation) == confirmType {
+		prompt := fmt.Sprintf("Type %q to confirm:\n\n%s\n", operation, m.confirmInput.View())
+		if m.status != "" {
+			prompt += m.status + "\n"
+		}
+		return prompt + "\n[Enter] Confirm   [Esc] Cancel"
+	}
+	return "Are you sure?\n\n[y] Yes   [n] No"
+}
+
+// applyPreviewView describes the outcome of the last dry-run apply
+func (m model) applyPreviewView() string {
+	p := m.preview
+	switch {
+	case p.err != nil:
+		return fmt.Sprintf("Couldn't check %s:\n\n%v\n\n[n] Close", p.ref, p.err)
+	case len(p.conflicts) == 0 && p.output == "":
+		return fmt.Sprintf("✓ %s applies cleanly.\n\n[y] Apply now   [n] Close", p.ref)
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("✗ %s would not apply cleanly.\n\n", p.ref))
+	if len(p.conflicts) == 0 {
+		b.WriteString(strings.TrimSpace(p.output) + "\n")
+	}
+	for _, c := range p.conflicts {
+		b.WriteString(fmt.Sprintf("  %s — %s\n", c.Path, c.Reason))
+	}
+	if len(p.conflicts) > 0 {
+		b.WriteString("\n[y] Apply anyway   [m] Merge preview   [n] Close")
+	} else {
+		b.WriteString("\n[y] Apply anyway   [n] Close")
+	}
+	return b.String()
+}
+
+// rawStashListView puts git's raw stash listing above Packrat's reading of it, so parsing
+// problems with odd messages or encodings stand out
+func (m model) rawStashListView(msg rawStashListMsg) string {
+	var b strings.Builder
+	if m.showShared {
+		b.WriteString(fmt.Sprintf("Raw `git for-each-ref %s`:\n\n", cfg.SharedRefs))
+	} else {
+		b.WriteString("Raw `git stash list`:\n\n")
+	}
+	if msg.err != nil {
+		b.WriteString(fmt.Sprintf("Error: %v\n", msg.err))
+	}
+	b.WriteString(msg.raw)
+	if msg.raw == "" {
+		b.WriteString("(empty)\n")
+	}
+
+	b.WriteString(fmt.Sprintf("\nParsed by Packrat (%d stashes):\n", len(m.stashes)))
+	for _, s := range m.stashes {
+		b.WriteString(fmt.Sprintf("\n%s\n", s.Ref))
+		b.WriteString(fmt.Sprintf("  message: %q\n", s.Message))
+		b.WriteString(fmt.Sprintf("  branch:  %q\n", s.Branch))
+		b.WriteString(fmt.Sprintf("  created: %s (%s)\n", s.Created, s.Timestamp.Format(time.RFC3339)))
+		b.WriteString(fmt.Sprintf("  commit:  %s  base: %s\n", s.Sha, s.BaseSha))
+		if s.Extra != "" {
+			b.WriteString(fmt.Sprintf("  extra:   %q\n", s.Extra))
+		}
+	}
+	return b.String()
+}
+
 func (m model) renderModal() string {
 	switch m.activeModal {
+	case ModalPathFilter:
+		return m.pathFilterModalView()
+	case ModalClearStashes:
+		return m.clearModalView()
+	case ModalRecoverConfirm:
+		return m.recoverConfirmView()
 	case ModalDeleteConfirm:
-		return modalStyle.Render(fmt.Sprintf("Delete %s?\n\n[y] Yes   [n] No", m.selectedRef))
+		if marked := m.markedStashList(); len(marked) > 0 {
+			var b strings.Builder
+			b.WriteString(fmt.Sprintf("Drop %d marked stash(es)?\n\n", len(marked)))
+			for _, s := range marked {
+				b.WriteString(fmt.Sprintf("  %s %s\n", s.Ref, s.displayMessage()))
+			}
+			b.WriteString("\n[y] Yes   [n] No")
+			return modalStyle.Render(b.String())
+		}
+		return modalStyle.Render(fmt.Sprintf("Delete %s?\n%s\n\n[y] Yes   [n] No", m.selectedRef, m.stashSummary(m.selectedRef)))
 	case ModalApplyConfirm:
-		return modalStyle.Render(fmt.Sprintf("Apply %s?\n\n[y] Yes   [n] No", m.selectedRef))
+		if m.applyIndexError != "" {
+			return modalStyle.Render(fmt.Sprintf("git stash apply --index %s failed:\n\n%s\n\n%s\n\n[y] Apply without --index   [n] Cancel",
+				m.selectedRef, removedLineStyle.Render(m.applyIndexError),
+				statusStyle.Render("Without --index the changes come back, but all of them unstaged.")))
+		}
+		return modalStyle.Render(fmt.Sprintf("Apply %s?\n%s\n\n%s\n\n[y] Apply   [i] Apply --index   [n] Cancel", m.selectedRef, m.stashSummary(m.selectedRef),
+			statusStyle.Render("Apply leaves every change unstaged; --index also restores what was staged when you stashed.")))
+	case ModalPartialOverwrite:
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Overwrite with the versions in %s?\n\n", m.partialRef))
+		b.WriteString(removedLineStyle.Render("⚠ These files have local changes that will be lost:") + "\n")
+		for _, path := range m.partialDirty {
+			b.WriteString("  " + path + "\n")
+		}
+		b.WriteString("\n" + statusStyle.Render("Enter in the picker applies the stash's changes on top of them instead.") + "\n\n")
+		b.WriteString("[y] Overwrite   [n] Cancel")
+		return modalStyle.Render(b.String())
+	case ModalStaleStash:
+		return modalStyle.Render(fmt.Sprintf("%s\n\n%s\n\n[Enter] OK",
+			removedLineStyle.Render(fmt.Sprintf("⚠ Nothing was done: %s.", m.staleStash.Error())),
+			"Stashes were made or dropped outside Packrat since the list loaded.\nThe list has been refreshed; check which stash is selected and try again."))
+	case ModalPopConfirm:
+		return modalStyle.Render(fmt.Sprintf("Pop %s?\n%s\n\nIt's dropped only if it applies cleanly.\n\n[y] Yes   [n] No", m.selectedRef, m.stashSummary(m.selectedRef)))
 	case ModalStashMessage:
-		content := fmt.Sprintf("Create Stash\n\n%s\n\n[Enter] Save   [Esc] Cancel", m.stashInput.View())
+		title := "Create Stash"
+		switch m.stashScope {
+		case ScopeStaged:
+			title = "Create Stash (all staged changes)"
+			if m.stagedHunks != "" {
+				title = "Create Stash (the picked hunks; Esc unstages them again)"
+			}
+		case ScopeAll:
+			title = "Create Stash (ALL changes)"
+		}
+		input := m.stashInput
+		if m.stashPrefilled {
+			input.TextStyle = input.TextStyle.Reverse(true)
+		}
+		content := fmt.Sprintf("%s\n\n%s\n\nAdvanced flags:\n%s\n\n%s%s%s%s\n\n%s[Enter] Save   [Tab] Message/Flags   [↑/↓] History   [Ctrl+u] Untracked   [Ctrl+k] Keep index   [Ctrl+s] Keep changes   [Esc] Cancel",
+			title, input.View(), m.flagsInput.View(), m.untrackedToggleView(), m.keepIndexView(), m.snapshotView(), m.stashCommandPreview(), m.untrackedWarning()+m.partlyStagedNote())
+		return modalStyle.Render(content)
+	case ModalApplyPreview:
+		return modalStyle.Render(m.applyPreviewView())
+	case ModalRestoreSession:
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Restore the %d file(s) you had selected last time?\n\n", len(m.pendingSession.Files)))
+		for _, f := range m.pendingSession.Files {
+			b.WriteString("  " + f.Title() + "\n")
+		}
+		b.WriteString("\nFiles that are no longer changed are skipped.\n\n[y] Restore   [n] Discard")
+		return modalStyle.Render(b.String())
+	case ModalStashBranch:
+		content := fmt.Sprintf("Create a branch from %s\n%s\n\n%s\n\n%s\n\n[Enter] Create   [Esc] Cancel",
+			m.selectedRef, m.stashSummary(m.selectedRef), m.branchInput.View(),
+			statusStyle.Render("The branch starts at the commit the stash was made on, gets the stash applied, and the stash is dropped."))
 		return modalStyle.Render(content)
+	case ModalRenameStash:
+		content := fmt.Sprintf("Rename %s\n%s\n\n%s\n\n", m.selectedRef, m.stashSummary(m.selectedRef), m.stashInput.View())
+		if s, ok := m.stashByRef(m.selectedRef); ok && s.Branch != "" {
+			content += statusStyle.Render(fmt.Sprintf("Saved as \"On %s: …\". The renamed stash moves to the top of the list.", s.Branch)) + "\n\n"
+		} else {
+			content += statusStyle.Render("The renamed stash moves to the top of the list.") + "\n\n"
+		}
+		return modalStyle.Render(content + "[Enter] Rename   [Esc] Cancel")
+	case ModalExportStash:
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Export %s as a patch\n%s\n\n", m.selectedRef, m.stashSummary(m.selectedRef)))
+		b.WriteString(m.exportInput.View() + "\n\n")
+		if m.exportOverwrite {
+			b.WriteString(removedLineStyle.Render("⚠ That file already exists. Press Enter again to overwrite it.") + "\n\n")
+		}
+		b.WriteString(statusStyle.Render("Untracked files in the stash are included. Apply it with git apply.") + "\n\n")
+		b.WriteString("[Enter] Export   [Esc] Cancel")
+		return modalStyle.Render(b.String())
+	case ModalGlobSelect:
+		var b strings.Builder
+		b.WriteString("Select files matching a glob\n\n")
+		b.WriteString(m.globInput.View() + "\n\n")
+		matches := m.globMatches()
+		if m.globInput.Value() != "" {
+			b.WriteString(fmt.Sprintf("%d file(s) match\n", len(matches)))
+			for i, f := range matches {
+				if i == 10 {
+					b.WriteString(fmt.Sprintf("  … and %d more\n", len(matches)-10))
+					break
+				}
+				b.WriteString("  " + f.Title() + "\n")
+			}
+		}
+		b.WriteString("\n" + statusStyle.Render("* matches within a directory, ** across directories; a pattern without / matches file names anywhere"))
+		b.WriteString("\n\n[Enter] Select   [Esc] Cancel")
+		return modalStyle.Render(b.String())
+	case ModalRawStashList, ModalCheatsheet:
+		return modalStyle.Render(m.modalViewport.View() + "\n\n[j/k] Scroll   [Esc] Close")
 	case ModalRestoreConfirm:
 		warning := "⚠️  WARNING ⚠️\n\n"
-		warning += "This will restore your working directory to a clean state.\n"
-		warning += "All staged and unstaged changes will be LOST!\n"
-		warning += "Untracked files will be DELETED!\n\n"
-		warning += "Are you sure?\n\n"
-		warning += "[y] Yes   [n] No"
+		if n := len(m.selectedFiles); n > 0 {
+			warning += fmt.Sprintf("Restore %d selected file(s)\n\n", n)
+			warning += "Their changes will be LOST, and the untracked ones DELETED!\n"
+			warning += "Nothing else in the working directory is touched.\n"
+		} else {
+			warning += "Restore ENTIRE working directory\n\n"
+			warning += "This will restore your working directory to a clean state.\n"
+			warning += "All staged and unstaged changes will be LOST!\n"
+			warning += "Untracked files will be DELETED!\n"
+		}
+		if len(cfg.CleanExcludes) > 0 {
+			warning += fmt.Sprintf("(Except files matching: %s)\n", strings.Join(cfg.CleanExcludes, ", "))
+		}
+		warning += "\n"
+
+		// The file list gets whatever height the rest of the modal leaves over
+		files := m.modalViewport
+		files.Height = min(files.TotalLineCount(), max(m.height-24, 3))
+		files.Width = min(files.Width, 90)
+		warning += files.View() + "\n"
+		if files.TotalLineCount() > files.Height {
+			warning += statusStyle.Render("[↑/↓] Scroll files") + "\n"
+		}
+		warning += "\n"
+		warning += m.confirmPrompt("restore")
 		return modalStyle.Render(warning)
 	default:
 		return ""
 	}
 }
 
+// appliedWithConflictsView puts the files to resolve above git's own output
+func appliedWithConflictsView(msg stashAppliedMsg) string {
+	var b strings.Builder
+	b.WriteString(removedLineStyle.Render(fmt.Sprintf("%s applied with conflicts in %d file(s):", msg.ref, len(msg.conflicts))) + "\n\n")
+	for _, c := range msg.conflicts {
+		b.WriteString(fmt.Sprintf("  %s — %d conflict marker(s)\n", c.Path, c.Markers))
+	}
+	b.WriteString(fmt.Sprintf("\nResolve them and git add each file; Tab lists them in Build Mode. %s is still in the stash list.\n\n", msg.ref))
+	b.WriteString(statusStyle.Render("git's output:") + "\n" + msg.output)
+	return b.String()
+}
+
+// statusLine shows transient feedback (or details of the displayed stash) on the left
+// and the diff scroll position on the right
+func (m model) statusLine(vp viewport.Model) string {
+	left := m.status
+	if m.searchActive && m.mode == ModeExplore {
+		left = m.searchInput.View()
+	} else if left == "" && m.mode == ModeExplore {
+		left = m.stashInfo()
+	} else if left == "" && m.mode == ModeBuild && m.appState != StateHunkSelect {
+		left = m.worktreeStat.String() + " · " + m.diffOptionsInfo()
+	}
+	position := ""
+	if total := vp.TotalLineCount(); total > 0 {
+		position = fmt.Sprintf("line %d/%d", min(vp.YOffset+1, total), total)
+	}
+	gap := max(vp.Width-lipgloss.Width(left)-lipgloss.Width(position), 1)
+	return statusStyle.Render(left + strings.Repeat(" ", gap) + position)
+}
+
+// stashInfo names the commits behind the stash whose diff is displayed, and the file
+// the diff is scrolled to
+func (m model) stashInfo() string {
+	info := ""
+	for _, s := range m.stashes {
+		if s.Ref == m.displayedRef && s.Sha != "" {
+			info = fmt.Sprintf("%s %s · base %s", s.Ref, m.formatSHA(s.Sha), m.formatSHA(s.BaseSha))
+		}
+	}
+	if i := m.currentDiffFile(); m.displayedRef != "" && i >= 0 {
+		info += fmt.Sprintf(" · %d/%d %s", i+1, len(m.diffFiles), m.diffFiles[i].Path)
+	}
+	switch {
+	case m.statView:
+		info += " · --stat"
+	case m.displayedRef == "":
+	case m.diffOpts.Words:
+		info += " · " + m.diffOptionsInfo() + " · word diff"
+	case m.splitView && m.splitUnavailable() == "":
+		info += " · " + m.diffOptionsInfo() + " · side by side"
+	default:
+		info += " · " + m.diffOptionsInfo()
+	}
+	return strings.TrimPrefix(info, " · ")
+}
+
+// currentDiffFile is the index of the file the top of the stash diff is in, or -1 above
+// the first file
+func (m model) currentDiffFile() int {
+	current := -1
+	for i, h := range m.diffFiles {
+		if h.Line > m.viewport.YOffset {
+			break
+		}
+		current = i
+	}
+	return current
+}
+
+// stashSha is the SHA a listed stash had when the list loaded, or "" if it's unknown
+func (m model) stashSha(ref string) string {
+	s, _ := m.stashByRef(ref)
+	return s.Sha
+}
+
+// reportStaleStash explains why an apply or drop was refused and reloads the list
+func (m *model) reportStaleStash(stale staleStashError) tea.Cmd {
+	m.loading = false
+	m.staleStash = stale
+	m.activeModal = ModalStaleStash
+	// Follow the stash the user meant, wherever it is now
+	m.selectSha = stale.Want
+	return tea.Batch(m.stashList.StartSpinner(), loadStashes(m.showShared, false))
+}
+
+// stashByRef finds a listed stash
+func (m model) stashByRef(ref string) (Stash, bool) {
+	for _, s := range m.stashes {
+		if s.Ref == ref {
+			return s, true
+		}
+	}
+	return Stash{}, false
+}
+
+// markedStashList is the marked stashes in list order
+func (m model) markedStashList() []Stash {
+	var marked []Stash
+	for _, s := range m.stashes {
+		if m.markedStashes[s.Sha] {
+			marked = append(marked, s)
+		}
+	}
+	return marked
+}
+
+// stashSummary identifies a stash beyond its ref, so confirmations make clear which of
+// several same-named stashes is meant
+func (m model) stashSummary(ref string) string {
+	for _, s := range m.stashes {
+		if s.Ref == ref {
+			return fmt.Sprintf("%q · %s · %s", s.displayMessage(), s.Created, m.formatSHA(s.Sha))
+		}
+	}
+	return ""
+}
+
+// formatSHA abbreviates a SHA unless full SHAs were toggled on with H
+func (m model) formatSHA(sha string) string {
+	if m.fullSHA || len(sha) <= 7 {
+		return sha
+	}
+	return sha[:7]
+}
+
+// layout sizes every list and viewport to fit the terminal
+func (m *model) layout() {
+	// Calculate dimensions accounting for borders and padding
+	// borderStyle adds its border plus padding on both sides, which depends on packrat.border and packrat.padding
+	borderChromeX := borderStyle.GetHorizontalFrameSize()
+	borderChromeY := borderStyle.GetVerticalFrameSize()
+
+	// Left pane (list) takes up about 75 columns
+	listPaneWidth := 75
+	listContentWidth := listPaneWidth - borderChromeX
+
+	// Right pane (viewport) takes the remaining width
+	rightPaneWidth := m.width - listPaneWidth
+	viewportContentWidth := rightPaneWidth - borderChromeX
+
+	// Height calculations - both panes should have the same total height
+	// Content inside the border should be: m.height - borderChromeY
+	totalContentHeight := m.height - borderChromeY
+
+	// The diff pane loses a line to the status line, and as many as the help header
+	// wraps to unless it's hidden with ctrl+h
+	m.viewport.Width = viewportContentWidth
+	m.buildViewport.Width = viewportContentWidth
+	m.headerHeight = m.helpHeaderHeight()
+	headerAndSpacing := 1 + m.headerHeight
+	viewportHeight := totalContentHeight - headerAndSpacing
+	if viewportHeight < 0 {
+		viewportHeight = 0
+	}
+	// The metadata block and the stash files panel sit between the status line and the stash diff
+	exploreViewportHeight := max(viewportHeight-m.stashFilesHeight(), 0)
+	if m.stashMeta.Sha != "" {
+		exploreViewportHeight = max(exploreViewportHeight-stashMetaHeight, 0)
+	}
+
+	// Set the actual component sizes for both modes
+	m.stashList.SetWidth(listContentWidth)
+	m.stashList.SetHeight(totalContentHeight)
+	m.treeList.SetWidth(listContentWidth)
+	m.treeList.SetHeight(totalContentHeight)
+	m.partialList.SetWidth(listContentWidth)
+	m.partialList.SetHeight(totalContentHeight)
+	m.inspectList.SetWidth(listContentWidth)
+	m.inspectList.SetHeight(totalContentHeight)
+	m.recoverList.SetWidth(listContentWidth)
+	m.recoverList.SetHeight(totalContentHeight)
+	m.cleanupList.SetWidth(listContentWidth)
+	m.cleanupList.SetHeight(totalContentHeight)
+	m.overviewList.SetWidth(listContentWidth)
+	m.overviewList.SetHeight(totalContentHeight)
+	m.viewport.Height = exploreViewportHeight
+
+	m.fileList.SetWidth(listContentWidth)
+	m.fileList.SetHeight(totalContentHeight)
+	m.buildViewport.Height = viewportHeight
+	if m.repoOperation.name != "" {
+		m.buildViewport.Height = max(viewportHeight-1, 0)
+	}
+
+	// Scrollable modals leave a margin around themselves for their border and padding
+	m.modalViewport.Width = max(m.width-16, 0)
+	m.modalViewport.Height = max(m.height-12, 0)
+}
+
+// helpText is the key help for the current screen
+func (m model) helpText() string {
+	switch {
+	case m.mode == ModeExplore && m.appState == StateOverview:
+		return "[s] Toggle sort (count/path)  [/] Filter  [Esc] Back to stashes  [q] Quit"
+	case m.mode == ModeExplore && m.appState == StateStashTree:
+		return "[Enter] Show file / toggle directory  [Shift+Tab] Focus  [Esc] Back to stashes  [q] Quit"
+	case m.mode == ModeExplore && m.appState == StateInspect:
+		return "[Enter/Space] Expand/collapse file  [Shift+Tab] Focus  [Esc] Back to stashes  [q] Quit"
+	case m.mode == ModeExplore && m.appState == StateCleanUp:
+		return fmt.Sprintf("[Space] Mark stash  [d] Drop marked (%d)  [/] Filter  [Shift+Tab] Focus  [Esc] Back to stashes  [q] Quit", m.markedCleanupCount())
+	case m.mode == ModeExplore && m.appState == StateRecover:
+		return "[Enter] Recover stash  [/] Filter  [Shift+Tab] Focus  [Esc] Back to stashes  [q] Quit"
+	case m.mode == ModeExplore && m.appState == StatePartialApply:
+		return fmt.Sprintf("[Space] Pick file (%d)  [Enter] Apply picked  [o] Overwrite picked with stash versions  [Shift+Tab] Focus  [Esc] Back to stashes  [q] Quit", len(m.pickedPartialFiles()))
+	case m.mode == ModeExplore:
+		return "[Enter] Show stash  [f] Files  [[/]] Prev/next file  [/] Search diff (diff focused)  [n/N] Next/prev match  [t] Stat/diff  [w] Word diff  [+/-] Context  [W] Whitespace  [|] Side by side  [F] Full diff  [L] Load more  [a] Apply  [P] Apply files  [p] Pop  [b] Branch  [C] Check apply  [x] Mark  [d] Drop  [D] Drop all  [U] Recover dropped  [X] Clean up  [m] Rename  [e] Export patch  [c] Compare  [y] Copy diff  [Y] Copy hunk  [Ctrl+y] Copy apply cmd  ['] Jump  [O] Overview  [T] Tree  [i] Inspect  [S] Shared  [Ctrl+f] This branch  [Ctrl+p] Find by path  [z] Group  [o] Sort  [*] Pin  [R] Refresh  [B] Browser  [E] Open in editor  [Tab] Build Mode  [Shift+Tab] Focus  [Alt+j/k] Scroll diff  [Ctrl+s] Stash all  [H] Full SHAs  [Ctrl+g] Raw list  [F1] Git cheatsheet  [Ctrl+h] Hide help  [q] Quit"
+	case m.appState == StateHunkSelect:
+		return "[↑/↓] Move  [Space] Pick hunk  [Enter] Stage picked & stash index  [Esc] Cancel"
+	}
+	// Both stash the index alone, which older git can't do
+	stagedHint := ""
+	if m.stagedStash {
+		stagedHint = "  [S] Save staged  [h] Hunks"
+	}
+	return fmt.Sprintf("[Enter] Select  [Space] Expand/Collapse  [s] Save (%s selected)%s  [g] Stage/unstage  [x] Reviewed  [*] Glob select  [A] Select all  [N] Select none  [X] Start over  [d] Diff tool  [o] Sort  [z] Tree  [v] Preview/selected  [u] Unified patch  [F] Full diffs  [+/-] Context  [W] Whitespace  [Y] Copy hunk  [B] Browser  [r] Restore  [R] Refresh  [Tab] Explore Mode  [Shift+Tab] Focus  [Ctrl+s] Stash all  [F1] Git cheatsheet  [Ctrl+h] Hide help  [q] Quit", m.selectionTotal(), stagedHint)
+}
+
+// helpHeader is the key help above a diff pane, unless hidden with ctrl+h. It's wrapped
+// to the pane's width, and cut short with … past a third of the pane's height so a
+// narrow pane still has room for the diff.
+func (m model) helpHeader() string {
+	if !m.showHeader {
+		return ""
+	}
+	width := max(m.viewport.Width, 1)
+	lines := strings.Split(ansi.Wrap(m.helpText(), width, ""), "\n")
+	if limit := max((m.height-borderStyle.GetVerticalFrameSize())/3, 1); len(lines) > limit {
+		lines = lines[:limit]
+		lines[limit-1] = ansi.Truncate(strings.TrimRight(lines[limit-1], " "), width-1, "") + "…"
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return titleStyle.Render(strings.Join(lines, "\n")) + "\n"
+}
+
+// helpHeaderHeight is how many lines the help header takes
+func (m model) helpHeaderHeight() int {
+	return lipgloss.Height(m.helpHeader()) - 1
+}
+
+// paneStyle highlights the border of the pane that has focus
+func (m model) paneStyle(p Pane) lipgloss.Style {
+	if m.focus == p || p == PaneDiff && m.focus == PaneFiles {
+		return focusedBorderStyle
+	}
+	return borderStyle
+}
+
+// paneBorders are the packrat.border choices
+var paneBorders = map[string]lipgloss.Border{
+	"normal":  lipgloss.NormalBorder(),
+	"rounded": lipgloss.RoundedBorder(),
+	"thick":   lipgloss.ThickBorder(),
+	"none":    {},
+}
+
+// applyStyleConfig rebuilds the pane styles from packrat.border and packrat.padding.
+// It must run before the model is built, since viewports copy their style.
+func applyStyleConfig() {
+	border := paneBorders[cfg.Border]
+	style := lipgloss.NewStyle().Padding(cfg.Padding)
+	if cfg.Border != "none" {
+		style = style.Border(border)
+	}
+	borderStyle = style
+	viewportStyle = style
+	focusedBorderStyle = borderStyle.BorderForeground(lipgloss.Color("36"))
+}
+
 func (m model) View() string {
 	if m.err != nil {
 		return fmt.Sprintf("Error: %v\n", m.err)
@@ -745,28 +5040,66 @@ func (m model) View() string {
 		return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, modal)
 	}
 
+	if m.mode == ModeExplore && m.appState == StateOverview {
+		leftPane := borderStyle.Render(m.overviewList.View())
+		rightPane := borderStyle.Render(m.helpHeader() + statusStyle.Render(m.status) + "\n" + m.overviewView())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
+	if m.mode == ModeExplore && m.appState == StateStashTree {
+		leftPane := m.paneStyle(PaneList).Render(m.treeList.View())
+		rightPane := m.paneStyle(PaneDiff).Render(m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.stashMetaView() + m.stashFilesView() + m.viewport.View())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
+	if m.mode == ModeExplore && m.appState == StateInspect {
+		leftPane := m.paneStyle(PaneList).Render(m.inspectList.View())
+		rightPane := m.paneStyle(PaneDiff).Render(m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.stashMetaView() + m.stashFilesView() + m.viewport.View())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
+	if m.mode == ModeExplore && m.appState == StateCleanUp {
+		leftPane := m.paneStyle(PaneList).Render(m.cleanupList.View())
+		rightPane := m.paneStyle(PaneDiff).Render(m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.viewport.View())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
+	if m.mode == ModeExplore && m.appState == StateRecover {
+		leftPane := m.paneStyle(PaneList).Render(m.recoverList.View())
+		rightPane := m.paneStyle(PaneDiff).Render(m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.viewport.View())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
+	if m.mode == ModeExplore && m.appState == StatePartialApply {
+		leftPane := m.paneStyle(PaneList).Render(m.partialList.View())
+		rightPane := m.paneStyle(PaneDiff).Render(m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.stashMetaView() + m.stashFilesView() + m.viewport.View())
+		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
+	}
+
 	if m.mode == ModeExplore {
 		// Explore Mode view
-		leftPane := borderStyle.Render(m.stashList.View())
+		leftPane := m.paneStyle(PaneList).Render(m.stashList.View())
 
-		header := titleStyle.Render("[Enter] Show stash  [a] Apply  [d] Drop  [Tab] Build Mode  [q] Quit  [↑/↓] Scroll")
 		viewportContent := m.viewport.View()
+		if m.exploreEmpty() {
+			viewportContent = emptyStateView(m.viewport, m.emptyStashesView())
+		}
 
-		rightContent := header + "\n\n" + viewportContent
-		rightPane := borderStyle.Render(rightContent)
+		rightContent := m.helpHeader() + m.statusLine(m.viewport) + "\n" + m.stashMetaView() + m.stashFilesView() + viewportContent
+		rightPane := m.paneStyle(PaneDiff).Render(rightContent)
 
 		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
 	} else {
 		// Build Mode view
-		leftPane := borderStyle.Render(m.fileList.View())
+		leftPane := m.paneStyle(PaneList).Render(m.fileList.View())
 
-		selectedCount := len(m.selectedFiles)
-		helpText := fmt.Sprintf("[Enter] Select  [Space] Expand/Collapse  [s] Save (%d)  [r] Restore  [Tab] Explore Mode  [q] Quit", selectedCount)
-		header := titleStyle.Render(helpText)
 		viewportContent := m.buildViewport.View()
+		if m.buildEmpty() {
+			viewportContent = emptyStateView(m.buildViewport, cleanTreeView())
+		}
 
-		rightContent := header + "\n\n" + viewportContent
-		rightPane := borderStyle.Render(rightContent)
+		rightContent := m.helpHeader() + m.statusLine(m.buildViewport) + "\n" + m.operationBanner() + viewportContent
+		rightPane := m.paneStyle(PaneDiff).Render(rightContent)
 
 		return lipgloss.JoinHorizontal(lipgloss.Top, leftPane, rightPane)
 	}
@@ -776,8 +5109,34 @@ func (m model) View() string {
 // Main
 // ---------------------------------------------------------------------------
 func main() {
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	printRef := flag.Bool("print-ref", false, "print the SHA of each stash created before exiting")
+	fromStdin := flag.Bool("from-stdin", false, "read stashes and diffs from stdin instead of git (see README)")
+	flag.Parse()
+
+	cfg = loadConfig()
+	applyStyleConfig()
+
+	opts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithOutput(output)}
+	if *fromStdin {
+		fixture, err := readFixture(os.Stdin)
+		if err != nil {
+			log.Fatalf("reading stdin: %v", err)
+		}
+		source = fixture
+		// Stdin holds the fixture, so keystrokes have to come from the terminal itself
+		opts = append(opts, tea.WithInputTTY())
+	}
+
+	p := tea.NewProgram(initialModel(), opts...)
+	final, err := p.Run()
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Printed after the TUI exits so wrapper scripts can capture it from stdout
+	if *printRef {
+		for _, sha := range final.(model).createdShas {
+			fmt.Println(sha)
+		}
+	}
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// testModel is a model with the default config, sized like a small terminal. Nothing
+// the Cmds it returns would run is executed.
+func testModel(t *testing.T) model {
+	t.Helper()
+	cfg = parseConfig(nil)
+	m := initialModel()
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	return updated.(model)
+}
+
+func TestUnquotePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"with space.txt", "with space.txt"},
+		{"-rf", "-rf"},
+		{`"docs/\303\244.md"`, "docs/ä.md"},
+		{`"tab\there.txt"`, "tab\there.txt"},
+		{`"say \"hi\".txt"`, `say "hi".txt`},
+		{`"back\\slash"`, `back\slash`},
+		{`"new\nline"`, "new\nline"},
+		{`"unterminated`, `"unterminated`},
+		{`"bad \q escape"`, `"bad \q escape"`},
+	}
+	for _, tt := range tests {
+		if got := unquotePath(tt.in); got != tt.want {
+			t.Errorf("unquotePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatusUnusualNames(t *testing.T) {
+	// -z output isn't quoted, so each name comes through byte for byte
+	names := []string{"with space.txt", "tab\there.txt", "docs/ä.md", "-rf", `quote".txt`}
+	var out strings.Builder
+	for _, name := range names {
+		out.WriteString(" M " + name + "\x00")
+	}
+	out.WriteString("?? -untracked dash\x00")
+
+	files := parseStatus(out.String())
+	if len(files) != len(names)+1 {
+		t.Fatalf("parseStatus() gave %d entries, want %d: %+v", len(files), len(names)+1, files)
+	}
+	for i, name := range names {
+		if f := files[i]; f.Path != name || f.IsStaged || f.Status != "M" {
+			t.Errorf("entry %d = %+v, want unstaged M %q", i, f, name)
+		}
+	}
+	if f := files[len(names)]; f.Path != "-untracked dash" || !f.IsUntracked {
+		t.Errorf("untracked entry = %+v", f)
+	}
+
+	// A leading dash can't be taken for an option once it's past the --
+	args := stashPushArgs(files, "msg", ScopeSelection, true, nil)
+	sep := slices.Index(args, "--")
+	if sep < 0 || !slices.Contains(args[sep+1:], "-rf") || slices.Contains(args[:sep], "-rf") {
+		t.Errorf("stashPushArgs() = %q, want -rf after --", args)
+	}
+}
+
+func TestParseStatusStagedRename(t *testing.T) {
+	files := parseStatus("R  new.go\x00old.go\x00")
+	if len(files) != 1 {
+		t.Fatalf("parseStatus() gave %d entries, want 1: %+v", len(files), files)
+	}
+	f := files[0]
+	if f.Path != "new.go" || f.OldPath != "old.go" || f.Status != "R" || !f.IsStaged {
+		t.Errorf("entry = %+v, want staged rename old.go → new.go", f)
+	}
+	if got := f.pathspec(); !slices.Equal(got, []string{"old.go", "new.go"}) {
+		t.Errorf("pathspec() = %q, want both paths", got)
+	}
+	args := stashPushArgs(files, "msg", ScopeSelection, false, nil)
+	sep := slices.Index(args, "--")
+	if sep < 0 || !slices.Equal(args[sep+1:], []string{"new.go"}) {
+		t.Errorf("stashPushArgs() = %q, want only new.go after --", args)
+	}
+}
+
+func TestStashStagedRename(t *testing.T) {
+	testRepo(t)
+	if out, err := exec.Command("git", "mv", "a.txt", "b.txt").CombinedOutput(); err != nil {
+		t.Fatalf("git mv: %v\n%s", err, out)
+	}
+	if err := os.WriteFile("other.txt", []byte("untracked\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := listChangedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rename FileChange
+	for _, f := range files {
+		if f.Status == "R" {
+			rename = f
+		}
+	}
+	if rename.OldPath != "a.txt" || rename.Path != "b.txt" {
+		t.Fatalf("no staged rename a.txt → b.txt among %+v", files)
+	}
+
+	if got := renameCleanupArgs([]FileChange{rename}, ScopeSelection, []string{"--keep-index"}); got != nil {
+		t.Errorf("renameCleanupArgs() = %q with --keep-index, want nil", got)
+	}
+	if got := renameCleanupArgs([]FileChange{rename}, ScopeAll, nil); got != nil {
+		t.Errorf("renameCleanupArgs() = %q for all changes, want nil", got)
+	}
+
+	msg := createStash([]FileChange{rename}, "rename", ScopeSelection, false, false, nil)().(stashCreatedMsg)
+	if msg.err != nil {
+		t.Fatalf("createStash() failed: %v\n%s", msg.err, msg.output)
+	}
+	status, _ := exec.Command("git", "status", "--porcelain").Output()
+	if got := string(status); got != "?? other.txt\n" {
+		t.Errorf("status after stashing the rename:\n%s\nwant only the untracked file left", got)
+	}
+
+	if out, err := exec.Command("git", "stash", "pop", "--index").CombinedOutput(); err != nil {
+		t.Fatalf("git stash pop --index: %v\n%s", err, out)
+	}
+	status, _ = exec.Command("git", "status", "--porcelain").Output()
+	if got := string(status); got != "R  a.txt -> b.txt\n?? other.txt\n" {
+		t.Errorf("status after popping the stash:\n%s\nwant the staged rename back", got)
+	}
+}
+
+// testRepo makes a repository with a commit and the given stashes, the last one
+// stash@{0}, and changes into it for the rest of the test
+func testRepo(t *testing.T, stashes ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Chdir(dir)
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+	write("base\n")
+	git("add", "a.txt")
+	git("commit", "-qm", "base")
+	for _, message := range stashes {
+		write(message + "\n")
+		git("stash", "push", "-qm", message)
+	}
+}
+
+// stashShas lists the stashes' SHAs, stash@{0} first
+func stashShas(t *testing.T) []string {
+	t.Helper()
+	out, err := exec.Command("git", "stash", "list", "--format=%H").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return splitLines(string(out))
+}
+
+func TestBranchFromStashChecksSha(t *testing.T) {
+	testRepo(t, "older", "newer")
+	before := stashShas(t)
+
+	// The list was loaded when stash@{0} was the older stash
+	msg := branchFromStash("from-older", "stash@{0}", before[1])().(stashBranchedMsg)
+	var stale staleStashError
+	if !errors.As(msg.err, &stale) {
+		t.Fatalf("err = %v, want a staleStashError", msg.err)
+	}
+	if after := stashShas(t); !slices.Equal(after, before) {
+		t.Errorf("stashes changed from %q to %q", before, after)
+	}
+
+	msg = branchFromStash("from-newer", "stash@{0}", before[0])().(stashBranchedMsg)
+	if msg.err != nil {
+		t.Fatalf("err = %v\n%s", msg.err, msg.output)
+	}
+	if after := stashShas(t); !slices.Equal(after, before[1:]) {
+		t.Errorf("stashes are %q after branching from stash@{0}, want %q", after, before[1:])
+	}
+}
+
+func TestHelpHeaderFitsTerminal(t *testing.T) {
+	for _, size := range []tea.WindowSizeMsg{{Width: 120, Height: 40}, {Width: 100, Height: 30}} {
+		for _, mode := range []Mode{ModeExplore, ModeBuild} {
+			t.Run(fmt.Sprintf("%dx%d mode %v", size.Width, size.Height, mode), func(t *testing.T) {
+				m := testModel(t)
+				updated, _ := m.Update(size)
+				m = updated.(model)
+				m.mode = mode
+				updated, _ = m.Update(changedFilesMsg{})
+				m = updated.(model)
+				if m.headerHeight < 2 {
+					t.Errorf("headerHeight = %d, want the help wrapped over several lines", m.headerHeight)
+				}
+
+				view := m.View()
+				if h := lipgloss.Height(view); h != size.Height {
+					t.Errorf("View() is %d lines tall, want %d", h, size.Height)
+				}
+				if w := lipgloss.Width(view); w > size.Width {
+					t.Errorf("View() is %d columns wide, want at most %d", w, size.Width)
+				}
+			})
+		}
+	}
+}
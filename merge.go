@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Three-Way Merge Preview
+// ---------------------------------------------------------------------------
+
+// mergeContext is how many unchanged lines are kept around each conflict region
+const mergeContext = 3
+
+var (
+	conflictMarkerStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("3"))
+	oursLineStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("4"))
+	theirsLineStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	baseLineStyle       = lipgloss.NewStyle().Faint(true)
+)
+
+// mergePreview is the result of merging one file of a stash into the working tree
+type mergePreview struct {
+	Path      string
+	Merged    string // merge-file output, with diff3-style conflict markers
+	Conflicts int
+	Err       error
+}
+
+type mergePreviewMsg struct {
+	ref   string
+	files []mergePreview
+}
+
+// previewMerge runs a three-way merge of each path (stash base, working tree, stash)
+// with `git merge-file` on temporary copies, leaving the working tree alone
+func previewMerge(ref string, paths []string) tea.Cmd {
+	return func() tea.Msg {
+		files := make([]mergePreview, 0, len(paths))
+		for _, path := range paths {
+			files = append(files, mergeFile(ref, path))
+		}
+		return mergePreviewMsg{ref: ref, files: files}
+	}
+}
+
+func mergeFile(ref, path string) mergePreview {
+	result := mergePreview{Path: path}
+
+	theirs, err := exec.Command("git", "show", ref+":"+path).Output()
+	if err != nil {
+		result.Err = fmt.Errorf("not a tracked file in %s", ref)
+		return result
+	}
+	// A file the stash added has no base version; merging against empty shows it whole
+	base, _ := exec.Command("git", "show", ref+"^1:"+path).Output()
+	current, err := os.ReadFile(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	dir, err := os.MkdirTemp("", "packrat-merge-*")
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{dir + "/current", dir + "/base", dir + "/stash"}
+	for i, content := range [][]byte{current, base, theirs} {
+		if err := os.WriteFile(names[i], content, 0o600); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	cmd := exec.Command("git", "merge-file", "-p", "--diff3",
+		"-L", "working tree", "-L", "base", "-L", ref,
+		names[0], names[1], names[2])
+	out, err := cmd.Output()
+	result.Merged = string(out)
+
+	// merge-file exits with the number of conflicts, and only negative codes are errors
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		result.Conflicts = exitErr.ExitCode()
+	} else if err != nil {
+		result.Err = err
+	}
+	return result
+}
+
+// mergePreviewView renders the conflict regions of each merged file with a little
+// context, coloring the working tree, base and stash sides differently
+func mergePreviewView(msg mergePreviewMsg) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Three-way merge preview of %s (nothing has been changed)\n", msg.ref))
+	b.WriteString(oursLineStyle.Render("working tree") + "  " + baseLineStyle.Render("base") + "  " + theirsLineStyle.Render("stash") + "\n")
+
+	for _, f := range msg.files {
+		b.WriteString("\n" + titleStyle.Render(f.Path))
+		switch {
+		case f.Err != nil:
+			b.WriteString(fmt.Sprintf(" — couldn't merge: %v\n", f.Err))
+			continue
+		case f.Conflicts == 0:
+			b.WriteString(" — merges cleanly\n")
+			continue
+		}
+		b.WriteString(fmt.Sprintf(" — %d conflict(s)\n", f.Conflicts))
+		b.WriteString(renderConflictRegions(f.Merged))
+	}
+	return b.String()
+}
+
+// renderConflictRegions colors merge-file output and folds long stretches of lines
+// outside any conflict
+func renderConflictRegions(merged string) string {
+	lines := strings.Split(strings.TrimRight(merged, "\n"), "\n")
+
+	// First pass: style each line and note which ones belong to a conflict
+	styled := make([]string, len(lines))
+	inConflict := make([]bool, len(lines))
+	side := ""
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<<"):
+			side = "ours"
+			styled[i] = conflictMarkerStyle.Render(line)
+		case strings.HasPrefix(line, "|||||||") && side != "":
+			side = "base"
+			styled[i] = conflictMarkerStyle.Render(line)
+		case strings.HasPrefix(line, "=======") && side != "":
+			side = "theirs"
+			styled[i] = conflictMarkerStyle.Render(line)
+		case strings.HasPrefix(line, ">>>>>>>") && side != "":
+			side = ""
+			styled[i] = conflictMarkerStyle.Render(line)
+			inConflict[i] = true
+			continue
+		case side == "ours":
+			styled[i] = oursLineStyle.Render(line)
+		case side == "base":
+			styled[i] = baseLineStyle.Render(line)
+		case side == "theirs":
+			styled[i] = theirsLineStyle.Render(line)
+		default:
+			styled[i] = line
+		}
+		inConflict[i] = side != ""
+	}
+
+	// Second pass: keep conflicts plus mergeContext lines either side
+	var b strings.Builder
+	skipped := 0
+	for i := range lines {
+		near := false
+		for j := max(i-mergeContext, 0); j <= min(i+mergeContext, len(lines)-1); j++ {
+			if inConflict[j] {
+				near = true
+				break
+			}
+		}
+		if !near {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			b.WriteString(statusStyle.Render(fmt.Sprintf("  ⋯ %d unchanged lines", skipped)) + "\n")
+			skipped = 0
+		}
+		b.WriteString(styled[i] + "\n")
+	}
+	if skipped > 0 {
+		b.WriteString(statusStyle.Render(fmt.Sprintf("  ⋯ %d unchanged lines", skipped)) + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Stash Metadata
+// ---------------------------------------------------------------------------
+
+// stashMetaHeight is how many lines the metadata block takes above the diff
+const stashMetaHeight = 4
+
+// stashMeta describes the commit behind a stash, shown above its diff
+type stashMeta struct {
+	Ref, Sha, Author string
+	Date             time.Time
+	Base             string // the commit the stash was taken on, abbreviated, with its subject
+}
+
+type stashMetaMsg struct {
+	meta stashMeta
+	err  error
+}
+
+// getStashMeta loads a stash's metadata separately from its diff, so a big diff doesn't
+// hold the header up
+func getStashMeta(ref string) tea.Cmd {
+	return func() tea.Msg {
+		meta := stashMeta{Ref: ref}
+		out, err := exec.Command("git", "show", "-s", "--format=%H%x00%an%x00%aI", ref).Output()
+		if err != nil {
+			return stashMetaMsg{meta: meta, err: err}
+		}
+		fields := strings.Split(strings.TrimSpace(string(out)), "\x00")
+		if len(fields) != 3 {
+			return stashMetaMsg{meta: meta, err: fmt.Errorf("unexpected git show output %q", out)}
+		}
+		meta.Sha, meta.Author = fields[0], fields[1]
+		meta.Date, _ = time.Parse(time.RFC3339, fields[2])
+
+		base, err := exec.Command("git", "show", "-s", "--format=%h %s", ref+"^1").Output()
+		if err == nil {
+			meta.Base = strings.TrimSpace(string(base))
+		}
+		return stashMetaMsg{meta: meta}
+	}
+}
+
+// fetchStashMeta requests metadata for ref unless it's already showing. Replies for
+// any other ref are stale and get dropped.
+func (m *model) fetchStashMeta(ref string) tea.Cmd {
+	if !source.Live() || m.metaRef == ref {
+		return nil
+	}
+	m.metaRef = ref
+	return getStashMeta(ref)
+}
+
+// stashMetaView renders the metadata block, or nothing until it has loaded
+func (m model) stashMetaView() string {
+	meta := m.stashMeta
+	if meta.Sha == "" {
+		return ""
+	}
+	branch := "an unknown branch"
+	if s, ok := m.stashByRef(meta.Ref); ok && s.Branch != "" {
+		branch = s.Branch
+	}
+	lines := []string{
+		titleStyle.Render(meta.Sha) + fmt.Sprintf("  on %s  by %s", branch, meta.Author),
+		statusStyle.Render("Based on " + meta.Base),
+		statusStyle.Render(fmt.Sprintf("Created %s (%s)", meta.Date.Format("2006-01-02 15:04:05 -0700"), relativeTime(meta.Date, time.Now()))),
+	}
+	for i, line := range lines {
+		// A wrapped line would push the diff down past the bottom of the pane
+		lines[i] = ansi.Truncate(line, m.viewport.Width, "…")
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
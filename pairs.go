@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Partly Staged Files
+// ---------------------------------------------------------------------------
+
+// fetchPairDiff is fetchFileDiff for a partly staged file: the staged diff and then the
+// unstaged one, each under a heading, since stashing the path takes both. The message
+// keeps file's own side, which is what the diff is cached under.
+func fetchPairDiff(file FileChange, full bool, opts diffOptions) fileDiffMsg {
+	msg := fileDiffMsg{path: file.Path, staged: file.IsStaged, opts: opts}
+	var diff strings.Builder
+	for _, staged := range []bool{true, false} {
+		side := file
+		side.IsStaged, side.PartlyStaged = staged, false
+		heading := "Staged (git diff --cached)"
+		if !staged {
+			heading = "Unstaged (git diff)"
+			side.OldPath = "" // a staged rename is done with by the working tree side
+		}
+		part := fileDiff(side, full, opts)
+		if part.err != nil {
+			msg.diff, msg.err = part.diff, part.err
+			return msg
+		}
+		msg.summarized = msg.summarized || part.summarized
+		diff.WriteString(statusStyle.Render("── "+heading+" ──") + "\n" + part.diff)
+		if !strings.HasSuffix(part.diff, "\n") {
+			diff.WriteString("\n")
+		}
+	}
+	msg.diff = diff.String()
+	return msg
+}
+
+// partlyStagedSelected lists the selected paths that are partly staged
+func (m model) partlyStagedSelected() []string {
+	var paths []string
+	for path, f := range m.selectedFiles {
+		if f.PartlyStaged {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// partlyStagedNote is the stash modal's reminder that a partly staged file is stashed
+// whole, or "" when none are selected
+func (m model) partlyStagedNote() string {
+	paths := m.partlyStagedSelected()
+	if len(paths) == 0 || m.stashScope != ScopeSelection {
+		return ""
+	}
+	return statusStyle.Render(fmt.Sprintf("%d file(s) have staged and unstaged changes; the stash takes both.", len(paths))) + "\n\n"
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Partial Apply
+// ---------------------------------------------------------------------------
+
+// partialFile is a file of a stash in the partial apply picker
+type partialFile struct {
+	treeFile
+	Picked bool
+}
+
+func (f partialFile) Title() string {
+	box := "[ ]"
+	if f.Picked {
+		box = "[x]"
+	}
+	return fmt.Sprintf("%s %s %s", box, f.Status, f.Path)
+}
+func (f partialFile) Description() string { return "" }
+func (f partialFile) FilterValue() string { return f.Path }
+
+type partialFilesMsg struct {
+	ref   string
+	files []treeFile
+	err   error
+}
+
+type partialAppliedMsg struct {
+	ref      string
+	written  []string // paths the working tree now has the stash's changes for
+	skipped  []string // paths checkout can't restore because the stash deletes them
+	checkout bool     // files were overwritten with the stash's versions rather than patched
+	output   string
+	err      error
+}
+
+// localChangesMsg reports which picked files have uncommitted changes a checkout
+// would overwrite
+type localChangesMsg struct {
+	ref   string
+	files []treeFile
+	dirty []string
+	err   error
+}
+
+// getPartialFiles lists the tracked files a stash changes. Renames are listed as a
+// deletion and an addition, so each path can be picked on its own.
+func getPartialFiles(ref string) tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "stash", "show", "--name-status", "--no-renames", ref).Output()
+		if err != nil {
+			return partialFilesMsg{ref: ref, err: err}
+		}
+		var files []treeFile
+		for _, line := range splitLines(string(out)) {
+			status, path, ok := strings.Cut(line, "\t")
+			if ok && status != "" {
+				files = append(files, treeFile{Path: unquotePath(path), Status: status[:1]})
+			}
+		}
+		return partialFilesMsg{ref: ref, files: files}
+	}
+}
+
+// findLocalChanges checks the picked files for staged, unstaged or untracked changes
+func findLocalChanges(ref string, files []treeFile) tea.Cmd {
+	return func() tea.Msg {
+		msg := localChangesMsg{ref: ref, files: files}
+		args := append([]string{"status", "--porcelain", "-z", "--no-renames", "--"}, treeFilePaths(files)...)
+		out, err := exec.Command("git", args...).Output()
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		for _, entry := range strings.Split(string(out), "\x00") {
+			if len(entry) > 3 {
+				msg.dirty = append(msg.dirty, entry[3:])
+			}
+		}
+		return msg
+	}
+}
+
+// applyStashFiles brings the picked files back from a stash, which stays in the list.
+// By default it applies just their part of the stash's patch, so local changes survive
+// or the apply fails as a whole; with checkout it overwrites them with the stash's
+// versions, as `git checkout <stash> -- <paths>` does.
+func applyStashFiles(ref string, files []treeFile, checkout bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := partialAppliedMsg{ref: ref, checkout: checkout}
+		if checkout {
+			var paths []string
+			for _, f := range files {
+				if f.Status == "D" {
+					msg.skipped = append(msg.skipped, f.Path)
+				} else {
+					paths = append(paths, f.Path)
+				}
+			}
+			if len(paths) == 0 {
+				return msg
+			}
+			out, err := exec.Command("git", append([]string{"checkout", ref, "--"}, paths...)...).CombinedOutput()
+			msg.output, msg.err = string(out), err
+			if err == nil {
+				msg.written = paths
+			}
+			return msg
+		}
+
+		paths := treeFilePaths(files)
+		diffArgs := append([]string{"diff", "--binary", "--no-color", ref + "^1", ref, "--"}, paths...)
+		patch, err := exec.Command("git", diffArgs...).Output()
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		apply := exec.Command("git", "apply", "-")
+		apply.Stdin = bytes.NewReader(patch)
+		out, err := apply.CombinedOutput()
+		msg.output, msg.err = string(out), err
+		if err == nil {
+			msg.written = paths
+		}
+		return msg
+	}
+}
+
+func treeFilePaths(files []treeFile) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+// pickedPartialFiles are the files picked with space, in list order
+func (m model) pickedPartialFiles() []treeFile {
+	var picked []treeFile
+	for _, item := range m.partialList.Items() {
+		if f, ok := item.(partialFile); ok && f.Picked {
+			picked = append(picked, f.treeFile)
+		}
+	}
+	return picked
+}
+
+// updatePartialApply handles keys while picking files to apply from a stash
+func (m model) updatePartialApply(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.appState = StateExplore
+		return m, nil
+	case " ": // Pick or unpick the file
+		if f, ok := m.partialList.SelectedItem().(partialFile); ok {
+			f.Picked = !f.Picked
+			m.partialList.SetItem(m.partialList.GlobalIndex(), f)
+		}
+		return m, nil
+	case "enter", "o": // Apply the picked files as a patch, or overwrite them
+		picked := m.pickedPartialFiles()
+		if len(picked) == 0 {
+			m.status = "No files picked; press Space to pick some"
+			return m, nil
+		}
+		m.loading = true
+		if msg.String() == "o" {
+			return m, findLocalChanges(m.partialRef, picked)
+		}
+		return m, applyStashFiles(m.partialRef, picked, false)
+	}
+
+	var cmd tea.Cmd
+	if m.focus == PaneDiff {
+		m.viewport, cmd = m.viewport.Update(msg)
+	} else {
+		m.partialList, cmd = m.partialList.Update(msg)
+	}
+	return m, cmd
+}
+
+// partialAppliedView reports what a partial apply wrote
+func partialAppliedView(msg partialAppliedMsg) string {
+	var b strings.Builder
+	if msg.err != nil {
+		b.WriteString(fmt.Sprintf("Error applying files from %s, nothing was written:\n\n%s\n", msg.ref, strings.TrimSpace(msg.output)))
+		if msg.output == "" {
+			b.WriteString(msg.err.Error() + "\n")
+		}
+	} else {
+		verb := "Applied"
+		if msg.checkout {
+			verb = "Checked out"
+		}
+		b.WriteString(fmt.Sprintf("%s %d file(s) from %s:\n\n", verb, len(msg.written), msg.ref))
+		for _, path := range msg.written {
+			b.WriteString("  " + path + "\n")
+		}
+	}
+	if len(msg.skipped) > 0 {
+		b.WriteString("\nSkipped, since the stash deletes them (press Enter in the picker to apply the deletion):\n")
+		for _, path := range msg.skipped {
+			b.WriteString("  " + path + "\n")
+		}
+	}
+	b.WriteString(fmt.Sprintf("\n%s is kept in the stash list.", msg.ref))
+	return b.String()
+}
+
+// newPartialList is the picker's list, one line per file like the stash tree
+func newPartialList() list.Model {
+	delegate := list.NewDefaultDelegate()
+	delegate.ShowDescription = false
+	delegate.SetSpacing(0)
+	l := list.New([]list.Item{}, delegate, 30, 10)
+	l.Title = "Packrat - Partial Apply"
+	return l
+}
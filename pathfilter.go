@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Path Filter
+// ---------------------------------------------------------------------------
+
+// trackedFilesMsg feeds the path filter's completion
+type trackedFilesMsg struct {
+	paths []string
+}
+
+// stashPathsMsg lists the files one stash touches, for the path filter search numbered seq
+type stashPathsMsg struct {
+	seq   int
+	sha   string
+	paths []string
+	err   error
+}
+
+func getTrackedFiles() tea.Cmd {
+	return func() tea.Msg {
+		out, _ := exec.Command("git", "ls-files").Output()
+		return trackedFilesMsg{paths: splitPaths(string(out))}
+	}
+}
+
+// getStashPaths lists a stash's files, untracked ones included where git can show them
+func getStashPaths(seq int, s Stash) tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "stash", "show", "--name-only", "-u", s.Ref).CombinedOutput()
+		if err != nil && untrackedUnsupported(string(out)) {
+			out, err = exec.Command("git", "stash", "show", "--name-only", s.Ref).CombinedOutput()
+		}
+		if err != nil {
+			return stashPathsMsg{seq: seq, sha: s.Sha, err: fmt.Errorf("%s: %s", s.Ref, strings.TrimSpace(string(out)))}
+		}
+		return stashPathsMsg{seq: seq, sha: s.Sha, paths: splitPaths(string(out))}
+	}
+}
+
+// searchStashPaths looks through every stash for files whose path contains pattern,
+// one git process per stash, all at once
+func (m *model) searchStashPaths(pattern string) tea.Cmd {
+	m.pathSearchSeq++
+	m.pathFilter = pattern
+	m.pathHits = make(map[string]bool)
+	m.pathSearchPending = len(m.stashes)
+	if len(m.stashes) == 0 {
+		m.setStashItems(m.stashes)
+		return nil
+	}
+	m.loading = true
+	m.status = fmt.Sprintf("Looking for %q in %d stash(es)...", pattern, len(m.stashes))
+	cmds := make([]tea.Cmd, len(m.stashes))
+	for i, s := range m.stashes {
+		cmds[i] = getStashPaths(m.pathSearchSeq, s)
+	}
+	return tea.Batch(cmds...)
+}
+
+// gotStashPaths counts a stash's answer in, and filters the list once they're all in
+func (m *model) gotStashPaths(msg stashPathsMsg) {
+	if msg.seq != m.pathSearchSeq {
+		return
+	}
+	for _, p := range msg.paths {
+		if strings.Contains(p, m.pathFilter) {
+			m.pathHits[msg.sha] = true
+			break
+		}
+	}
+	if msg.err != nil {
+		m.pathSearchErrs = append(m.pathSearchErrs, msg.err.Error())
+	}
+	if m.pathSearchPending--; m.pathSearchPending > 0 {
+		return
+	}
+	m.loading = false
+	m.setStashItems(m.stashes)
+	m.status = fmt.Sprintf("%d stash(es) touch %q; Esc shows them all", len(m.pathHits), m.pathFilter)
+	if len(m.pathSearchErrs) > 0 {
+		m.status += fmt.Sprintf(" (couldn't read %s)", strings.Join(m.pathSearchErrs, "; "))
+		m.pathSearchErrs = nil
+	}
+}
+
+// clearPathFilter lists every stash again
+func (m *model) clearPathFilter() {
+	m.pathSearchSeq++
+	m.pathFilter = ""
+	m.pathHits = nil
+	m.pathSearchErrs = nil
+	m.setStashItems(m.stashes)
+	m.status = "Showing all stashes"
+}
+
+// updatePathFilterModal handles keys while the path is typed. Tab takes the suggested
+// completion.
+func (m model) updatePathFilterModal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		pattern := strings.TrimSpace(m.pathInput.Value())
+		if pattern == "" {
+			return m, nil
+		}
+		m.activeModal = ModalNone
+		m.pathInput.SetValue("")
+		return m, m.searchStashPaths(pattern)
+	case "esc":
+		m.activeModal = ModalNone
+		m.pathInput.SetValue("")
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.pathInput, cmd = m.pathInput.Update(msg)
+	return m, cmd
+}
+
+func (m model) pathFilterModalView() string {
+	var b strings.Builder
+	b.WriteString("Find stashes that touch a file\n\n")
+	b.WriteString(m.pathInput.View() + "\n\n")
+	b.WriteString(statusStyle.Render("Any part of a path matches, e.g. database.yml or config/. Tab completes tracked files.") + "\n\n")
+	b.WriteString("[Enter] Search   [Esc] Cancel")
+	return modalStyle.Render(b.String())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Pinned Stashes
+// ---------------------------------------------------------------------------
+
+// Pins are kept by SHA in the repository's packrat.pinned, so they follow a stash as
+// its index changes
+
+type pinSavedMsg struct {
+	err error
+}
+
+// savePins adds and removes SHAs from packrat.pinned
+func savePins(add, remove []string) tea.Cmd {
+	return func() tea.Msg {
+		for _, sha := range add {
+			if out, err := exec.Command("git", "config", "--add", "packrat.pinned", sha).CombinedOutput(); err != nil {
+				return pinSavedMsg{err: fmt.Errorf("%v: %s", err, out)}
+			}
+		}
+		for _, sha := range remove {
+			pattern := "^" + regexp.QuoteMeta(sha) + "$"
+			if out, err := exec.Command("git", "config", "--unset-all", "packrat.pinned", pattern).CombinedOutput(); err != nil {
+				return pinSavedMsg{err: fmt.Errorf("%v: %s", err, out)}
+			}
+		}
+		return pinSavedMsg{}
+	}
+}
+
+// togglePin pins the stash, or unpins it, and keeps it selected as it moves
+func (m *model) togglePin(s Stash) tea.Cmd {
+	var add, remove []string
+	if m.pinned[s.Sha] {
+		delete(m.pinned, s.Sha)
+		remove = append(remove, s.Sha)
+		m.status = "Unpinned " + s.Ref
+	} else {
+		m.pinned[s.Sha] = true
+		add = append(add, s.Sha)
+		m.status = "Pinned " + s.Ref
+	}
+	m.setStashItems(m.stashes)
+	m.selectStashWhere(func(other Stash) bool { return other.Sha == s.Sha })
+	return savePins(add, remove)
+}
+
+// forgetGonePins drops the pins of stashes that aren't in the list anymore, such as
+// ones that were dropped or popped
+func (m *model) forgetGonePins(listed map[string]bool) tea.Cmd {
+	var gone []string
+	for sha := range m.pinned {
+		if !listed[sha] {
+			gone = append(gone, sha)
+			delete(m.pinned, sha)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	return savePins(nil, gone)
+}
+
+// pinnedFirst moves pinned stashes to the top, keeping the order within each part
+func pinnedFirst(stashes []Stash) []Stash {
+	sorted := slices.Clone(stashes)
+	slices.SortStableFunc(sorted, func(a, b Stash) int {
+		switch {
+		case a.Pinned == b.Pinned:
+			return 0
+		case a.Pinned:
+			return -1
+		}
+		return 1
+	})
+	return sorted
+}
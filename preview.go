@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Build Mode Preview
+// ---------------------------------------------------------------------------
+
+// previewDelay is how long the cursor has to rest on a file before its diff is
+// fetched, so scrolling through the list doesn't start a git process per row
+const previewDelay = 150 * time.Millisecond
+
+type previewTickMsg struct {
+	seq int // only the timer for the latest cursor move may fetch
+}
+
+func previewAfter(seq int) tea.Cmd {
+	return tea.Tick(previewDelay, func(time.Time) tea.Msg {
+		return previewTickMsg{seq: seq}
+	})
+}
+
+// samePreview reports whether a and b are the same row of the file list. A path
+// changed in the index and in the working tree has a row, and a diff, for each.
+func samePreview(a, b FileChange) bool {
+	return a.Path == b.Path && a.IsStaged == b.IsStaged
+}
+
+// cachedDiff is the diff already loaded for f, if fileDiffs holds f's side of it
+func (m model) cachedDiff(f FileChange) (string, bool) {
+	diff, ok := m.fileDiffs[f.Path]
+	return diff, ok && m.diffStaged[f.Path] == f.IsStaged
+}
+
+// trackPreview follows the file list's cursor with the preview. A cached diff shows
+// at once; otherwise the fetch waits for the cursor to settle.
+func (m *model) trackPreview() tea.Cmd {
+	if m.mode != ModeBuild || m.showSelection || m.appState == StateHunkSelect {
+		return nil
+	}
+	f, ok := m.fileList.SelectedItem().(FileChange)
+	if !ok {
+		if m.previewFile != nil {
+			m.previewFile = nil
+			m.previewSeq++
+			m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+			m.buildViewport.GotoTop()
+		}
+		return nil
+	}
+	if m.previewFile != nil && samePreview(*m.previewFile, f) {
+		return nil
+	}
+	m.previewFile = &f
+	m.previewSeq++
+	diff, cached := m.cachedDiff(f)
+	m.previewDiff, m.previewLoaded = diff, cached
+	m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	m.buildViewport.GotoTop()
+	if cached {
+		return nil
+	}
+	return previewAfter(m.previewSeq)
+}
+
+// gotPreviewDiff shows a fetched diff if it's for the previewed file; one that
+// arrives after the cursor moved on is left in the cache
+func (m *model) gotPreviewDiff(msg fileDiffMsg, diff string) bool {
+	if m.previewFile == nil || m.previewFile.Path != msg.path || m.previewFile.IsStaged != msg.staged {
+		return false
+	}
+	m.previewDiff, m.previewLoaded = diff, true
+	return true
+}
+
+// forgetPreviews drops the diffs cached only for previewing, such as after the
+// working tree or the diff options changed, and has the preview fetched again
+func (m *model) forgetPreviews() {
+	maps.DeleteFunc(m.fileDiffs, func(path string, _ string) bool {
+		_, selected := m.selectedFiles[path]
+		return !selected
+	})
+	m.previewFile = nil
+}
+
+// toggleSelectionView switches the right pane between previewing the highlighted file
+// and the selected files' collapsible diffs
+func (m *model) toggleSelectionView() tea.Cmd {
+	m.showSelection = !m.showSelection
+	m.previewFile = nil // trackPreview picks the cursor up again
+	m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	m.buildViewport.GotoTop()
+	if m.showSelection {
+		return m.fetchMissingDiffs()
+	}
+	return nil
+}
+
+// previewView is the right pane while previewing: the highlighted file's diff,
+// whether or not it's selected
+func (m model) previewView() string {
+	if m.previewFile == nil {
+		if dir, ok := m.fileList.SelectedItem().(fileDirRow); ok {
+			return fmt.Sprintf("%s/ has %d change(s).\n\n[Space] Collapse/expand  [Enter] Select all of them  [v] Selected files", dir.Path, len(dir.Files))
+		}
+		return "Move through the list to preview a file's diff.\n[Enter] Select file  [v] Selected files  [s] Create stash"
+	}
+	f := *m.previewFile
+	state := "not selected"
+	if sel, ok := m.selectedFiles[f.Path]; ok && samePreview(sel, f) {
+		state = "selected"
+	}
+	header := titleStyle.Render(fmt.Sprintf("Preview: %s (%s, %s)", f.Path, f.Description(), state)) + "\n" +
+		statusStyle.Render(fmt.Sprintf("[Enter] Select/deselect  [v] Selected files (%d)", len(m.selectedFiles))) + "\n\n"
+	if !m.previewLoaded {
+		return header + "Loading diff..."
+	}
+	return header + m.previewDiff
+}
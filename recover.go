@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Recover Dropped Stashes
+// ---------------------------------------------------------------------------
+
+// recoverCandidate is an unreachable commit that looks like a dropped stash
+type recoverCandidate struct {
+	Sha, Subject string
+	Date         time.Time
+}
+
+// veryOld reports whether the candidate is past the old age threshold, so probably
+// not the stash that was just dropped
+func (c recoverCandidate) veryOld() bool {
+	return cfg.Ages.ageOf(c.Date, time.Now()) == ageOld
+}
+
+func (c recoverCandidate) Title() string { return c.Subject }
+func (c recoverCandidate) Description() string {
+	desc := fmt.Sprintf("%s (%s)", c.Sha[:7], relativeTime(c.Date, time.Now()))
+	if c.veryOld() {
+		desc += " · very old"
+	}
+	return desc
+}
+func (c recoverCandidate) FilterValue() string { return c.Subject }
+
+type recoverCandidatesMsg struct {
+	candidates []recoverCandidate
+	err        error
+}
+
+type stashRecoveredMsg struct {
+	candidate recoverCandidate
+	err       error
+}
+
+// findDroppedStashes asks fsck for commits nothing points to, and keeps the ones whose
+// subject reads like a stash's. Stashes that are still listed live only in the stash
+// reflog, which --no-reflogs ignores, so they're left out by SHA.
+func findDroppedStashes() tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "fsck", "--unreachable", "--no-reflogs", "--no-progress").Output()
+		if err != nil {
+			return recoverCandidatesMsg{err: err}
+		}
+		var shas []string
+		for _, line := range splitLines(string(out)) {
+			if sha, ok := strings.CutPrefix(line, "unreachable commit "); ok {
+				shas = append(shas, sha)
+			}
+		}
+		if len(shas) == 0 {
+			return recoverCandidatesMsg{}
+		}
+
+		listed := make(map[string]bool)
+		if out, err := exec.Command("git", "stash", "list", "--format=%H").Output(); err == nil {
+			for _, sha := range splitLines(string(out)) {
+				listed[sha] = true
+			}
+		}
+
+		// One git log for every commit, newest first
+		log := exec.Command("git", "log", "--no-walk", "--stdin", "--format=%H%x00%ct%x00%s")
+		log.Stdin = strings.NewReader(strings.Join(shas, "\n") + "\n")
+		out, err = log.Output()
+		if err != nil {
+			return recoverCandidatesMsg{err: err}
+		}
+		var candidates []recoverCandidate
+		for _, line := range splitLines(string(out)) {
+			fields := strings.SplitN(line, "\x00", 3)
+			if len(fields) != 3 || listed[fields[0]] {
+				continue
+			}
+			if !strings.HasPrefix(fields[2], "WIP on ") && !strings.HasPrefix(fields[2], "On ") {
+				continue
+			}
+			unix, _ := strconv.ParseInt(fields[1], 10, 64)
+			candidates = append(candidates, recoverCandidate{Sha: fields[0], Subject: fields[2], Date: time.Unix(unix, 0)})
+		}
+		return recoverCandidatesMsg{candidates: candidates}
+	}
+}
+
+// recoverStash puts a dropped stash back on top of the stash list
+func recoverStash(c recoverCandidate) tea.Cmd {
+	return func() tea.Msg {
+		out, err := exec.Command("git", "stash", "store", "-m", c.Subject, c.Sha).CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return stashRecoveredMsg{candidate: c, err: err}
+	}
+}
+
+// startRecover switches to the recovery list and starts the fsck, which can take a
+// while on a big repository
+func (m *model) startRecover() tea.Cmd {
+	m.appState = StateRecover
+	m.recoverList.SetItems(nil)
+	m.recoverList.Title = "Packrat - Recover (searching)"
+	m.displayedRef = ""
+	m.viewport.SetContent("Looking for dropped stashes with git fsck...")
+	m.viewport.GotoTop()
+	return tea.Batch(m.recoverList.StartSpinner(), findDroppedStashes())
+}
+
+// gotDroppedStashes fills the recovery list with what fsck found
+func (m *model) gotDroppedStashes(msg recoverCandidatesMsg) {
+	m.recoverList.StopSpinner()
+	if m.appState != StateRecover {
+		return
+	}
+	if msg.err != nil {
+		m.recoverList.Title = "Packrat - Recover"
+		m.viewport.SetContent(fmt.Sprintf("Error running git fsck: %v", msg.err))
+		return
+	}
+	items := make([]list.Item, len(msg.candidates))
+	for i, c := range msg.candidates {
+		items[i] = c
+	}
+	m.recoverList.SetItems(items)
+	m.recoverList.Title = fmt.Sprintf("Packrat - Recover (%d found)", len(items))
+	m.recoverList.Select(0)
+	m.showRecoverCandidate()
+}
+
+// showRecoverCandidate describes the highlighted candidate in the right pane
+func (m *model) showRecoverCandidate() {
+	c, ok := m.recoverList.SelectedItem().(recoverCandidate)
+	if !ok {
+		m.viewport.SetContent("No dropped stashes found. Stashes git has already garbage collected can't be recovered.")
+		return
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(c.Sha) + "\n\n")
+	b.WriteString(c.Subject + "\n")
+	b.WriteString(statusStyle.Render(fmt.Sprintf("Created %s (%s)", c.Date.Format("2006-01-02 15:04:05 -0700"), relativeTime(c.Date, time.Now()))) + "\n")
+	if c.veryOld() {
+		b.WriteString("\n" + removedLineStyle.Render("This is very old, so it's probably not the stash you just dropped.") + "\n")
+	}
+	b.WriteString("\nPress Enter to put it back on top of the stash list.")
+	m.viewport.SetContent(b.String())
+	m.viewport.GotoTop()
+}
+
+// updateRecover handles keys while dropped stashes are listed
+func (m model) updateRecover(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.appState = StateExplore
+		m.recoverList.StopSpinner()
+		if sel, ok := m.selectedStash(); ok {
+			return m, m.showStashDiff(sel.Ref, false)
+		}
+		return m, nil
+	case "enter":
+		if _, ok := m.recoverList.SelectedItem().(recoverCandidate); ok {
+			m.activeModal = ModalRecoverConfirm
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if m.focus == PaneDiff {
+		m.viewport, cmd = m.viewport.Update(msg)
+	} else {
+		before := m.recoverList.Index()
+		m.recoverList, cmd = m.recoverList.Update(msg)
+		if m.recoverList.Index() != before {
+			m.showRecoverCandidate()
+		}
+	}
+	return m, cmd
+}
+
+func (m model) recoverConfirmView() string {
+	c, _ := m.recoverList.SelectedItem().(recoverCandidate)
+	var b strings.Builder
+	b.WriteString("Recover this stash?\n\n")
+	b.WriteString(fmt.Sprintf("  %s %s\n", m.formatSHA(c.Sha), c.Subject))
+	b.WriteString(statusStyle.Render(fmt.Sprintf("  created %s", relativeTime(c.Date, time.Now()))) + "\n")
+	if c.veryOld() {
+		b.WriteString("\n" + removedLineStyle.Render("⚠️  This is very old; double check it's the one you want.") + "\n")
+	}
+	b.WriteString("\nIt will be stored as stash@{0}.\n\n[y] Yes   [n] No")
+	return modalStyle.Render(b.String())
+}
+
+// newRecoverList lists dropped stash candidates, newest first
+func newRecoverList() list.Model {
+	l := list.New([]list.Item{}, list.NewDefaultDelegate(), 30, 10)
+	l.Title = "Packrat - Recover"
+	return l
+}
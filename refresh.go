@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// File List Refresh
+// ---------------------------------------------------------------------------
+
+// fileListTickMsg is the packrat.refreshInterval timer going off
+type fileListTickMsg struct{}
+
+// pollChangedFiles waits out cfg.RefreshInterval before the next check of the working tree
+func pollChangedFiles() tea.Cmd {
+	return tea.Tick(time.Duration(cfg.RefreshInterval)*time.Second, func(time.Time) tea.Msg {
+		return fileListTickMsg{}
+	})
+}
+
+// pollDue reports whether a timed refresh may reload the file list now. It waits while
+// a modal or the hunk picker is open, or git is already busy, rather than change the
+// list under them.
+func (m model) pollDue() bool {
+	return m.mode == ModeBuild && m.activeModal == ModalNone && !m.loading && m.appState != StateHunkSelect
+}
+
+// refreshStaleDiffs re-fetches the loaded diffs of files whose mtime moved since the
+// file list was last loaded, and drops the previews cached for them. Files git still
+// reports the same way can have changed all the same.
+func (m *model) refreshStaleDiffs(mtimes map[string]time.Time) tea.Cmd {
+	var cmds []tea.Cmd
+	for path, mtime := range mtimes {
+		seen, ok := m.listMtimes[path]
+		if !ok || seen.Equal(mtime) {
+			continue
+		}
+		if file, selected := m.selectedFiles[path]; selected {
+			if _, loaded := m.fileDiffs[path]; loaded {
+				cmds = append(cmds, refreshFileDiff(file, m.fileDiffOptions()))
+			}
+		} else {
+			delete(m.fileDiffs, path)
+		}
+		if f := m.previewFile; f != nil && f.Path == path {
+			if sel, selected := m.selectedFiles[path]; !selected || !samePreview(sel, *f) {
+				cmds = append(cmds, refreshFileDiff(*f, m.fileDiffOptions()))
+			}
+		}
+	}
+	m.listMtimes = mtimes
+	return tea.Batch(cmds...)
+}
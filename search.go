@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Diff Search
+// ---------------------------------------------------------------------------
+
+var (
+	searchMatchStyle  = lipgloss.NewStyle().Reverse(true)
+	currentMatchStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("0")).Background(lipgloss.Color("214"))
+)
+
+// diffMatch is one occurrence of the search query. Columns are in cells, so they line
+// up with the colored text no matter how many escape codes it holds.
+type diffMatch struct {
+	Line, Start, End int
+}
+
+// findMatches searches the diff with its colors stripped. Like less with -i, an
+// all-lowercase query ignores case.
+func findMatches(diff, query string) []diffMatch {
+	if query == "" {
+		return nil
+	}
+	foldCase := strings.ToLower(query) == query
+	var matches []diffMatch
+	for i, line := range strings.Split(diff, "\n") {
+		plain := ansi.Strip(line)
+		haystack := plain
+		if foldCase && len(strings.ToLower(plain)) == len(plain) {
+			haystack = strings.ToLower(plain)
+		}
+		for offset := 0; ; {
+			at := strings.Index(haystack[offset:], query)
+			if at < 0 {
+				break
+			}
+			at += offset
+			start := ansi.StringWidth(plain[:at])
+			end := start + ansi.StringWidth(plain[at:at+len(query)])
+			matches = append(matches, diffMatch{Line: i, Start: start, End: end})
+			offset = at + len(query)
+		}
+	}
+	return matches
+}
+
+// highlightMatches marks every match in the colored diff, and the current one more loudly
+func highlightMatches(diff string, matches []diffMatch, current int) string {
+	lines := strings.Split(diff, "\n")
+	// Work backwards so cutting a line doesn't move the matches before it
+	for i := len(matches) - 1; i >= 0; i-- {
+		match := matches[i]
+		line := lines[match.Line]
+		style := searchMatchStyle
+		if i == current {
+			style = currentMatchStyle
+		}
+		lines[match.Line] = ansi.Cut(line, 0, match.Start) +
+			style.Render(ansi.Strip(ansi.Cut(line, match.Start, match.End))) +
+			ansi.Cut(line, match.End, ansi.StringWidth(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startSearch opens the search prompt in the status line
+func (m *model) startSearch() tea.Cmd {
+	m.searchActive = true
+	m.searchInput.SetValue("")
+	return m.searchInput.Focus()
+}
+
+// updateSearch feeds keys to the search prompt; enter searches, esc gives up
+func (m model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.searchActive = false
+		m.searchInput.Blur()
+		return m, nil
+	case "enter":
+		m.searchActive = false
+		m.searchInput.Blur()
+		m.searchQuery = m.searchInput.Value()
+		m.searchedDiff = m.renderedDiff()
+		m.searchMatches = findMatches(m.searchedDiff, m.searchQuery)
+		if len(m.searchMatches) == 0 {
+			m.viewport.SetContent(clampLines(m.searchedDiff, cfg.MaxLineWidth))
+			m.status = fmt.Sprintf("Pattern not found: %s", m.searchQuery)
+			return m, nil
+		}
+		// Start from the first match at or below the top of the viewport
+		m.searchIndex = 0
+		for i, match := range m.searchMatches {
+			if match.Line >= m.viewport.YOffset {
+				m.searchIndex = i
+				break
+			}
+		}
+		m.showMatch()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	return m, cmd
+}
+
+// jumpToMatch moves to the next (delta 1) or previous (delta -1) match, wrapping around
+func (m *model) jumpToMatch(delta int) {
+	if len(m.searchMatches) == 0 || m.searchedDiff != m.renderedDiff() {
+		m.status = "No search; press / with the diff focused"
+		return
+	}
+	m.searchIndex = (m.searchIndex + delta + len(m.searchMatches)) % len(m.searchMatches)
+	m.showMatch()
+}
+
+// showMatch redraws the highlights and scrolls the current match to the top
+func (m *model) showMatch() {
+	m.viewport.SetContent(clampLines(highlightMatches(m.searchedDiff, m.searchMatches, m.searchIndex), cfg.MaxLineWidth))
+	m.viewport.SetYOffset(m.searchMatches[m.searchIndex].Line)
+	m.status = fmt.Sprintf("/%s  match %d of %d  [n] Next  [N] Previous", m.searchQuery, m.searchIndex+1, len(m.searchMatches))
+}
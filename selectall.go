@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Select All
+// ---------------------------------------------------------------------------
+
+// selectAllFiles selects every file the list shows, narrowed by its filter if one is
+// applied, including files in collapsed directories. They start collapsed and their
+// diffs aren't fetched until they're expanded, so selecting a hundred files doesn't
+// start a hundred git processes.
+func (m *model) selectAllFiles() tea.Cmd {
+	var files []FileChange
+	for _, item := range m.fileList.VisibleItems() {
+		switch it := item.(type) {
+		case FileChange:
+			files = append(files, it)
+		case fileDirRow:
+			if !it.Expanded {
+				files = append(files, it.Files...)
+			}
+		}
+	}
+	added := 0
+	for _, f := range files {
+		if _, exists := m.selectedFiles[f.Path]; !exists && !f.IsConflicted {
+			m.selectedFiles[f.Path] = f
+			m.expandedFiles[f.Path] = false
+			added++
+		}
+	}
+	m.status = fmt.Sprintf("Selected %d more file(s)", added)
+	m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	m.buildViewport.GotoTop()
+	return tea.Batch(m.fetchMissingDiffs(), m.saveSession())
+}
+
+// deselectAllFiles clears the selection
+func (m *model) deselectAllFiles() tea.Cmd {
+	m.status = fmt.Sprintf("Deselected %d file(s)", len(m.selectedFiles))
+	clear(m.selectedFiles)
+	clear(m.expandedFiles)
+	clear(m.fileDiffs)
+	clear(m.summarizedFiles)
+	clear(m.fileMtimes)
+	m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	m.buildViewport.GotoTop()
+	return m.saveSession()
+}
+
+// startOver clears the selection and the reviewed marks, which otherwise last through
+// trips to Explore Mode
+func (m *model) startOver() tea.Cmd {
+	clear(m.reviewedFiles)
+	cmd := m.deselectAllFiles()
+	m.status = "Cleared the selection and reviewed marks"
+	return cmd
+}
+
+// needsDiff reports whether a selected file's diff is on screen, so has to be fetched
+// rather than left until it's expanded
+func (m model) needsDiff(path string) bool {
+	return m.unifiedPatch || m.expandedFiles[path]
+}
+
+// fetchMissingDiffs fetches the diffs that are on screen but haven't been loaded yet
+func (m model) fetchMissingDiffs() tea.Cmd {
+	var cmds []tea.Cmd
+	for path, file := range m.selectedFiles {
+		if _, loaded := m.cachedDiff(file); !loaded && m.needsDiff(path) {
+			cmds = append(cmds, getFileDiff(file, m.fileDiffOptions()))
+		}
+	}
+	return tea.Batch(cmds...)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Build Mode Sessions
+// ---------------------------------------------------------------------------
+
+// session is the Build Mode selection saved between runs, so quitting or crashing
+// mid-selection doesn't lose it
+type session struct {
+	Files    []FileChange `json:"files"`
+	Expanded []string     `json:"expanded,omitempty"`
+}
+
+type sessionLoadedMsg struct {
+	session session
+}
+
+// sessionSeq numbers session writes in the order Update asked for them, since their
+// Cmds can run in any order
+var sessionSeq atomic.Uint64
+
+var (
+	sessionMu      sync.Mutex
+	sessionWritten uint64 // the number of the newest write that has landed
+)
+
+// sessionPath is the session file for the current repository, keyed by a hash of its
+// top-level directory
+func sessionPath() (string, error) {
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(string(top))))
+	return filepath.Join(dir, "packrat", "sessions", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// saveSession writes the current selection, or removes the session file when nothing is selected
+func (m model) saveSession() tea.Cmd {
+	s := session{Files: m.sortedSelectedFiles()}
+	for _, f := range s.Files {
+		if m.expandedFiles[f.Path] {
+			s.Expanded = append(s.Expanded, f.Path)
+		}
+	}
+	seq := sessionSeq.Add(1)
+	return func() tea.Msg {
+		if len(s.Files) == 0 {
+			writeSession(seq, nil)
+			return nil
+		}
+		if data, err := json.Marshal(s); err == nil {
+			writeSession(seq, data)
+		}
+		return nil
+	}
+}
+
+// clearSession forgets the saved selection, e.g. once it has been stashed
+func clearSession() tea.Cmd {
+	seq := sessionSeq.Add(1)
+	return func() tea.Msg {
+		writeSession(seq, nil)
+		return nil
+	}
+}
+
+// writeSession replaces the session file with data, or removes it when data is nil,
+// unless a write numbered after seq has landed already. The file is written beside
+// the old one and renamed over it, so a crash can't leave half of it behind.
+func writeSession(seq uint64, data []byte) {
+	path, err := sessionPath()
+	if err != nil {
+		return
+	}
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if seq < sessionWritten {
+		return
+	}
+	sessionWritten = seq
+
+	if data == nil {
+		os.Remove(path)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".session-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// loadSession reads the saved selection, if there is one
+func loadSession() tea.Cmd {
+	return func() tea.Msg {
+		path, err := sessionPath()
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var s session
+		if err := json.Unmarshal(data, &s); err != nil || len(s.Files) == 0 {
+			return nil
+		}
+		return sessionLoadedMsg{session: s}
+	}
+}
+
+// restoreSession re-selects the saved files that are still changed, in the same
+// staged/unstaged state, and fetches their diffs
+func (m *model) restoreSession(s session) tea.Cmd {
+	type key struct {
+		path   string
+		staged bool
+	}
+	changed := make(map[key]FileChange)
+	for _, f := range m.changedFiles {
+		changed[key{f.Path, f.IsStaged}] = f
+	}
+	expanded := make(map[string]bool)
+	for _, path := range s.Expanded {
+		expanded[path] = true
+	}
+
+	var cmds []tea.Cmd
+	for _, saved := range s.Files {
+		f, ok := changed[key{saved.Path, saved.IsStaged}]
+		if !ok || f.IsConflicted {
+			continue
+		}
+		m.selectedFiles[f.Path] = f
+		m.expandedFiles[f.Path] = expanded[f.Path]
+		cmds = append(cmds, getFileDiff(f, m.fileDiffOptions()))
+	}
+	return tea.Batch(cmds...)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionWritesLandInOrder(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	m := testModel(t)
+	path, err := sessionPath()
+	if err != nil {
+		t.Skipf("not in a git repository: %v", err)
+	}
+	load := func() []FileChange {
+		msg, _ := loadSession()().(sessionLoadedMsg)
+		return msg.session.Files
+	}
+
+	m.selectedFiles["a.go"] = FileChange{Path: "a.go", Status: "M"}
+	older := m.saveSession()
+	m.selectedFiles["b.go"] = FileChange{Path: "b.go", Status: "M"}
+	newer := m.saveSession()
+
+	// The newer snapshot's Cmd happens to run first
+	newer()
+	older()
+	if files := load(); len(files) != 2 {
+		t.Fatalf("session has %d file(s) after an older save ran last, want the newer 2", len(files))
+	}
+
+	stale := m.saveSession()
+	clearSession()()
+	stale()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("an older save brought back the cleared session: %v", err)
+	}
+
+	m.saveSession()()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(path) {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("session directory holds %q, want just %s", names, filepath.Base(path))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Stash Sources
+// ---------------------------------------------------------------------------
+
+// stashSource is where Explore Mode gets its stashes and their diffs from
+type stashSource interface {
+	Stashes() ([]Stash, error)
+	// Diff returns the stash's diff, or a summary when it's over budget and full isn't set
+	Diff(ref string, full bool, opts diffOptions) (diff string, summarized bool, err error)
+	// Live reports whether the stashes are real, so operations that run git on them make sense
+	Live() bool
+}
+
+// source is git unless --from-stdin swaps in a fixture
+var source stashSource = gitSource{}
+
+// gitSource reads stashes from the repository in the working directory
+type gitSource struct{}
+
+func (gitSource) Stashes() ([]Stash, error) { return listStashes() }
+func (gitSource) Live() bool                { return true }
+
+func (gitSource) Diff(ref string, full bool, opts diffOptions) (string, bool, error) {
+	if !full && overLineBudget("stash", "show", "--numstat", "-u", ref) {
+		cmd := exec.Command("git", "stash", "show", "--no-color", "--stat", "-u", ref)
+		out, err := cmd.CombinedOutput()
+		return untrackedSection(ref, false) + colorizeStat(string(out)), true, err
+	}
+
+	show := func(flags ...string) ([]byte, error) {
+		args := append([]string{"stash", "show", "--no-color"}, flags...)
+		args = append(append(args, opts.args()...), "-p", "-M", ref)
+		return exec.Command("git", args...).CombinedOutput()
+	}
+	out, err := show("-u")
+	legacy := false
+	if err != nil && untrackedUnsupported(string(out)) {
+		// git before 2.32 can't show untracked files; show what it can
+		legacy = true
+		out, err = show()
+	}
+	return untrackedSection(ref, legacy) + decorateRenames(opts.colorize(string(out))), false, err
+}
+
+// untrackedUnsupported recognizes an old git rejecting `stash show -u`
+func untrackedUnsupported(output string) bool {
+	return strings.Contains(output, "unknown switch") || strings.Contains(output, "unknown option")
+}
+
+// untrackedSection lists the untracked files a stash holds in its third parent, ahead
+// of the diff, so they can't be mistaken for tracked changes. It's empty when the stash
+// has none. With legacy set the diff below lacks them, so git's --stat of the parent
+// is shown instead of a plain list.
+func untrackedSection(ref string, legacy bool) string {
+	// A stash made with -u but nothing untracked still gets an (empty) third parent
+	out, err := exec.Command("git", "ls-tree", "-r", "--name-only", ref+"^3").Output()
+	paths := splitPaths(string(out))
+	if err != nil || len(paths) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Untracked files included:") + "\n")
+	if legacy {
+		stat, _ := exec.Command("git", "show", "--no-color", "--stat", "--format=", ref+"^3").Output()
+		b.WriteString(colorizeStat(string(stat)))
+	} else {
+		for _, path := range paths {
+			b.WriteString("  " + path + "\n")
+		}
+	}
+	return b.String() + "\n"
+}
+
+// fixtureSource serves stashes read from a fixture, for demos and reproducing bug reports
+type fixtureSource struct {
+	stashes []Stash
+	diffs   map[string]string // ref -> plain unified diff
+}
+
+func (s fixtureSource) Stashes() ([]Stash, error) { return s.stashes, nil }
+func (s fixtureSource) Live() bool                { return false }
+
+// Diff ignores opts: fixtures hold a single rendering of each diff
+func (s fixtureSource) Diff(ref string, full bool, opts diffOptions) (string, bool, error) {
+	diff, ok := s.diffs[ref]
+	if !ok {
+		return "", false, fmt.Errorf("no stash %s in the fixture", ref)
+	}
+	return decorateRenames(colorizeDiff(diff)), false, nil
+}
+
+// readFixture parses the --from-stdin format. Each stash starts with a header line
+//
+//	=== <ref> <unix timestamp> <message>
+//
+// followed by its unified diff, up to the next header. Anything before the first
+// header is ignored, so fixtures can start with a comment.
+func readFixture(r io.Reader) (fixtureSource, error) {
+	src := fixtureSource{diffs: make(map[string]string)}
+	var diff *strings.Builder
+	var ref string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		header, ok := strings.CutPrefix(line, "=== ")
+		if !ok {
+			if diff != nil {
+				diff.WriteString(line + "\n")
+			}
+			continue
+		}
+		if diff != nil {
+			src.diffs[ref] = diff.String()
+		}
+
+		fields := strings.SplitN(header, " ", 3)
+		if len(fields) < 3 {
+			return src, fmt.Errorf("line %d: want \"=== <ref> <unix timestamp> <message>\"", lineNo)
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return src, fmt.Errorf("line %d: bad timestamp %q", lineNo, fields[1])
+		}
+		ts := time.Unix(unix, 0)
+		ref = fields[0]
+		src.stashes = append(src.stashes, Stash{
+			Ref:       ref,
+			Message:   fields[2],
+			Created:   formatTimestamp(ts, cfg.DateFormat, time.Now()),
+			Branch:    parseStashBranch(fields[2]),
+			Timestamp: ts,
+		})
+		diff = &strings.Builder{}
+	}
+	if diff != nil {
+		src.diffs[ref] = diff.String()
+	}
+	return src, scanner.Err()
+}
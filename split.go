@@ -0,0 +1,173 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Side-by-Side Diff
+// ---------------------------------------------------------------------------
+
+// splitMinWidth is the narrowest diff pane the side-by-side view is offered in; any
+// narrower and both columns get too cramped to read
+const splitMinWidth = 120
+
+var (
+	splitGutterStyle = lipgloss.NewStyle().Faint(true)
+	hunkRangePattern = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+)
+
+// splitRow is one side of a side-by-side row; a zero Line leaves that side blank
+type splitRow struct {
+	Line  int
+	Text  string
+	Style lipgloss.Style
+}
+
+// renderSplitDiff lays a (possibly colored) unified diff out as old and new columns,
+// width cells wide in total. Everything outside hunks, such as file headers, keeps the
+// full width, and so do files that were added or deleted outright, since one of their
+// columns would be empty.
+func renderSplitDiff(diff string, width int) string {
+	column := (width - 3) / 2 // minus the " │ " between the columns
+	var out []string
+	var removed, added []splitRow
+	flush := func() {
+		for i := 0; i < max(len(removed), len(added)); i++ {
+			var left, right splitRow
+			if i < len(removed) {
+				left = removed[i]
+			}
+			if i < len(added) {
+				right = added[i]
+			}
+			out = append(out, splitCell(left, column)+splitGutterStyle.Render(" │ ")+splitCell(right, column))
+		}
+		removed, added = nil, nil
+	}
+
+	inHunk, wholeFile := false, false
+	oldLine, newLine := 0, 0
+	for _, line := range strings.Split(diff, "\n") {
+		plain := ansi.Strip(line)
+		if strings.HasPrefix(plain, "diff --git ") {
+			flush()
+			inHunk, wholeFile = false, false
+			out = append(out, line)
+			continue
+		}
+		if !inHunk && (strings.HasPrefix(plain, "new file mode") || strings.HasPrefix(plain, "deleted file mode")) {
+			wholeFile = true
+		}
+		if match := hunkRangePattern.FindStringSubmatch(plain); match != nil {
+			flush()
+			inHunk = true
+			oldLine, _ = strconv.Atoi(match[1])
+			newLine, _ = strconv.Atoi(match[2])
+			out = append(out, line)
+			continue
+		}
+		if !inHunk || wholeFile || plain == "" {
+			flush()
+			out = append(out, line)
+			continue
+		}
+
+		text := plain[1:]
+		switch plain[0] {
+		case '-':
+			removed = append(removed, splitRow{Line: oldLine, Text: text, Style: removedLineStyle})
+			oldLine++
+		case '+':
+			added = append(added, splitRow{Line: newLine, Text: text, Style: addedLineStyle})
+			newLine++
+		case ' ':
+			flush()
+			out = append(out, splitCell(splitRow{Line: oldLine, Text: text}, column)+splitGutterStyle.Render(" │ ")+
+				splitCell(splitRow{Line: newLine, Text: text}, column))
+			oldLine++
+			newLine++
+		default:
+			// e.g. "\ No newline at end of file"
+			flush()
+			out = append(out, line)
+		}
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+// splitCell renders one side of a row as exactly width cells: a line number gutter,
+// then the text, cut off or padded to fit. The text is styled after it's measured, so
+// escape codes can't throw the columns out of line.
+func splitCell(row splitRow, width int) string {
+	const gutter = 5 // four digits and a space
+	if row.Line == 0 {
+		return strings.Repeat(" ", max(width, 0))
+	}
+	textWidth := max(width-gutter, 1)
+	text := ansi.Truncate(strings.ReplaceAll(row.Text, "\t", "    "), textWidth, "…")
+	text += strings.Repeat(" ", max(textWidth-ansi.StringWidth(text), 0))
+	number := strconv.Itoa(row.Line)
+	if len(number) > gutter-1 {
+		number = number[len(number)-(gutter-1):]
+	}
+	return splitGutterStyle.Render(strings.Repeat(" ", gutter-1-len(number))+number+" ") + row.Style.Render(text)
+}
+
+// diffPaneWidth is how many cells of content fit across the Explore Mode diff pane
+func (m model) diffPaneWidth() int {
+	return m.viewport.Width - m.viewport.Style.GetHorizontalFrameSize()
+}
+
+// splitUnavailable explains why the side-by-side view can't show the current diff, or
+// returns "" when it can
+func (m model) splitUnavailable() string {
+	switch {
+	case m.diffPaneWidth() < splitMinWidth:
+		return "Side-by-side needs a wider diff pane"
+	case m.statView:
+		return "Side-by-side shows diffs, not --stat views"
+	case m.diffOpts.Words:
+		return "Side-by-side doesn't work with the word diff"
+	case m.diffSummarized:
+		return "Side-by-side needs the full diff; press F"
+	}
+	return ""
+}
+
+// renderedDiff is m.diff as the viewport shows it: side by side when that's on and
+// possible for a stash diff or comparison, as is otherwise
+func (m model) renderedDiff() string {
+	if m.splitView && (m.displayedRef != "" || m.comparing) && m.splitUnavailable() == "" {
+		return renderSplitDiff(m.diff, m.diffPaneWidth())
+	}
+	return m.diff
+}
+
+// showDiff puts m.diff in the viewport in its current rendering
+func (m *model) showDiff() {
+	content := m.renderedDiff()
+	m.diffFiles = indexFileHeaders(content)
+	m.viewport.SetContent(clampLines(content, cfg.MaxLineWidth))
+}
+
+// rerenderDiff redraws the displayed diff after its rendering changed, staying on the
+// file that was at the top of the pane
+func (m *model) rerenderDiff() {
+	if m.displayedRef == "" && !m.comparing {
+		return
+	}
+	file := m.currentDiffFile()
+	m.showDiff()
+	if file >= 0 && file < len(m.diffFiles) {
+		m.viewport.SetYOffset(m.diffFiles[file].Line)
+	} else {
+		m.viewport.GotoTop()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Staging
+// ---------------------------------------------------------------------------
+
+// fileStagedMsg reports staging or unstaging a file with g
+type fileStagedMsg struct {
+	file   FileChange // the entry as it was before
+	output string
+	err    error
+}
+
+// toggleStaged stages an unstaged or untracked change with `git add`, or unstages a
+// staged one with `git restore --staged`. Before the first commit there's no HEAD to
+// restore from, so the file is dropped from the index with `git rm --cached` instead.
+func toggleStaged(f FileChange) tea.Cmd {
+	return func() tea.Msg {
+		var args []string
+		if f.IsStaged {
+			args = append([]string{"restore", "--staged", "--"}, f.pathspec()...)
+			if !headExists() {
+				args = append([]string{"rm", "--cached", "-r", "-q", "--"}, f.pathspec()...)
+			}
+		} else {
+			args = append([]string{"add", "--"}, f.pathspec()...)
+		}
+		out, err := exec.Command("git", args...).CombinedOutput()
+		return fileStagedMsg{file: f, output: strings.TrimSpace(string(out)), err: err}
+	}
+}
+
+// stagedStatus describes what g did, or why it failed
+func (msg fileStagedMsg) stagedStatus() string {
+	if msg.err != nil {
+		detail := msg.output
+		if detail == "" {
+			detail = msg.err.Error()
+		}
+		verb := "staging"
+		if msg.file.IsStaged {
+			verb = "unstaging"
+		}
+		return fmt.Sprintf("Error %s %s: %s", verb, msg.file.Path, strings.Join(splitLines(detail), " "))
+	}
+	if msg.file.IsStaged {
+		return "Unstaged " + msg.file.Path
+	}
+	return "Staged " + msg.file.Path
+}
+
+// reconcileSelection brings the selection up to date with a reloaded file list. A
+// selected path that's gone is deselected. One whose entry changed, such as by being
+// staged, takes the new entry, preferring one on the same side of the index, and its
+// diff is fetched again if it was loaded.
+func (m *model) reconcileSelection() tea.Cmd {
+	var cmds []tea.Cmd
+	for path, sel := range m.selectedFiles {
+		var current FileChange
+		found := false
+		for _, f := range m.changedFiles {
+			if f.Path != path {
+				continue
+			}
+			if !found || f.IsStaged == sel.IsStaged {
+				current, found = f, true
+			}
+		}
+		if !found || current.IsConflicted {
+			m.deselectFile(path)
+			continue
+		}
+		if current == sel {
+			continue
+		}
+		m.selectedFiles[path] = current
+		delete(m.summarizedFiles, path)
+		if _, loaded := m.fileDiffs[path]; loaded || m.needsDiff(path) {
+			delete(m.fileDiffs, path)
+			cmds = append(cmds, getFileDiff(current, m.fileDiffOptions()))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// selectFileRow moves the file list's cursor to path, preferring its entry on the
+// given side of the index
+func (m *model) selectFileRow(path string, staged bool) {
+	first := -1
+	for i, item := range m.fileList.Items() {
+		f, ok := item.(FileChange)
+		if !ok || f.Path != path {
+			continue
+		}
+		if f.IsStaged == staged {
+			m.fileList.Select(i)
+			return
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	if first >= 0 {
+		m.fileList.Select(first)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import "slices"
+
+// ---------------------------------------------------------------------------
+// Stash Message Modal Options
+// ---------------------------------------------------------------------------
+
+// openStashModal asks for a stash message. Untracked files are included by default when
+// stashing everything, or when the selection has some, and left out otherwise, so
+// unrelated untracked files under a selected directory stay where they are.
+func (m *model) openStashModal(scope StashScope) {
+	m.stashScope = scope
+	m.stashUntracked = scope == ScopeAll || (scope == ScopeSelection && m.untrackedSelected() > 0)
+	m.stashKeepIndex = false
+	m.stashSnapshot = false
+	m.prefillStashMessage()
+	m.stashInput.Focus()
+	m.activeModal = ModalStashMessage
+}
+
+// includeUntracked reports whether the stash modal will pass --include-untracked. A
+// selection with untracked files always does, since git stash push can't find them
+// otherwise.
+func (m model) includeUntracked() bool {
+	switch m.stashScope {
+	case ScopeStaged:
+		return false
+	case ScopeSelection:
+		return m.stashUntracked || m.untrackedSelected() > 0
+	}
+	return m.stashUntracked
+}
+
+// untrackedToggleView is the stash modal's include untracked line, above the command
+// preview
+func (m model) untrackedToggleView() string {
+	switch {
+	case m.stashScope == ScopeStaged:
+		return ""
+	case m.stashScope == ScopeSelection && m.untrackedSelected() > 0:
+		return "Include untracked: yes (the selection has untracked files)\n"
+	case m.stashUntracked:
+		return "Include untracked: yes\n"
+	}
+	return "Include untracked: no\n"
+}
+
+// stashFlags is the advanced flags input checked against stashPushFlags, with
+// --keep-index added when it's toggled on and not typed in already
+func (m model) stashFlags() ([]string, error) {
+	flags, err := parseStashFlags(m.flagsInput.Value())
+	if err != nil || !m.stashKeepIndex || m.stashScope == ScopeStaged {
+		return flags, err
+	}
+	if slices.Contains(flags, "--keep-index") || slices.Contains(flags, "-k") {
+		return flags, nil
+	}
+	return append([]string{"--keep-index"}, flags...), nil
+}
+
+// keepIndexView is the stash modal's keep index line, above the command preview
+func (m model) keepIndexView() string {
+	switch {
+	case m.stashScope == ScopeStaged:
+		return ""
+	case m.stashKeepIndex:
+		return "Keep index: yes (staged changes are stashed but stay staged)\n"
+	}
+	return "Keep index: no\n"
+}
+
+// snapshotView is the stash modal's keep changes line, above the command preview
+func (m model) snapshotView() string {
+	if m.stashSnapshot {
+		return "Keep changes: yes (snapshot; the working tree is left as it is)\n"
+	}
+	return "Keep changes: no\n"
+}
+
+// renameCleanupArgs takes the original paths of a selection's staged renames out of the
+// index and working tree once the stash is pushed, or is nil when there's nothing to
+// take. git stash push refuses pathspecs that aren't in the index, which a renamed
+// file's original no longer is, so a rename is stashed by its new path alone. The stash
+// records the whole index, deletion included, but git only cleans up what the pathspec
+// named and would leave the deletion staged, splitting the rename. With --keep-index
+// the index stays as it was anyway.
+func renameCleanupArgs(files []FileChange, scope StashScope, flags []string) []string {
+	if scope != ScopeSelection {
+		return nil
+	}
+	for _, flag := range slices.Backward(flags) {
+		if flag == "--keep-index" || flag == "-k" {
+			return nil
+		}
+		if flag == "--no-keep-index" {
+			break
+		}
+	}
+	var paths []string
+	for _, f := range files {
+		if f.IsStaged && f.Status == "R" && f.OldPath != "" {
+			paths = append(paths, f.OldPath)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return append([]string{"--literal-pathspecs", "restore", "--source=HEAD", "--staged", "--worktree", "--"}, paths...)
+}
+
+// snapshotApplyArgs puts a snapshot's changes straight back, staged parts staged
+func snapshotApplyArgs(ref string) []string {
+	return []string{"stash", "apply", "--index", "--quiet", ref}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestIncludeUntracked(t *testing.T) {
+	tracked := FileChange{Path: "a.go", Status: "M"}
+	untracked := FileChange{Path: "new.go", Status: "?", IsUntracked: true}
+	tests := []struct {
+		name        string
+		scope       StashScope
+		selection   []FileChange
+		toggle      bool // Ctrl+u pressed once after the modal opens
+		wantDefault bool
+		wantToggle  bool // stashUntracked once the toggle has been handled
+		want        bool
+	}{
+		{"tracked-only selection", ScopeSelection, []FileChange{tracked}, false, false, false, false},
+		{"tracked-only selection, toggled on", ScopeSelection, []FileChange{tracked}, true, false, true, true},
+		{"mixed selection", ScopeSelection, []FileChange{tracked, untracked}, false, true, true, true},
+		{"mixed selection can't drop them", ScopeSelection, []FileChange{tracked, untracked}, true, true, false, true},
+		{"all changes", ScopeAll, nil, false, true, true, true},
+		{"all changes, toggled off", ScopeAll, nil, true, true, false, false},
+		{"staged changes", ScopeStaged, []FileChange{tracked, untracked}, false, false, false, false},
+		{"staged changes ignore the toggle", ScopeStaged, nil, true, false, false, false},
+		{"staged changes with untracked selected ignore the toggle", ScopeStaged, []FileChange{tracked, untracked}, true, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(t)
+			for _, f := range tt.selection {
+				m.selectedFiles[f.Path] = f
+			}
+			m.openStashModal(tt.scope)
+			if m.stashUntracked != tt.wantDefault {
+				t.Errorf("openStashModal set stashUntracked = %v, want %v", m.stashUntracked, tt.wantDefault)
+			}
+			if tt.toggle {
+				updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlU})
+				m = updated.(model)
+			}
+			if m.stashUntracked != tt.wantToggle {
+				t.Errorf("stashUntracked = %v after the toggle, want %v", m.stashUntracked, tt.wantToggle)
+			}
+			if got := m.includeUntracked(); got != tt.want {
+				t.Errorf("includeUntracked() = %v, want %v", got, tt.want)
+			}
+			args := stashPushArgs(m.sortedSelectedFiles(), "msg", m.stashScope, m.includeUntracked(), nil)
+			if got := slices.Contains(args, "--include-untracked"); got != tt.want {
+				t.Errorf("stashPushArgs() = %q, --include-untracked present = %v, want %v", args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickedHunksUnstaged(t *testing.T) {
+	const patch = "diff --git a/a.go b/a.go\n"
+	open := func(t *testing.T) model {
+		m := testModel(t)
+		m.stagedHunks = patch
+		m.openStashModal(ScopeStaged)
+		return m
+	}
+	tests := []struct {
+		name        string
+		msg         tea.Msg
+		wantUnstage bool
+	}{
+		{"esc", tea.KeyMsg{Type: tea.KeyEsc}, true},
+		{"ctrl+c", tea.KeyMsg{Type: tea.KeyCtrlC}, true},
+		{"stash failed", stashCreatedMsg{err: errors.New("exit status 1")}, true},
+		{"stash saved", stashCreatedMsg{}, false},
+		{"stash saved, then failed", stashCreatedMsg{err: errors.New("exit status 1"), saved: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := open(t)
+			if _, isKey := tt.msg.(tea.KeyMsg); !isKey {
+				// The stash runs with the patch still recorded
+				m.stashInput.SetValue("picked hunks")
+				updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+				m = updated.(model)
+				if m.stagedHunks != patch {
+					t.Fatalf("stagedHunks = %q once the stash started, want the patch", m.stagedHunks)
+				}
+			}
+			updated, _ := m.Update(tt.msg)
+			m = updated.(model)
+			if m.activeModal != ModalNone {
+				t.Errorf("modal %v still open", m.activeModal)
+			}
+			if m.stagedHunks != "" {
+				t.Errorf("stagedHunks = %q, want it cleared", m.stagedHunks)
+			}
+			if m.loading != tt.wantUnstage {
+				t.Errorf("unstaging the hunks = %v, want %v", m.loading, tt.wantUnstage)
+			}
+		})
+	}
+}
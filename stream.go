@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Diff Streaming
+// ---------------------------------------------------------------------------
+
+// diffStream is a `git stash show` whose output is read a chunk of cfg.MaxDiffBytes at
+// a time, so a huge diff doesn't have to be read, colored and laid out in one go. The
+// model keeps the stream between chunks, and kills git once it's no longer wanted.
+type diffStream struct {
+	mu     sync.Mutex // held while git is started, read or waited on, one command at a time
+	ref    string
+	opts   diffOptions
+	cancel context.CancelFunc
+	ctx    context.Context
+	cmd    *exec.Cmd
+	out    *bufio.Reader
+	stderr bytes.Buffer
+	prefix string          // the untracked files section shown above the diff
+	raw    strings.Builder // the uncolored diff read so far
+	done   bool            // git's output has been read to the end
+	waited bool            // git has been waited on, so it's left no zombie behind
+	closed atomic.Bool     // the model let go of the stream; set from the UI goroutine
+}
+
+func newDiffStream(ref string, opts diffOptions) *diffStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &diffStream{ref: ref, opts: opts, ctx: ctx, cancel: cancel}
+}
+
+// close kills git if it's still running; a read in progress then comes back empty.
+// git is reaped in the background once that read lets go of the stream.
+func (s *diffStream) close() {
+	s.closed.Store(true)
+	s.cancel()
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.wait()
+	}()
+}
+
+// wait reaps git, once
+func (s *diffStream) wait() error {
+	if s.cmd == nil || s.waited {
+		return nil
+	}
+	s.waited = true
+	return s.cmd.Wait()
+}
+
+// start runs git, falling back to a show without untracked files on a git too old for
+// them, as gitSource.Diff does
+func (s *diffStream) start() error {
+	legacy := false
+	for {
+		args := []string{"stash", "show", "--no-color"}
+		if !legacy {
+			args = append(args, "-u")
+		}
+		args = append(append(args, s.opts.args()...), "-p", "-M", s.ref)
+		s.cmd = exec.CommandContext(s.ctx, "git", args...)
+		s.stderr.Reset()
+		s.cmd.Stderr = &s.stderr
+		stdout, err := s.cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := s.cmd.Start(); err != nil {
+			s.cmd = nil
+			return err
+		}
+		s.waited = false
+		s.out = bufio.NewReader(stdout)
+		if _, err := s.out.Peek(1); err == io.EOF && !legacy {
+			// Nothing on stdout: either an empty diff or git refusing -u
+			if waitErr := s.wait(); waitErr != nil && untrackedUnsupported(s.stderr.String()) {
+				legacy = true
+				continue
+			} else if waitErr != nil {
+				return fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(s.stderr.String()))
+			}
+			s.done = true
+		}
+		s.prefix = untrackedSection(s.ref, legacy)
+		return nil
+	}
+}
+
+// readChunk reads about another cfg.MaxDiffBytes of the diff, stopping at the end of
+// a line
+func (s *diffStream) readChunk() error {
+	if s.done {
+		return nil
+	}
+	read := 0
+	for read < cfg.MaxDiffBytes {
+		line, err := s.out.ReadString('\n')
+		s.raw.WriteString(line)
+		read += len(line)
+		if err == io.EOF {
+			s.done = true
+			defer s.cancel()
+			if err := s.wait(); err != nil {
+				return fmt.Errorf("%w: %s", err, strings.TrimSpace(s.stderr.String()))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msg renders what's been read so far
+func (s *diffStream) msg(err error) tea.Msg {
+	if s.closed.Load() {
+		// Dropped for another stash, so nobody wants this
+		return nil
+	}
+	diff := s.prefix + decorateRenames(s.opts.colorize(s.raw.String()))
+	if !s.done {
+		diff += "\n" + truncatedNote(s.raw.Len())
+	}
+	return stashDiffMsg{ref: s.ref, diff: diff, opts: s.opts, truncated: !s.done, err: err}
+}
+
+// streamStashDiff loads the first chunk of a stash's diff. A diff over cfg.MaxDiffLines
+// still loads as a --stat summary.
+func streamStashDiff(s *diffStream) tea.Cmd {
+	return func() tea.Msg {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if overLineBudget("stash", "show", "--numstat", "-u", s.ref) {
+			s.close()
+			diff, summarized, err := source.Diff(s.ref, false, s.opts)
+			return stashDiffMsg{ref: s.ref, diff: diff, summarized: summarized, opts: s.opts, err: err}
+		}
+		if err := s.start(); err != nil {
+			return s.msg(err)
+		}
+		return s.msg(s.readChunk())
+	}
+}
+
+// nextDiffChunk loads the next chunk of a truncated diff. Chunks are read one at a
+// time, in order, however fast they're asked for.
+func nextDiffChunk(s *diffStream) tea.Cmd {
+	return func() tea.Msg {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.msg(s.readChunk())
+	}
+}
+
+// truncatedNote is appended to a diff that's only partly loaded
+func truncatedNote(read int) string {
+	return fmt.Sprintf("Diff truncated after %s bytes (packrat.maxDiffBytes is %s). Press L to load more, or F to load the full diff.",
+		groupThousands(read), groupThousands(cfg.MaxDiffBytes))
+}
+
+// closeDiffStream stops reading the diff that's streaming in, if any
+func (m *model) closeDiffStream() {
+	if m.diffStream != nil {
+		m.diffStream.close()
+		m.diffStream = nil
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// List Titles
+// ---------------------------------------------------------------------------
+
+// Update runs update and then retitles the lists, resizes the panes if the help header
+// now wraps to more or fewer lines, and moves the Build Mode preview along, so they
+// track every cursor move, deletion, refresh and switch of screen without each handler
+// having to remember
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	if m, ok := next.(model); ok {
+		m.updateListTitles()
+		if m.headerHeight != m.helpHeaderHeight() {
+			m.layout()
+		}
+		return m, tea.Batch(cmd, m.trackPreview())
+	}
+	return next, cmd
+}
+
+// updateListTitles puts counts in the Explore and Build Mode list titles, e.g.
+// "Packrat - 4/23 stashes" and "Packrat - 12 changed files, 3 selected"
+func (m *model) updateListTitles() {
+	total, position := 0, 0
+	selected := m.stashList.GlobalIndex()
+	for i, item := range m.stashList.Items() {
+		if _, ok := item.(Stash); ok {
+			total++
+			if i <= selected {
+				position = total
+			}
+		}
+	}
+	noun := "stashes"
+	if m.showShared {
+		noun = "shared stashes"
+	}
+	switch {
+	case !m.stashesLoaded:
+		m.stashList.Title = "Packrat - loading stashes"
+	case total == 0:
+		m.stashList.Title = fmt.Sprintf("Packrat - no %s%s", noun, m.stashListNote)
+	default:
+		m.stashList.Title = fmt.Sprintf("Packrat - %d/%d %s%s", position, total, noun, m.stashListNote)
+	}
+
+	m.fileList.Title = fmt.Sprintf("Packrat - %d changed file(s), %d selected%s",
+		len(m.changedFiles), len(m.selectedFiles), m.fileListNote)
+}
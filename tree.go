@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// File Tree
+// ---------------------------------------------------------------------------
+
+// treeFile is a changed path placed in a fileTree
+type treeFile struct {
+	Path   string
+	Status string // status letter, e.g. "M", "A", "D", or "?" for untracked
+}
+
+// treeNode is a directory or a file in a tree of paths. F is what each file holds:
+// a treeFile for the stash tree, a FileChange for the Build Mode list.
+type treeNode[F any] struct {
+	Name     string // last path element
+	Path     string // full path from the repo root
+	IsDir    bool
+	File     F // set for files
+	Children []*treeNode[F]
+}
+
+// treeRow is one visible line of a flattened tree, usable as a list item
+type treeRow struct {
+	Node     *treeNode[treeFile]
+	Depth    int
+	Expanded bool
+}
+
+func (r treeRow) Title() string {
+	indent := strings.Repeat("  ", r.Depth)
+	if r.Node.IsDir {
+		indicator := cfg.Glyphs.Collapsed
+		if r.Expanded {
+			indicator = cfg.Glyphs.Expanded
+		}
+		return fmt.Sprintf("%s%s %s/", indent, indicator, r.Node.Name)
+	}
+	return fmt.Sprintf("%s  %s %s", indent, r.Node.File.Status, r.Node.Name)
+}
+func (r treeRow) Description() string { return r.Node.Path }
+func (r treeRow) FilterValue() string { return r.Node.Path }
+
+// buildFileTree arranges files into directories, with directories listed before files
+func buildFileTree(files []treeFile) *treeNode[treeFile] {
+	return buildTree(files, func(f treeFile) string { return f.Path })
+}
+
+// buildTree arranges files into directories by the path pathOf gives each, with
+// directories listed before files. Files sharing a name keep their order in files.
+func buildTree[F any](files []F, pathOf func(F) string) *treeNode[F] {
+	root := &treeNode[F]{IsDir: true}
+	for _, f := range files {
+		node := root
+		p := pathOf(f)
+		parts := strings.Split(p, "/")
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				node.Children = append(node.Children, &treeNode[F]{Name: part, Path: p, File: f})
+				break
+			}
+			node = node.childDir(part, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	root.sort()
+	return root
+}
+
+// childDir returns the named subdirectory, creating it if needed
+func (n *treeNode[F]) childDir(name, path string) *treeNode[F] {
+	for _, c := range n.Children {
+		if c.IsDir && c.Name == name {
+			return c
+		}
+	}
+	dir := &treeNode[F]{Name: name, Path: path, IsDir: true}
+	n.Children = append(n.Children, dir)
+	return dir
+}
+
+// files is every file in and beneath the node, in tree order
+func (n *treeNode[F]) files() []F {
+	var files []F
+	for _, c := range n.Children {
+		if c.IsDir {
+			files = append(files, c.files()...)
+		} else {
+			files = append(files, c.File)
+		}
+	}
+	return files
+}
+
+func (n *treeNode[F]) sort() {
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		return a.Name < b.Name
+	})
+	for _, c := range n.Children {
+		if c.IsDir {
+			c.sort()
+		}
+	}
+}
+
+// treeRows flattens the tree into the rows currently visible. Directories are
+// expanded unless collapsed[path] is set.
+func treeRows(root *treeNode[treeFile], collapsed map[string]bool) []treeRow {
+	var rows []treeRow
+	walkTree(root, collapsed, func(n *treeNode[treeFile], depth int, expanded bool) {
+		rows = append(rows, treeRow{Node: n, Depth: depth, Expanded: expanded})
+	})
+	return rows
+}
+
+// walkTree calls visit for each visible node in display order, skipping what's
+// beneath a directory in collapsed
+func walkTree[F any](root *treeNode[F], collapsed map[string]bool, visit func(n *treeNode[F], depth int, expanded bool)) {
+	var walk func(n *treeNode[F], depth int)
+	walk = func(n *treeNode[F], depth int) {
+		for _, c := range n.Children {
+			expanded := c.IsDir && !collapsed[c.Path]
+			visit(c, depth, expanded)
+			if expanded {
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(root, 0)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Merge Conflicts
+// ---------------------------------------------------------------------------
+
+// unmergedStatuses are the status pairs git status gives a path a merge, rebase,
+// cherry-pick or revert left conflicted, and what each means
+var unmergedStatuses = map[string]string{
+	"UU": "both modified",
+	"AA": "both added",
+	"DD": "both deleted",
+	"AU": "added by us",
+	"UA": "added by them",
+	"DU": "deleted by us",
+	"UD": "deleted by them",
+}
+
+// conflictedTitleColor picks conflicted files out in the Build Mode list
+var conflictedTitleColor = lipgloss.Color("1")
+
+// repoOp is an operation git can stop partway through for conflicts to be resolved
+type repoOp struct {
+	file   string // kept in the git directory until the operation ends
+	name   string
+	finish string // the command that carries on once conflicts are resolved
+}
+
+var repoOperations = []repoOp{
+	{"rebase-merge", "Rebase", "git rebase --continue"},
+	{"rebase-apply", "Rebase", "git rebase --continue"},
+	{"MERGE_HEAD", "Merge", "git commit"},
+	{"CHERRY_PICK_HEAD", "Cherry-pick", "git cherry-pick --continue"},
+	{"REVERT_HEAD", "Revert", "git revert --continue"},
+}
+
+// repoOperation finds the merge, rebase, cherry-pick or revert the repository is in
+// the middle of, or the zero repoOp if there's none
+func repoOperation() repoOp {
+	out, err := exec.Command("git", "rev-parse", "--git-dir").Output()
+	if err != nil {
+		return repoOp{}
+	}
+	gitDir := strings.TrimSpace(string(out))
+	for _, op := range repoOperations {
+		if _, err := os.Stat(filepath.Join(gitDir, op.file)); err == nil {
+			return op
+		}
+	}
+	return repoOp{}
+}
+
+// conflictedFiles counts the changed files that are still unmerged
+func (m model) conflictedFiles() int {
+	n := 0
+	for _, f := range m.changedFiles {
+		if f.IsConflicted {
+			n++
+		}
+	}
+	return n
+}
+
+// stashBlocked explains why nothing can be stashed right now, or is "" if it can be.
+// git stash refuses to run at all while the index holds unmerged paths.
+func (m model) stashBlocked() string {
+	if n := m.conflictedFiles(); n > 0 {
+		return fmt.Sprintf("Can't stash while %d file(s) are conflicted; resolve them and git add them first", n)
+	}
+	return ""
+}
+
+// operationBanner is the warning above the Build Mode diff pane while a merge, rebase
+// or the like is stopped partway, or "" when none is
+func (m model) operationBanner() string {
+	op := m.repoOperation
+	if op.name == "" {
+		return ""
+	}
+	text := fmt.Sprintf("⚠ %s in progress: conflicts resolved, finish with %s", op.name, op.finish)
+	if n := m.conflictedFiles(); n > 0 {
+		text = fmt.Sprintf("⚠ %s in progress: %d conflicted file(s); nothing can be stashed until they're resolved", op.name, n)
+	}
+	return removedLineStyle.Render(ansi.Truncate(text, m.buildViewport.Width, "…")) + "\n"
+}
+
+// conflictStyled is the delegate to draw item with: d itself, or d with the title in
+// the conflict color if item is a conflicted file
+func conflictStyled(d list.DefaultDelegate, item list.Item) list.DefaultDelegate {
+	var f FileChange
+	switch it := item.(type) {
+	case FileChange:
+		f = it
+	case reviewedFile:
+		f = it.FileChange
+	case treeFileChange:
+		f = it.FileChange
+	default:
+		return d
+	}
+	if f.IsConflicted {
+		d.Styles.NormalTitle = d.Styles.NormalTitle.Foreground(conflictedTitleColor)
+		d.Styles.SelectedTitle = d.Styles.SelectedTitle.Foreground(conflictedTitleColor)
+	}
+	return d
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ---------------------------------------------------------------------------
+// Untracked Files
+// ---------------------------------------------------------------------------
+
+// untrackedPaths lists the files behind an untracked entry. git status reports a
+// directory full of untracked files as the directory alone, with a trailing slash.
+func untrackedPaths(file FileChange) ([]string, error) {
+	if !strings.HasSuffix(file.Path, "/") {
+		return []string{file.Path}, nil
+	}
+	out, err := exec.Command("git", "ls-files", "--others", "--exclude-standard", "--", file.Path).Output()
+	return splitPaths(string(out)), err
+}
+
+// noIndexDiff runs `git diff --no-index` against /dev/null, which shows a file git
+// doesn't track as added in full. It exits 1 whenever there's a difference, which for
+// a new file is always, so only other exit codes are errors.
+func noIndexDiff(path string, args ...string) (string, error) {
+	cmdArgs := append(append([]string{"diff", "--no-index", "--no-color"}, args...), "--", "/dev/null", path)
+	out, err := exec.Command("git", cmdArgs...).CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 {
+		err = nil
+	}
+	return string(out), err
+}
+
+// fetchUntrackedDiff is fetchFileDiff for an untracked file or directory: its content
+// as an added file, or a --stat summary when that's over cfg.MaxDiffLines unless full
+// is set
+func fetchUntrackedDiff(file FileChange, full bool, opts diffOptions) fileDiffMsg {
+	msg := fileDiffMsg{path: file.Path, opts: opts}
+	paths, err := untrackedPaths(file)
+	if err != nil {
+		msg.err = err
+		return msg
+	}
+
+	if !full && cfg.MaxDiffLines > 0 {
+		lines := 0
+		for _, p := range paths {
+			numstat, _ := noIndexDiff(p, "--numstat")
+			for _, line := range splitLines(numstat) {
+				var added int
+				fmt.Sscanf(line, "%d", &added)
+				lines += added
+			}
+		}
+		if lines > cfg.MaxDiffLines {
+			var stat strings.Builder
+			for _, p := range paths {
+				out, err := noIndexDiff(p, "--stat")
+				if err != nil {
+					msg.err = fmt.Errorf("%v: %s", err, strings.TrimSpace(out))
+					return msg
+				}
+				stat.WriteString(out)
+			}
+			msg.diff, msg.summarized = colorizeStat(stat.String()), true
+			return msg
+		}
+	}
+
+	var diff strings.Builder
+	for _, p := range paths {
+		out, err := noIndexDiff(p, opts.args()...)
+		if err != nil {
+			msg.err = fmt.Errorf("%v: %s", err, strings.TrimSpace(out))
+			return msg
+		}
+		diff.WriteString(out)
+	}
+	msg.diff = opts.colorize(diff.String())
+	return msg
+}
+
+// untrackedSelected counts the selected untracked entries, which a stash takes out of
+// the working tree altogether
+func (m model) untrackedSelected() int {
+	n := 0
+	for _, f := range m.selectedFiles {
+		if f.IsUntracked {
+			n++
+		}
+	}
+	return n
+}
+
+// untrackedWarning is the stash modal's reminder that untracked files leave the
+// working tree, or "" when none are selected or they're put straight back
+func (m model) untrackedWarning() string {
+	n := m.untrackedSelected()
+	if n == 0 || m.stashScope != ScopeSelection || m.stashSnapshot {
+		return ""
+	}
+	text := fmt.Sprintf("%d untracked file(s) will be removed from the working tree; only the stash will have them.", n)
+	return removedLineStyle.Render(ansi.Wordwrap(text, 60, " ")) + "\n\n"
+}
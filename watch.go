@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Auto Refresh
+// ---------------------------------------------------------------------------
+
+// watchInterval is how often expanded Build Mode diffs are checked for changes on disk.
+// A change has to hold still for one full interval before the diff is re-fetched, so a
+// burst of saves only triggers one refresh.
+const watchInterval = time.Second
+
+// updatedIndicatorTime is how long a refreshed diff is marked as updated
+const updatedIndicatorTime = 3 * time.Second
+
+type fileMtimesMsg struct {
+	mtimes map[string]time.Time // path -> modification time; missing when the file is gone
+}
+
+// watchFiles stats the given paths after watchInterval
+func watchFiles(paths []string) tea.Cmd {
+	return tea.Tick(watchInterval, func(time.Time) tea.Msg {
+		return fileMtimesMsg{mtimes: statFiles(paths)}
+	})
+}
+
+// statFiles reads the modification times of paths, leaving out any that are gone
+func statFiles(paths []string) map[string]time.Time {
+	mtimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			mtimes[path] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// watchedPaths are the files whose diffs are currently expanded in Build Mode
+func (m model) watchedPaths() []string {
+	if m.mode != ModeBuild {
+		return nil
+	}
+	var paths []string
+	for path := range m.selectedFiles {
+		if m.expandedFiles[path] {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// refreshChangedFiles re-fetches the diffs of files whose mtime has changed and then
+// stayed put for an interval, and schedules the next check
+func (m *model) refreshChangedFiles(msg fileMtimesMsg) tea.Cmd {
+	var cmds []tea.Cmd
+	for path, mtime := range msg.mtimes {
+		file, ok := m.selectedFiles[path]
+		if !ok || !m.expandedFiles[path] {
+			continue
+		}
+		seen, ok := m.fileMtimes[path]
+		if !ok {
+			// First sighting; the diff on screen was fetched from this version
+			m.fileMtimes[path] = mtime
+			continue
+		}
+		if mtime.Equal(seen) {
+			delete(m.pendingMtimes, path)
+			continue
+		}
+		if pending, ok := m.pendingMtimes[path]; !ok || !pending.Equal(mtime) {
+			// Still being written; wait for it to settle
+			m.pendingMtimes[path] = mtime
+			continue
+		}
+		m.fileMtimes[path] = mtime
+		delete(m.pendingMtimes, path)
+		cmds = append(cmds, refreshFileDiff(file, m.fileDiffOptions()))
+	}
+
+	// Let "updated" markers expire
+	expired := false
+	for path, at := range m.updatedFiles {
+		if time.Since(at) > updatedIndicatorTime {
+			delete(m.updatedFiles, path)
+			expired = true
+		}
+	}
+	if expired {
+		m.buildViewport.SetContent(clampLines(m.buildDiffsView(), cfg.MaxLineWidth))
+	}
+
+	cmds = append(cmds, watchFiles(m.watchedPaths()))
+	return tea.Batch(cmds...)
+}
+
+// refreshFileDiff re-fetches a diff whose file changed on disk
+func refreshFileDiff(file FileChange, opts diffOptions) tea.Cmd {
+	fetch := fetchFileDiff(file, false, opts)
+	return func() tea.Msg {
+		msg := fetch().(fileDiffMsg)
+		msg.refreshed = true
+		return msg
+	}
+}
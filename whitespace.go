@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// ---------------------------------------------------------------------------
+// Whitespace
+// ---------------------------------------------------------------------------
+
+// whitespaceMode is how much whitespace-only change diffs leave out, cycled with W
+type whitespaceMode int
+
+const (
+	WhitespaceShown         whitespaceMode = iota
+	WhitespaceIgnored                      // -w
+	WhitespaceBlankLinesToo                // -w --ignore-blank-lines
+)
+
+var whitespaceModeName = map[whitespaceMode]string{
+	WhitespaceShown:         "showing whitespace changes",
+	WhitespaceIgnored:       "ignoring whitespace",
+	WhitespaceBlankLinesToo: "ignoring whitespace and blank lines",
+}
+
+// args are the git diff flags for the mode
+func (w whitespaceMode) args() []string {
+	switch w {
+	case WhitespaceIgnored:
+		return []string{"-w"}
+	case WhitespaceBlankLinesToo:
+		return []string{"-w", "--ignore-blank-lines"}
+	}
+	return nil
+}
+
+// cycleWhitespace moves on to the next whitespace mode; it holds for both modes until
+// packrat exits
+func (m *model) cycleWhitespace() {
+	m.diffOpts.Whitespace = (m.diffOpts.Whitespace + 1) % whitespaceMode(len(whitespaceModeName))
+	m.status = fmt.Sprintf("Diffs are %s", whitespaceModeName[m.diffOpts.Whitespace])
+}